@@ -0,0 +1,19 @@
+package main
+
+// Capture the dns messages:
+//   sudo dns -i eth0 -f "udp port 53"
+
+import (
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/dns"
+)
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	a := fdump.NewApp(dns.Decode, dns.Brief, dns.Detail, nil, dns.BriefAttributes())
+	a.SetPairFunc(dns.Pair)
+	a.Run()
+}
@@ -0,0 +1,19 @@
+package main
+
+// Capture the grpc calls:
+//   sudo grpc -i lo -f "tcp port 50051"
+
+import (
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/http2"
+)
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	a := fdump.NewStreamApp(http2.NewDecoderFactory(), http2.Brief, http2.Detail, nil, http2.BriefAttributes())
+	a.SetPairFunc(http2.Pair)
+	a.Run()
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/http1"
+)
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	a := fdump.NewStreamApp(http1.NewDecoderFactory(), http1.Brief, http1.Detail, nil, http1.BriefAttributes())
+	a.SetPairFunc(http1.Pair)
+	a.Run()
+}
@@ -0,0 +1,19 @@
+package main
+
+// Capture the mysql queries:
+//   sudo mysql -i lo -f "tcp port 3306" -p 3306
+
+import (
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/mysql"
+)
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	a := fdump.NewStreamApp(mysql.NewDecoderFactory(), mysql.Brief, mysql.Detail, nil, mysql.BriefAttributes())
+	a.SetPairFunc(mysql.Pair)
+	a.Run()
+}
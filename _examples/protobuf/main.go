@@ -0,0 +1,37 @@
+package main
+
+// Capture the grpc calls and decode the messages by a descriptor set:
+//   protoc --include_imports --descriptor_set_out=helloworld.pb helloworld.proto
+//   sudo protobuf -i lo -f "tcp port 50051" -d helloworld.pb
+
+import (
+	"fmt"
+	"os"
+
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/http2"
+	"github.com/tenfyzhong/fdump/protocol/protobuf"
+)
+
+var descriptorSet = ""
+
+func init() {
+	fdump.AppFlagSet.StringVar(&descriptorSet, "d", "", "FileDescriptorSet file to decode the messages by")
+}
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	schema, err := protobuf.LoadDescriptorSet(descriptorSet)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	newDecoder := schema.Decoder(http2.NewDecoderFactory(), schema.GRPCRegions)
+	a := fdump.NewStreamApp(newDecoder, http2.Brief, protobuf.DetailFunc(http2.Detail), nil, http2.BriefAttributes())
+	a.SetPairFunc(http2.Pair)
+	a.Run()
+}
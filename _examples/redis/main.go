@@ -0,0 +1,16 @@
+package main
+
+import (
+	logging "github.com/op/go-logging"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/resp"
+)
+
+func main() {
+	logging.SetLevel(logging.DEBUG, "")
+	fdump.Init()
+
+	a := fdump.NewStreamApp(resp.NewDecoder, resp.Brief, resp.Detail, nil, resp.BriefAttributes())
+	a.SetPairFunc(resp.Pair)
+	a.Run()
+}
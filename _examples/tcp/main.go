@@ -43,23 +43,12 @@ func main() {
 }
 
 func decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
-	if len(buf) < proto.HeaderLength {
-		err = fdump.ErrPkgNoEnough
+	packet, n, err := proto.DecodePacket(buf)
+	if err != nil {
 		return
 	}
 
-	header, e := proto.DecodeHeader(buf)
-	if e != nil {
-		err = e
-		return
-	}
-
-	if uint32(len(buf)) < header.Len {
-		err = fdump.ErrPkgNoEnough
-		return
-	}
-
-	n = int(header.Len)
+	header := &packet.Header
 	bodies = append(bodies, header)
 
 	var body interface{}
@@ -79,7 +68,7 @@ func decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n i
 	}
 
 	if body != nil {
-		e = proto.DecodeBody(buf[proto.HeaderLength:], body)
+		e := proto.DecodeBody(packet.Body, body)
 		if e != nil {
 			return
 		}
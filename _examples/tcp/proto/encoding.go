@@ -2,9 +2,11 @@ package proto
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/gob"
 	"errors"
+
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/layout"
 )
 
 // HeaderLength header packet length
@@ -16,50 +18,43 @@ var (
 	ErrPkgNoEnough = errors.New("pkg no enough")
 )
 
+var (
+	headerCodec = layout.MustNew(&Header{})
+	packetCodec = layout.MustNew(&Packet{})
+)
+
 // EncodeHeader encode the header use network byte order
 func EncodeHeader(header *Header) ([]byte, error) {
 	if header == nil {
 		return nil, ErrHeaderIsNil
 	}
-
-	currentIndex := 0
-	pkg := make([]byte, HeaderLength)
-	binary.BigEndian.PutUint32(pkg[currentIndex:], header.Len)
-	currentIndex += 4
-	binary.BigEndian.PutUint32(pkg[currentIndex:], header.Command)
-	currentIndex += 4
-	binary.BigEndian.PutUint64(pkg[currentIndex:], header.Maybe0)
-	currentIndex += 8
-	binary.BigEndian.PutUint32(pkg[currentIndex:], header.Maybe1)
-	currentIndex += 4
-	binary.BigEndian.PutUint32(pkg[currentIndex:], header.Result)
-	currentIndex += 4
-	pkg[currentIndex] = header.IsRequest
-	return pkg, nil
+	return headerCodec.Marshal(header)
 }
 
 // DecodeHeader decode the network byte order packet to Header
 func DecodeHeader(pkg []byte) (*Header, error) {
-	if len(pkg) < HeaderLength {
+	header := &Header{}
+	_, err := headerCodec.Unmarshal(pkg, header)
+	if err == fdump.ErrPkgNoEnough {
 		return nil, ErrPkgNoEnough
 	}
-
-	header := &Header{}
-	currentIndex := 0
-	header.Len = binary.BigEndian.Uint32(pkg[currentIndex:])
-	currentIndex += 4
-	header.Command = binary.BigEndian.Uint32(pkg[currentIndex:])
-	currentIndex += 4
-	header.Maybe0 = binary.BigEndian.Uint64(pkg[currentIndex:])
-	currentIndex += 8
-	header.Maybe1 = binary.BigEndian.Uint32(pkg[currentIndex:])
-	currentIndex += 4
-	header.Result = binary.BigEndian.Uint32(pkg[currentIndex:])
-	currentIndex += 4
-	header.IsRequest = pkg[currentIndex]
+	if err != nil {
+		return nil, err
+	}
 	return header, nil
 }
 
+// DecodePacket decode the header and the body of a packet, it returns
+// fdump.ErrPkgNoEnough if the pkg isn't a whole packet.
+func DecodePacket(pkg []byte) (packet *Packet, n int, err error) {
+	packet = &Packet{}
+	n, err = packetCodec.Unmarshal(pkg, packet)
+	if err != nil {
+		return nil, 0, err
+	}
+	return packet, n, nil
+}
+
 // EncodeBody encode body
 func EncodeBody(i interface{}) ([]byte, error) {
 	if i == nil {
@@ -95,20 +90,18 @@ func DecodeBody(body []byte, i interface{}) error {
 
 // Encode encode header and object to buffer
 func Encode(header *Header, i interface{}) ([]byte, error) {
+	if header == nil {
+		return nil, ErrHeaderIsNil
+	}
 	rspBody, err := EncodeBody(i)
 	if err != nil {
 		return nil, err
 	}
 
-	header.Len = uint32(HeaderLength + len(rspBody))
-	headerBuf, err := EncodeHeader(header)
+	buf, err := packetCodec.Marshal(&Packet{Header: *header, Body: rspBody})
 	if err != nil {
 		return nil, err
 	}
-
-	buf := make([]byte, int(header.Len))
-	copy(buf, headerBuf)
-	copy(buf[HeaderLength:], rspBody)
-
+	header.Len = uint32(len(buf))
 	return buf, nil
 }
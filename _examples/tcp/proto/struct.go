@@ -10,6 +10,12 @@ type Header struct {
 	IsRequest byte
 }
 
+// Packet the header and the body of a packet
+type Packet struct {
+	Header `fdump:"frame=Len"`
+	Body   []byte `fdump:"rest"`
+}
+
 type Command1Req struct {
 	Name string
 }
@@ -4,6 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	logging "github.com/op/go-logging"
 	"github.com/rivo/tview"
@@ -25,14 +29,35 @@ var (
 	filter   = ""
 	capacity = 0
 	lname    = ""
+	output   = ""
+	columns  = ""
+	ename    = ""
+	ports    = ""
+	tunnels  = ""
+	conns    = false
+	// the stream options
+	idleTimeout  = time.Duration(0)
+	closeTimeout = time.Duration(0)
+	maxPages     = 0
+	maxConnPages = 0
 )
 
 func init() {
-	AppFlagSet.StringVar(&iface, "i", "any", "Interface to get packet from")
+	AppFlagSet.StringVar(&iface, "i", "any", "Interface to get packet from, separate multiple interfaces by comma")
 	AppFlagSet.StringVar(&fname, "r", "", "Filename to read from, overrides -i")
 	AppFlagSet.StringVar(&filter, "f", "tcp and host localhost", "BPF filter for pcap")
 	AppFlagSet.IntVar(&capacity, "m", 65535, "Max capacity, it will remove halt of records when the size is equal to the max capacity, maximum 65535")
 	AppFlagSet.StringVar(&lname, "l", "", "Filename to load record from")
+	AppFlagSet.StringVar(&ename, "e", "", "Filename to export the records loaded by -l to and exit, pcapng format if the suffix is .pcapng, otherwise pcap")
+	AppFlagSet.StringVar(&columns, "c", "", "Built-in brief columns to show, separate by comma, available: conn,iface,latency,proto")
+	AppFlagSet.StringVar(&ports, "p", "", "Server ports to tell the client from the server if the handshake isn't captured, separate by comma")
+	AppFlagSet.StringVar(&tunnels, "x", "", "Tunnels to decapsulate before the reassembly, separate by comma, available: vxlan,gre,ipip. The vxlan port other than 4789 follows the colon, like vxlan:8472")
+	AppFlagSet.DurationVar(&idleTimeout, "idle", defaultStreamOptions.idleTimeout, "Duration to wait for the missing tcp bytes by the packet time, the bytes after them are decoded with a gap then")
+	AppFlagSet.DurationVar(&closeTimeout, "timeout", defaultStreamOptions.closeTimeout, "Duration to close the tcp streams and udp flows not seen by the packet time")
+	AppFlagSet.IntVar(&maxPages, "pages", defaultStreamOptions.maxPages, "Max pages of 1900 bytes buffered for the out of order tcp packets, 0 is unlimited")
+	AppFlagSet.IntVar(&maxConnPages, "conn-pages", defaultStreamOptions.maxConnPages, "Max pages buffered for the out of order tcp packets of a connection, 0 is unlimited")
+	AppFlagSet.BoolVar(&conns, "t", false, "Show the lifecycle records of the tcp connections, SYN, FIN, RST and complete")
+	AppFlagSet.StringVar(&output, "o", "", "Filename to write records to as JSON Lines instead of showing the tui, `-` is the stdout")
 
 	format := logging.MustStringFormatter(
 		`%{time:2006-01-02 15:04:05.000} %{level:.4s} %{shortfile}:%{shortfunc} %{message}`,
@@ -59,8 +84,9 @@ func Init() {
 
 // App the application to run
 type App struct {
-	ctrl *controller
-	view *view
+	ctrl       *controller
+	view       *view
+	newDecoder StreamDecoderFactory
 }
 
 // NewApp new an App instance.
@@ -70,7 +96,21 @@ func NewApp(
 	detailFunc DetailFunc,
 	replayHook *ReplayHook,
 	briefAttributes []*BriefColumnAttribute) *App {
-	if decodeFunc == nil || briefFunc == nil || detailFunc == nil || len(briefAttributes) == 0 {
+	if decodeFunc == nil {
+		return nil
+	}
+	return NewStreamApp(DecodeFuncFactory(decodeFunc), briefFunc, detailFunc, replayHook, briefAttributes)
+}
+
+// NewStreamApp new an App instance which decode every tcp stream and udp flow
+// by a StreamDecoder created by the newDecoder.
+func NewStreamApp(
+	newDecoder StreamDecoderFactory,
+	briefFunc BriefFunc,
+	detailFunc DetailFunc,
+	replayHook *ReplayHook,
+	briefAttributes []*BriefColumnAttribute) *App {
+	if newDecoder == nil || briefFunc == nil || detailFunc == nil || len(briefAttributes) == 0 {
 		return nil
 	}
 
@@ -78,24 +118,125 @@ func NewApp(
 		iface = "any"
 	}
 
+	serverPorts, err := parsePorts(ports)
+	if err != nil {
+		log.Errorf("parse server ports failed, err: %v", err)
+		return nil
+	}
+	decapTunnels, err := parseTunnels(tunnels)
+	if err != nil {
+		log.Errorf("parse tunnels failed, err: %v", err)
+		return nil
+	}
+
 	snaplen := 65535
 	tapp := tview.NewApplication()
 	a := &App{
-		ctrl: newController(iface, fname, snaplen, filter, decodeFunc),
+		ctrl: newController(iface, fname, snaplen, filter, newDecoder),
 		view: newView(
 			tapp,
 			capacity,
 			briefFunc,
 			detailFunc,
-			decodeFunc,
+			newDecoder,
 			replayHook,
 			briefAttributes),
+		newDecoder: newDecoder,
+	}
+	a.ctrl.SetServerPorts(serverPorts)
+	a.ctrl.SetTunnels(decapTunnels)
+	a.view.statsFunc = a.ctrl.Stats
+	a.ctrl.SetStreamOptions(streamOptions{
+		idleTimeout:  idleTimeout,
+		closeTimeout: closeTimeout,
+		maxPages:     maxPages,
+		maxConnPages: maxConnPages,
+	})
+	a.ctrl.SetLifecycle(conns)
+
+	for _, name := range strings.Split(columns, ",") {
+		if column, ok := builtinColumns[strings.TrimSpace(name)]; ok {
+			a.view.addColumn(column)
+		}
 	}
+	if fname == "" && strings.Contains(iface, ",") {
+		// distinguish the interfaces if capture from several interfaces
+		a.view.addColumn(ifaceColumn)
+	}
+
 	return a
 }
 
+// NewRegistryApp new an App instance which dispatch every tcp stream and udp
+// flow to a protocol of the registry. The brief view shows the protocol
+// column and the titles of the selected record protocol.
+func NewRegistryApp(registry *Registry, replayHook *ReplayHook) *App {
+	if registry == nil || len(registry.Protocols()) == 0 {
+		return nil
+	}
+
+	a := NewStreamApp(
+		registry.NewDecoder,
+		registry.Brief,
+		registry.Detail,
+		replayHook,
+		registry.briefAttributes())
+	if a == nil {
+		return nil
+	}
+	a.view.setLayouts(registry.layouts())
+	a.view.addColumn(protocolColumn)
+	if registry.hasPair() {
+		a.SetPairFunc(registry.Pair)
+	}
+	return a
+}
+
+// SetPacketSource read packets from the source instead of the pcap handle
+// opened by the command line flags. Call it before Run.
+func (a *App) SetPacketSource(source PacketSource) {
+	a.ctrl.SetSource(source)
+}
+
+// SetResyncFunc set the policy to recover a tcp stream from a decode error.
+// The default policy drops the buffered bytes of the stream. Call it before
+// Run.
+func (a *App) SetResyncFunc(resyncFunc ResyncFunc) {
+	a.ctrl.factory.resync = resyncFunc
+}
+
+// SetPairFunc pair every response with its request by the function, and show
+// the latency in the brief view. Call it before Run.
+func (a *App) SetPairFunc(pairFunc PairFunc) {
+	a.ctrl.SetPairFunc(pairFunc)
+	a.view.pairFunc = pairFunc
+	a.view.addColumn(latencyColumn)
+}
+
+// DefragStats returns the counters of the ip fragment reassembly, such as
+// the datagrams waiting for their fragments and the datagrams timed out.
+func (a *App) DefragStats() DefragStats {
+	return a.ctrl.defrag.Stats()
+}
+
+// Stats returns the capture statistics, such as the packets dropped by the
+// kernel and the records waiting to be shown.
+func (a *App) Stats() Stats {
+	return a.ctrl.Stats()
+}
+
 // Run begin work. It will block the goroutine
 func (a *App) Run() {
+	if lname != "" && ename != "" {
+		a.export()
+		return
+	}
+
+	if output != "" {
+		a.runHeadless()
+		return
+	}
+
 	a.ctrl.AddUpdateFunc(a.view.Update)
 
 	err := a.ctrl.Init()
@@ -106,6 +247,7 @@ func (a *App) Run() {
 	go a.ctrl.Run()
 
 	a.view.Init()
+	go a.refreshStats()
 	if lname != "" {
 		a.view.toggle(bitStop)
 		a.view.loadFile(lname)
@@ -115,3 +257,61 @@ func (a *App) Run() {
 		panic(err)
 	}
 }
+
+// refreshStats show the stats in the status bar every second.
+func (a *App) refreshStats() {
+	for range time.Tick(time.Second) {
+		a.view.UpdateStats(a.ctrl.Stats())
+	}
+}
+
+// export export the loaded records to a pcap/pcapng file.
+func (a *App) export() {
+	records, err := deserialize(lname, a.newDecoder)
+	if err != nil {
+		panic(err)
+	}
+	err = exportPcap(records, ename)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// runHeadless write the records as JSON Lines without the tui. It returns
+// when the packets are exhausted or the process is interrupted.
+func (a *App) runHeadless() {
+	w, err := newJSONWriter(output)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	if lname != "" {
+		records, err := deserialize(lname, a.newDecoder)
+		if err != nil {
+			panic(err)
+		}
+		pairRecords(records, a.view.pairFunc)
+		for _, record := range records {
+			w.Update(record)
+		}
+		return
+	}
+
+	a.ctrl.AddUpdateFunc(w.Update)
+
+	err = a.ctrl.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		a.ctrl.Stop()
+	}()
+
+	a.ctrl.Run()
+	a.ctrl.Close()
+}
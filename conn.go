@@ -0,0 +1,242 @@
+package fdump
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Direction the direction of a record in its connection
+type Direction int
+
+// the directions of the records
+const (
+	DirectionUnknown Direction = iota
+	DirectionClientToServer
+	DirectionServerToClient
+)
+
+var directionNames = []string{"unknown", "client->server", "server->client"}
+
+func (d Direction) String() string {
+	if int(d) < 0 || int(d) >= len(directionNames) {
+		return "unknown"
+	}
+	return directionNames[d]
+}
+
+// Reverse returns the opposite direction
+func (d Direction) Reverse() Direction {
+	switch d {
+	case DirectionClientToServer:
+		return DirectionServerToClient
+	case DirectionServerToClient:
+		return DirectionClientToServer
+	}
+	return DirectionUnknown
+}
+
+// DirectionSetter can be implemented by a StreamDecoder to know the
+// connection and the direction of the stream. It's called once after the
+// decoder is created.
+type DirectionSetter interface {
+	SetDirection(connID uint64, direction Direction)
+}
+
+// connTimeout the connection will be forgotten if it's not seen in the
+// duration, or in the close timeout of the streams if it's longer.
+const connTimeout = 2 * time.Minute
+
+// conn a tcp connection or an udp conversation. The net and transport are
+// the flows of the first packet, the direction is the direction of them.
+type conn struct {
+	id        uint64
+	net       gopacket.Flow
+	transport gopacket.Flow
+	direction Direction
+	lastSeen  time.Time
+	bytes     int    // the payload bytes of both directions
+	messages  int    // the decoded records of both directions
+	packets   int    // the packets of both directions
+	isn       uint32 // the sequence number of the SYN
+	closed    bool   // a FIN or RST is seen
+}
+
+// connTracker find out the client and the server of the connections by the
+// tcp handshake or the server ports. The first sender of an udp conversation
+// is the client if the ports don't tell.
+type connTracker struct {
+	serverPorts map[uint16]bool
+	conns       map[[2]gopacket.Flow]*conn
+	lastID      uint64
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		serverPorts: make(map[uint16]bool),
+		conns:       make(map[[2]gopacket.Flow]*conn),
+	}
+}
+
+// track returns the connection and the direction of the packet. The tcp is
+// nil for the udp packets.
+func (t *connTracker) track(net, transport gopacket.Flow, tcp *layers.TCP, seen time.Time) (*conn, Direction) {
+	key := [2]gopacket.Flow{net, transport}
+	reverseKey := [2]gopacket.Flow{net.Reverse(), transport.Reverse()}
+
+	syn := tcp != nil && tcp.SYN && !tcp.ACK
+	c, ok := t.conns[key]
+	if !ok && !syn {
+		c, ok = t.conns[reverseKey]
+	}
+	// a SYN begins a new connection if the ports are reused, a retransmitted
+	// SYN has the same sequence number
+	if !ok || (syn && (c.isn != tcp.Seq || c.closed)) {
+		delete(t.conns, reverseKey)
+		c = t.newConn(net, transport, tcp)
+		t.conns[key] = c
+	}
+	c.lastSeen = seen
+	c.packets++
+	if tcp != nil && (tcp.FIN || tcp.RST) {
+		c.closed = true
+	}
+
+	if c.direction == DirectionUnknown && tcp != nil && tcp.SYN && tcp.ACK {
+		// picked up after the SYN, the SYN-ACK is from the server
+		c.direction = DirectionServerToClient
+		if c.net != net {
+			c.direction = DirectionClientToServer
+		}
+	}
+
+	if c.net == net && c.transport == transport {
+		return c, c.direction
+	}
+	return c, c.direction.Reverse()
+}
+
+func (t *connTracker) newConn(net, transport gopacket.Flow, tcp *layers.TCP) *conn {
+	t.lastID++
+	c := &conn{
+		id:        t.lastID,
+		net:       net,
+		transport: transport,
+	}
+
+	switch {
+	case tcp != nil && tcp.SYN && !tcp.ACK:
+		c.direction = DirectionClientToServer
+		c.isn = tcp.Seq
+	case tcp != nil && tcp.SYN && tcp.ACK:
+		c.direction = DirectionServerToClient
+	default:
+		c.direction = t.portDirection(transport)
+		if c.direction == DirectionUnknown && tcp == nil {
+			c.direction = DirectionClientToServer
+		}
+	}
+	return c
+}
+
+// portDirection returns the direction by the server ports
+func (t *connTracker) portDirection(transport gopacket.Flow) Direction {
+	src, dst, err := transportPorts(transport)
+	if err != nil {
+		return DirectionUnknown
+	}
+	switch {
+	case t.serverPorts[dst] && !t.serverPorts[src]:
+		return DirectionClientToServer
+	case t.serverPorts[src] && !t.serverPorts[dst]:
+		return DirectionServerToClient
+	}
+	return DirectionUnknown
+}
+
+// expire forget the connections not seen since the time
+func (t *connTracker) expire(since time.Time) {
+	for key, c := range t.conns {
+		if c.lastSeen.Before(since) {
+			delete(t.conns, key)
+		}
+	}
+}
+
+// parsePorts parse the ports separated by comma
+func parsePorts(s string) ([]uint16, error) {
+	ports := make([]uint16, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(item, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
+
+// ConnEventType the type of a connection lifecycle event
+type ConnEventType int
+
+// the connection lifecycle events
+const (
+	ConnEventSYN ConnEventType = iota
+	ConnEventFIN
+	ConnEventRST
+	ConnEventComplete
+)
+
+var connEventTypeNames = []string{"SYN", "FIN", "RST", "COMPLETE"}
+
+func (t ConnEventType) String() string {
+	if int(t) < 0 || int(t) >= len(connEventTypeNames) {
+		return "UNKNOWN"
+	}
+	return connEventTypeNames[t]
+}
+
+// MarshalJSON marshal the event type as its name
+func (t ConnEventType) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(t.String())), nil
+}
+
+// ConnEvent the body of a RecordTypeConn record. The Bytes and Messages are
+// the payload bytes and the decoded records the connection carried before
+// the event, of both directions.
+type ConnEvent struct {
+	Event    ConnEventType `json:"event"`
+	Bytes    int           `json:"bytes"`
+	Messages int           `json:"messages"`
+}
+
+func (e *ConnEvent) String() string {
+	return fmt.Sprintf("%v, %d bytes, %d messages", e.Event, e.Bytes, e.Messages)
+}
+
+// connRecord create a lifecycle record of the connection
+func connRecord(c *conn, event ConnEventType, net, transport gopacket.Flow, direction Direction, seen time.Time) *Record {
+	record := &Record{
+		Type:      RecordTypeConn,
+		Net:       net,
+		Transport: transport,
+		Seen:      seen,
+		Direction: direction,
+	}
+	e := &ConnEvent{Event: event}
+	if c != nil {
+		record.ConnID = c.id
+		e.Bytes = c.bytes
+		e.Messages = c.messages
+	}
+	record.Bodies = []interface{}{e}
+	return record
+}
@@ -0,0 +1,197 @@
+package fdump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnTrackerHandshake(t *testing.T) {
+	tracker := newConnTracker()
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	now := time.Now()
+
+	c, dir := tracker.track(netFlow.Reverse(), transportFlow.Reverse(), &layers.TCP{SYN: true}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	c, dir = tracker.track(netFlow, transportFlow, &layers.TCP{SYN: true, ACK: true}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+	c, dir = tracker.track(netFlow, transportFlow, &layers.TCP{ACK: true}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+
+	// the ports are reused by a new connection
+	c, dir = tracker.track(netFlow.Reverse(), transportFlow.Reverse(), &layers.TCP{SYN: true, Seq: 1000}, now)
+	assert.Equal(t, uint64(2), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	c, dir = tracker.track(netFlow, transportFlow, &layers.TCP{ACK: true}, now)
+	assert.Equal(t, uint64(2), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+}
+
+func TestConnTrackerSYNRetransmit(t *testing.T) {
+	tracker := newConnTracker()
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	now := time.Now()
+
+	c, dir := tracker.track(netFlow, transportFlow, &layers.TCP{SYN: true, Seq: 100}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	// the SYN is retransmitted
+	c, dir = tracker.track(netFlow, transportFlow, &layers.TCP{SYN: true, Seq: 100}, now.Add(time.Second))
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	c, dir = tracker.track(netFlow.Reverse(), transportFlow.Reverse(), &layers.TCP{SYN: true, ACK: true, Seq: 500}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+	c, _ = tracker.track(netFlow, transportFlow, &layers.TCP{ACK: true, FIN: true, Seq: 101}, now)
+	assert.Equal(t, uint64(1), c.id)
+
+	// the same sequence number after the connection is closed
+	c, _ = tracker.track(netFlow, transportFlow, &layers.TCP{SYN: true, Seq: 100}, now)
+	assert.Equal(t, uint64(2), c.id)
+}
+
+func TestConnTrackerServerPorts(t *testing.T) {
+	tracker := newConnTracker()
+	ports, err := parsePorts("20001, 6379")
+	assert.NoError(t, err)
+	for _, port := range ports {
+		tracker.serverPorts[port] = true
+	}
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	now := time.Now()
+
+	// picked up in the middle of the stream
+	c, dir := tracker.track(netFlow, transportFlow, &layers.TCP{ACK: true}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	c, dir = tracker.track(netFlow.Reverse(), transportFlow.Reverse(), &layers.TCP{ACK: true}, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+
+	delete(tracker.serverPorts, 20001)
+	tracker.expire(now.Add(time.Second))
+	c, dir = tracker.track(netFlow, transportFlow, &layers.TCP{ACK: true}, now)
+	assert.Equal(t, uint64(2), c.id)
+	assert.Equal(t, DirectionUnknown, dir)
+
+	_, err = parsePorts("80,http")
+	assert.Error(t, err)
+}
+
+func TestConnTrackerUDP(t *testing.T) {
+	tracker := newConnTracker()
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointUDPPort)
+	now := time.Now()
+
+	c, dir := tracker.track(netFlow, transportFlow, nil, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionClientToServer, dir)
+	c, dir = tracker.track(netFlow.Reverse(), transportFlow.Reverse(), nil, now)
+	assert.Equal(t, uint64(1), c.id)
+	assert.Equal(t, DirectionServerToClient, dir)
+}
+
+type testDirectionDecoder struct {
+	DecodeFunc
+	direction Direction
+}
+
+func (d *testDirectionDecoder) SetDirection(connID uint64, direction Direction) {
+	d.direction = direction
+}
+
+func TestControllerDirection(t *testing.T) {
+	decoders := make([]*testDirectionDecoder, 0)
+	c := newController("", "", 65535, "", func(net, transport gopacket.Flow) StreamDecoder {
+		d := &testDirectionDecoder{DecodeFunc: testDecodeFunc}
+		decoders = append(decoders, d)
+		return d
+	})
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 500, SYN: true, ACK: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true}, []byte("abcdefghij")),
+	}
+
+	records := runTestController(t, c, packets)
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, DirectionClientToServer, records[0].Direction)
+	assert.Equal(t, DirectionServerToClient, records[1].Direction)
+	assert.Equal(t, records[0].ConnID, records[1].ConnID)
+	assert.NotZero(t, records[0].ConnID)
+	if assert.Len(t, decoders, 2) {
+		assert.Equal(t, DirectionClientToServer, decoders[0].direction)
+		assert.Equal(t, DirectionServerToClient, decoders[1].direction)
+	}
+}
+
+func TestControllerLifecycle(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	c.SetLifecycle(true)
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 500, SYN: true, ACK: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true, RST: true}, nil),
+	}
+
+	records := runTestController(t, c, packets)
+	events := make([]string, 0)
+	for _, r := range records {
+		if r.Type != RecordTypeConn {
+			events = append(events, r.Type.String())
+			continue
+		}
+		e := r.Bodies[0].(*ConnEvent)
+		events = append(events, e.String())
+		assert.Equal(t, records[0].ConnID, r.ConnID)
+	}
+	assert.Equal(t, []string{
+		"SYN, 0 bytes, 0 messages",
+		"tcp",
+		"RST, 10 bytes, 1 messages",
+		"COMPLETE, 10 bytes, 1 messages",
+		"COMPLETE, 10 bytes, 1 messages",
+	}, events)
+}
+
+func TestControllerSYNRetransmit(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	c.SetLifecycle(true)
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts.Add(time.Second), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts.Add(time.Second), &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 500, SYN: true, ACK: true}, nil),
+		testTCPPacket(t, ts.Add(time.Second), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testTCPPacket(t, ts.Add(time.Second), &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true}, []byte("abcdefghij")),
+	}
+
+	records := runTestController(t, c, packets)
+	syns := 0
+	for _, r := range records {
+		assert.Equal(t, records[0].ConnID, r.ConnID)
+		if r.Type == RecordTypeConn && r.Bodies[0].(*ConnEvent).Event == ConnEventSYN {
+			syns++
+		}
+	}
+	assert.Equal(t, 1, syns)
+	directions := make([]Direction, 0)
+	for _, r := range records {
+		if r.Type == RecordTypeTCP {
+			directions = append(directions, r.Direction)
+		}
+	}
+	assert.Equal(t, []Direction{DirectionClientToServer, DirectionServerToClient}, directions)
+}
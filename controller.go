@@ -1,6 +1,7 @@
 package fdump
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -11,18 +12,60 @@ import (
 
 type updateFunc func(record *Record)
 
+// flushInterval the interval of the packet time to flush the idle streams.
+const flushInterval = time.Second
+
+// streamOptions the timeouts and the buffer limits of the tcp reassembly.
+type streamOptions struct {
+	// idleTimeout the missing bytes of a tcp stream are skipped if they
+	// don't arrive in it, the buffered bytes after them are decoded.
+	idleTimeout time.Duration
+	// closeTimeout the tcp streams and the udp flows not seen in it are
+	// closed.
+	closeTimeout time.Duration
+	// maxPages and maxConnPages the max pages buffered while waiting for
+	// the out of order packets, in total and per connection, unlimited if
+	// they are 0. A page holds up to 1900 bytes.
+	maxPages     int
+	maxConnPages int
+}
+
+var defaultStreamOptions = streamOptions{
+	idleTimeout:  2 * time.Second,
+	closeTimeout: 2 * time.Minute,
+	maxPages:     65536,
+	maxConnPages: 4096,
+}
+
 type controller struct {
 	iface       string
 	fname       string
 	snaplen     int
 	filter      string
-	handle      *pcap.Handle
+	source      PacketSource
+	handles     []*pcap.Handle // the live handles to get the stats from
 	factory     *streamFactory
+	udpFlows    map[[2]gopacket.Flow]*udpFlow
+	conns       *connTracker
+	defrag      *defragmenter
+	tunnels     *tunnelConfig
+	options     streamOptions
+	lifecycle   bool
 	msgChan     chan *Record
 	updateFuncs []updateFunc
+	pairer      *pairer
+	stats       *statsCollector
+	stop        chan struct{}
+	consumed    chan struct{}
+
+	// the streams are flushed by the packet time, it works for the offline
+	// files too
+	latest  time.Time // the latest packet time
+	flushed time.Time // the packet time of the last flush
+	arrival time.Time // the wall time the latest packet arrived
 }
 
-func newController(iface string, fname string, snaplen int, filter string, decodeFunc DecodeFunc) *controller {
+func newController(iface string, fname string, snaplen int, filter string, newDecoder StreamDecoderFactory) *controller {
 	log.Infof("iface: %s, snaplen: %d, filter: %s\n", iface, snaplen, filter)
 	msgChan := make(chan *Record, 1000)
 	c := &controller{
@@ -31,40 +74,128 @@ func newController(iface string, fname string, snaplen int, filter string, decod
 		snaplen:     snaplen,
 		filter:      filter,
 		msgChan:     msgChan,
-		factory:     newStreamFactory(msgChan, decodeFunc),
+		factory:     newStreamFactory(msgChan, newDecoder),
+		udpFlows:    make(map[[2]gopacket.Flow]*udpFlow),
+		conns:       newConnTracker(),
+		defrag:      newDefragmenter(),
+		options:     defaultStreamOptions,
+		stats:       newStatsCollector(),
 		updateFuncs: make([]updateFunc, 0, 1),
+		stop:        make(chan struct{}),
+		consumed:    make(chan struct{}),
 	}
 	return c
 }
 
 func (c *controller) Init() error {
-	var handle *pcap.Handle
-	var err error
-
-	if fname != "" {
-		handle, err = pcap.OpenOffline(c.fname)
-	} else {
-		handle, err = pcap.OpenLive(
-			c.iface,
+	if c.source == nil {
+		err := c.openPcap()
+		if err != nil {
+			return err
+		}
+	}
+
+	go c.consumeMsg()
+
+	return nil
+}
+
+// SetSource use the source instead of pcap. Call it before Init.
+func (c *controller) SetSource(source PacketSource) {
+	c.source = source
+}
+
+func (c *controller) openPcap() error {
+	if c.fname != "" {
+		handle, err := pcap.OpenOffline(c.fname)
+		if err != nil {
+			log.Errorf("OpenOffline failed, err: %+v", err)
+			return err
+		}
+		if err := c.setFilter(handle); err != nil {
+			return err
+		}
+		c.source = newPcapSource(handle)
+		return nil
+	}
+
+	// every interface has its own handle, the packets are merged by the
+	// timestamp
+	ifaces := strings.Split(c.iface, ",")
+	sources := make([]PacketSource, 0, len(ifaces))
+	for _, iface := range ifaces {
+		handle, err := pcap.OpenLive(
+			iface,
 			int32(c.snaplen),
 			true,
 			pcap.BlockForever)
+		if err == nil {
+			err = c.setFilter(handle)
+		}
+		if err != nil {
+			log.Errorf("OpenLive %s failed, err: %+v", iface, err)
+			for _, source := range sources {
+				source.Close()
+			}
+			return err
+		}
+		sources = append(sources, newPcapSource(handle))
+		c.handles = append(c.handles, handle)
 	}
 
-	if err != nil {
-		log.Errorf("OpenLive failed, err: %+v", err)
-		return err
-	}
+	c.source = newMergeSource(sources, ifaces)
+	return nil
+}
 
+func (c *controller) setFilter(handle *pcap.Handle) error {
 	if err := handle.SetBPFFilter(c.filter); err != nil {
 		log.Errorf("set bpf filter failed, err: %+v", err)
+		handle.Close()
 		return err
 	}
+	return nil
+}
 
-	c.handle = handle
-	go c.consumeMsg()
+// SetServerPorts tell the client from the server by the ports if the tcp
+// handshake isn't captured. Call it before Run.
+func (c *controller) SetServerPorts(ports []uint16) {
+	for _, port := range ports {
+		c.conns.serverPorts[port] = true
+	}
+}
 
-	return nil
+// SetLifecycle send the lifecycle records of the tcp connections. Call it
+// before Run.
+func (c *controller) SetLifecycle(lifecycle bool) {
+	c.lifecycle = lifecycle
+	c.factory.lifecycle = lifecycle
+}
+
+// SetTunnels decapsulate the tunnels before the reassembly, the records are
+// decoded from the inner packets. Call it before Run.
+func (c *controller) SetTunnels(tunnels *tunnelConfig) {
+	c.tunnels = tunnels
+}
+
+// SetStreamOptions set the timeouts and the buffer limits of the tcp
+// reassembly, the zero timeouts are the default. Call it before Run.
+func (c *controller) SetStreamOptions(options streamOptions) {
+	if options.idleTimeout <= 0 {
+		options.idleTimeout = defaultStreamOptions.idleTimeout
+	}
+	if options.closeTimeout <= 0 {
+		options.closeTimeout = defaultStreamOptions.closeTimeout
+	}
+	if options.closeTimeout < options.idleTimeout {
+		options.closeTimeout = options.idleTimeout
+	}
+	c.options = options
+}
+
+// SetPairFunc pair the requests and responses by the function. Call it
+// before Init.
+func (c *controller) SetPairFunc(pairFunc PairFunc) {
+	c.pairer = newPairer(pairFunc)
 }
 
 func (c *controller) AddUpdateFunc(f updateFunc) {
@@ -72,7 +203,12 @@ func (c *controller) AddUpdateFunc(f updateFunc) {
 }
 
 func (c *controller) consumeMsg() {
+	defer close(c.consumed)
 	for msg := range c.msgChan {
+		c.stats.record(msg)
+		if c.pairer != nil && msg.Err == nil && msg.Type != RecordTypeConn {
+			c.pairer.pair(msg)
+		}
 		for _, f := range c.updateFuncs {
 			f(msg)
 		}
@@ -82,18 +218,40 @@ func (c *controller) consumeMsg() {
 func (c *controller) Run() {
 	streamPool := tcpassembly.NewStreamPool(c.factory)
 	assembler := tcpassembly.NewAssembler(streamPool)
+	assembler.MaxBufferedPagesTotal = c.options.maxPages
+	assembler.MaxBufferedPagesPerConnection = c.options.maxConnPages
 	log.Infof("reading in packets")
 
-	packetSource := gopacket.NewPacketSource(c.handle, c.handle.LinkType())
-	packets := packetSource.Packets()
-	ticker := time.Tick(60 * time.Second)
+	packets := c.source.Packets()
+	ticker := time.Tick(flushInterval)
+	defer func() {
+		c.stats.sample(time.Now(), c.handles, assemblerPages(assembler))
+	}()
 	for {
 		select {
 		case packet := <-packets:
 			if packet == nil {
 				log.Errorf("get a nil packet")
+				assembler.FlushAll()
+				c.closeUDPFlows(time.Time{})
 				return
 			}
+			c.stats.packet()
+			c.advance(assembler, packet.Metadata().Timestamp)
+
+			// the transport layer is in the first fragment only
+			packet = c.defrag.defrag(packet)
+			if packet == nil {
+				continue
+			}
+			packet, tunnel := decapsulate(packet, c.tunnels)
+			if tunnel != nil {
+				// the inner datagram may be fragmented too
+				packet = c.defrag.defrag(packet)
+				if packet == nil {
+					continue
+				}
+			}
 
 			if packet.NetworkLayer() == nil ||
 				packet.TransportLayer() == nil {
@@ -102,55 +260,256 @@ func (c *controller) Run() {
 
 			switch packet.TransportLayer().LayerType() {
 			case layers.LayerTypeTCP:
-				c.assembleTCP(assembler, packet)
+				c.assembleTCP(assembler, packet, tunnel)
 			case layers.LayerTypeUDP:
-				c.assembleUDP(packet)
+				c.assembleUDP(packet, tunnel)
 			}
-		case <-ticker:
-			assembler.FlushWithOptions(tcpassembly.FlushOptions{
-				T:        time.Now().Add(time.Minute * -2),
-				CloseAll: false,
-			})
+		case now := <-ticker:
+			c.stats.sample(now, c.handles, assemblerPages(assembler))
+			// no packet arrived in a while, the packet time goes on with the
+			// wall time, so the streams of a live capture still idle out
+			if idle := now.Sub(c.arrival); !c.latest.IsZero() && idle >= flushInterval {
+				c.advance(assembler, c.latest.Add(idle))
+			}
+		case <-c.stop:
+			assembler.FlushAll()
+			c.closeUDPFlows(time.Time{})
+			return
 		}
 	}
 }
 
-func (c *controller) assembleTCP(assembler *tcpassembly.Assembler, packet gopacket.Packet) {
+// advance move the packet time to t, the idle streams are flushed every
+// flushInterval of the packet time.
+func (c *controller) advance(assembler *tcpassembly.Assembler, t time.Time) {
+	c.arrival = time.Now()
+	if !t.After(c.latest) {
+		return
+	}
+	c.latest = t
+	if c.latest.Sub(c.flushed) < flushInterval {
+		return
+	}
+	c.flushed = c.latest
+	c.flush(assembler)
+}
+
+// flush skip the missing bytes of the streams idle for the idle timeout,
+// close the streams and flows not seen for the close timeout.
+func (c *controller) flush(assembler *tcpassembly.Assembler) {
+	flushed, _ := assembler.FlushWithOptions(tcpassembly.FlushOptions{
+		T: c.latest.Add(-c.options.idleTimeout),
+	})
+	_, closed := assembler.FlushWithOptions(tcpassembly.FlushOptions{
+		T:        c.latest.Add(-c.options.closeTimeout),
+		CloseAll: true,
+	})
+	if flushed > 0 || closed > 0 {
+		log.Debugf("flush streams, flushed: %d, closed: %d", flushed, closed)
+	}
+	c.closeUDPFlows(c.latest.Add(-c.options.closeTimeout))
+	// the stream of a connection lives for the close timeout
+	timeout := connTimeout
+	if c.options.closeTimeout > timeout {
+		timeout = c.options.closeTimeout
+	}
+	c.conns.expire(c.latest.Add(-timeout))
+	c.defrag.expire()
+}
+
+// Stop make the Run return.
+func (c *controller) Stop() {
+	close(c.stop)
+}
+
+// Close wait all the records to be consumed and close the source. Call it
+// after Run returned.
+func (c *controller) Close() {
+	close(c.msgChan)
+	<-c.consumed
+	c.source.Close()
+	log.Infof("stats: %s", c.Stats().Summary())
+}
+
+// Stats returns the capture statistics.
+func (c *controller) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.Pending = len(c.msgChan)
+	stats.Capacity = cap(c.msgChan)
+	stats.Defrag = c.defrag.Stats()
+	return stats
+}
+
+func (c *controller) assembleTCP(assembler *tcpassembly.Assembler, packet gopacket.Packet, tunnel *Tunnel) {
 	tcp := packet.TransportLayer().(*layers.TCP)
+	netFlow := packet.NetworkLayer().NetworkFlow()
+	conn, direction := c.conns.track(netFlow, tcp.TransportFlow(), tcp, packet.Metadata().Timestamp)
+	seq := tcp.Seq
+	if tcp.SYN {
+		// the payload begins after the SYN
+		seq++
+	}
+	c.factory.current = packetMeta{
+		iface:     packetInterface(packet),
+		tunnel:    tunnel,
+		conn:      conn,
+		direction: direction,
+		net:       netFlow,
+		transport: tcp.TransportFlow(),
+		seq:       seq,
+		capture:   packet.Metadata().CaptureInfo,
+		truncated: packetTruncated(packet),
+	}
+	start := c.startStream(netFlow, tcp)
+	// the event goes first, the stream completes in the assembling if it's
+	// a FIN or RST
+	if c.lifecycle {
+		c.tcpEvent(conn, netFlow, tcp, direction, packet, tunnel)
+	}
 	assembler.AssembleWithTimestamp(
-		packet.NetworkLayer().NetworkFlow(),
-		tcp,
+		netFlow,
+		start,
 		packet.Metadata().Timestamp)
-	assembler.FlushWithOptions(tcpassembly.FlushOptions{
-		T:        time.Now(),
-		CloseAll: false,
-	})
 }
 
-func (c *controller) assembleUDP(packet gopacket.Packet) {
+// startStream returns the tcp layer to assemble. The assembler buffers the
+// first packet of a stream picked up in the middle until a flush, it's
+// assembled as a SYN instead, so only the real gaps wait for the idle
+// timeout. The stream marks the start unknown.
+func (c *controller) startStream(netFlow gopacket.Flow, tcp *layers.TCP) *layers.TCP {
+	if tcp.SYN || len(tcp.Payload) == 0 {
+		// the assembler ignores the empty packet
+		return tcp
+	}
+	if c.factory.streams[[2]gopacket.Flow{netFlow, tcp.TransportFlow()}] {
+		return tcp
+	}
+	c.factory.current.pickup = true
+	start := *tcp
+	start.SYN = true
+	start.Seq--
+	return &start
+}
+
+// tcpEvent send the lifecycle record if the packet has the SYN, FIN or RST
+// flag. The SYN-ACK isn't a new connection, it's ignored.
+func (c *controller) tcpEvent(conn *conn, netFlow gopacket.Flow, tcp *layers.TCP, direction Direction, packet gopacket.Packet, tunnel *Tunnel) {
+	var event ConnEventType
+	switch {
+	case tcp.RST:
+		event = ConnEventRST
+	case tcp.FIN:
+		event = ConnEventFIN
+	case tcp.SYN && !tcp.ACK && conn.packets == 1:
+		// the retransmitted SYN isn't a new connection
+		event = ConnEventSYN
+	default:
+		return
+	}
+	record := connRecord(conn, event, netFlow, tcp.TransportFlow(), direction, packet.Metadata().Timestamp)
+	record.Interface = packetInterface(packet)
+	record.Tunnel = tunnel
+	c.msgChan <- record
+}
+
+func (c *controller) assembleUDP(packet gopacket.Packet, tunnel *Tunnel) {
 	udp := packet.TransportLayer().(*layers.UDP)
 	netFlow := packet.NetworkLayer().NetworkFlow()
 	transportFlow := udp.TransportFlow()
 	payload := udp.Payload
 
-	bodies, _, err := c.factory.decodeFunc(netFlow, transportFlow, payload)
-	if err != nil {
-		log.Debugf("unpack err: %+v", err)
-		return
+	conn, direction := c.conns.track(netFlow, transportFlow, nil, packet.Metadata().Timestamp)
+	conn.bytes += len(payload)
+	flow := c.udpFlow(netFlow, transportFlow, conn.id, direction)
+	flow.lastSeen = packet.Metadata().Timestamp
+
+	send := func(bodies []interface{}, buf []byte, err error) {
+		r := &Record{
+			Type:      RecordTypeUDP,
+			Bodies:    bodies,
+			Net:       netFlow,
+			Transport: transportFlow,
+			Seen:      packet.Metadata().Timestamp,
+			Buffer:    buf,
+			Interface: packetInterface(packet),
+			Tunnel:    tunnel,
+			Protocol:  decoderProtocol(flow.decoder),
+			ConnID:    conn.id,
+			Direction: direction,
+			Err:       err,
+
+			Length:        packet.Metadata().Length,
+			CaptureLength: packet.Metadata().CaptureLength,
+			Truncated:     packetTruncated(packet),
+		}
+		if err == nil {
+			conn.messages++
+		}
+		c.msgChan <- r
 	}
 
-	if len(bodies) == 0 {
-		return
+	// a datagram may carry several messages, decode them one by one like a
+	// tcp stream, but the rest of a message never comes
+	buf := payload
+	for len(buf) > 0 {
+		bodies, n, err := flow.decoder.Decode(netFlow, transportFlow, buf)
+		if err == ErrPkgNoEnough {
+			send(nil, buf, ErrUDPTruncated)
+			return
+		}
+		if err != nil {
+			log.Debugf("unpack err: %+v", err)
+			send(nil, buf, err)
+			return
+		}
+		if n <= 0 || n > len(buf) {
+			if len(bodies) == 0 {
+				send(nil, buf, ErrUDPLeftover)
+				return
+			}
+			// the decoder doesn't tell the consumed bytes, the message is
+			// the whole rest of the datagram
+			n = len(buf)
+		}
+
+		used := buf[:n]
+		buf = buf[n:]
+		if len(bodies) == 0 {
+			continue
+		}
+		send(bodies, used, nil)
 	}
+}
+
+// udpFlow the decoder of an udp flow
+type udpFlow struct {
+	decoder  StreamDecoder
+	lastSeen time.Time
+}
 
-	r := &Record{
-		Type:      RecordTypeUDP,
-		Bodies:    bodies,
-		Net:       netFlow,
-		Transport: transportFlow,
-		Seen:      time.Now(),
-		Buffer:    payload,
+func (c *controller) udpFlow(netFlow, transportFlow gopacket.Flow, connID uint64, direction Direction) *udpFlow {
+	key := [2]gopacket.Flow{netFlow, transportFlow}
+	flow, ok := c.udpFlows[key]
+	if !ok {
+		log.Infof("new udp flow, net: %+v, transport: %+v", netFlow, transportFlow)
+		flow = &udpFlow{
+			decoder: c.factory.newDecoder(netFlow, transportFlow),
+		}
+		if setter, ok := flow.decoder.(DirectionSetter); ok {
+			setter.SetDirection(connID, direction)
+		}
+		c.udpFlows[key] = flow
 	}
+	return flow
+}
 
-	c.msgChan <- r
+// closeUDPFlows close the udp flows not seen since t, all the flows if t is
+// zero.
+func (c *controller) closeUDPFlows(t time.Time) {
+	for key, flow := range c.udpFlows {
+		if t.IsZero() || flow.lastSeen.Before(t) {
+			flow.decoder.Close()
+			delete(c.udpFlows, key)
+		}
+	}
 }
@@ -0,0 +1,321 @@
+package fdump
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testClientIP = net.ParseIP("127.0.0.1").To4()
+	testServerIP = net.ParseIP("10.2.2.2").To4()
+)
+
+func testPacket(t *testing.T, ts time.Time, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	err := gopacket.SerializeLayers(buf, opts, ls...)
+	assert.NoError(t, err)
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	packet.Metadata().Length = len(buf.Bytes())
+	return packet
+}
+
+func testIPv4(src, dst net.IP, protocol layers.IPProtocol) (*layers.Ethernet, *layers.IPv4) {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: protocol,
+		SrcIP:    src,
+		DstIP:    dst,
+	}
+	return eth, ip
+}
+
+func testTCPPacket(t *testing.T, ts time.Time, tcp *layers.TCP, payload []byte) gopacket.Packet {
+	src, dst := testClientIP, testServerIP
+	if tcp.SrcPort == 20001 {
+		src, dst = dst, src
+	}
+	eth, ip := testIPv4(src, dst, layers.IPProtocolTCP)
+	tcp.Window = 65535
+	tcp.SetNetworkLayerForChecksum(ip)
+	return testPacket(t, ts, eth, ip, tcp, gopacket.Payload(payload))
+}
+
+func testUDPPacket(t *testing.T, ts time.Time, payload []byte) gopacket.Packet {
+	eth, ip := testIPv4(testClientIP, testServerIP, layers.IPProtocolUDP)
+	udp := &layers.UDP{
+		SrcPort: 50123,
+		DstPort: 20001,
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	return testPacket(t, ts, eth, ip, udp, gopacket.Payload(payload))
+}
+
+// runTestController run a controller on the packets and returns the records
+func runTestController(t *testing.T, c *controller, packets []gopacket.Packet) []*Record {
+	var mutex sync.Mutex
+	records := make([]*Record, 0)
+	c.SetSource(NewMemoryPacketSource(packets))
+	c.AddUpdateFunc(func(record *Record) {
+		mutex.Lock()
+		records = append(records, record)
+		mutex.Unlock()
+	})
+	assert.NoError(t, c.Init())
+	c.Run()
+	c.Close()
+	return records
+}
+
+func TestControllerRunTCP(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true, PSH: true}, []byte("0123456")),
+		testTCPPacket(t, ts.Add(time.Millisecond), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 108, ACK: true, PSH: true}, []byte("789abcdefghij")),
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, RecordType(RecordTypeTCP), records[0].Type)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, "abcdefghij", records[1].Bodies[0])
+	assert.Equal(t, "127.0.0.1", records[0].Net.Src().String())
+	assert.Equal(t, "20001", records[0].Transport.Dst().String())
+}
+
+func TestControllerCaptureMeta(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	truncated := testTCPPacket(t, ts.Add(2*time.Millisecond), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true, PSH: true}, []byte("abcdefghij"))
+	truncated.Metadata().Length += 100
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts.Add(time.Millisecond), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true, PSH: true}, []byte("0123456789")),
+		truncated,
+		testUDPPacket(t, ts.Add(3*time.Millisecond), []byte("0123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 3)
+	assert.Equal(t, ts.Add(time.Millisecond), records[0].Seen)
+	assert.Equal(t, uint32(101), records[0].Seq)
+	assert.Equal(t, uint32(111), records[0].SeqEnd)
+	assert.Equal(t, 64, records[0].CaptureLength)
+	assert.Equal(t, 64, records[0].Length)
+	assert.False(t, records[0].Truncated)
+
+	assert.Equal(t, uint32(111), records[1].Seq)
+	assert.Equal(t, uint32(121), records[1].SeqEnd)
+	assert.Equal(t, 164, records[1].Length)
+	assert.True(t, records[1].Truncated)
+
+	// the capture time of the udp datagram instead of the decoded time
+	assert.Equal(t, RecordType(RecordTypeUDP), records[2].Type)
+	assert.Equal(t, ts.Add(3*time.Millisecond), records[2].Seen)
+	assert.Equal(t, 60, records[2].CaptureLength)
+	assert.False(t, records[2].Truncated)
+}
+
+func TestControllerStreamInterface(t *testing.T) {
+	// the stream is captured on both interfaces, the record has the
+	// interface of its packet
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true, PSH: true}, []byte("0123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true, PSH: true}, []byte("abcdefghij")),
+	}
+	tagInterface(packets[0], "lo")
+	tagInterface(packets[1], "lo")
+	tagInterface(packets[2], "eth0")
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "lo", records[0].Interface)
+	assert.Equal(t, "eth0", records[1].Interface)
+}
+
+func TestControllerRunUDP(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packets := []gopacket.Packet{
+		testUDPPacket(t, time.Now(), []byte("0123456789")),
+		testUDPPacket(t, time.Now(), []byte("012")),
+		// two messages and an ignored message in a datagram
+		testUDPPacket(t, time.Now(), []byte("0123456789\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00abcdefghij")),
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, RecordType(RecordTypeUDP), records[0].Type)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, []byte("0123456789"), records[0].Buffer)
+	assert.Equal(t, ErrUDPTruncated, records[1].Err)
+	assert.Equal(t, []byte("012"), records[1].Buffer)
+	assert.Equal(t, "0123456789", records[2].Bodies[0])
+	assert.Equal(t, []byte("0123456789"), records[2].Buffer)
+	assert.Equal(t, "abcdefghij", records[3].Bodies[0])
+	assert.Equal(t, []byte("abcdefghij"), records[3].Buffer)
+}
+
+func TestControllerRunUDPLeftover(t *testing.T) {
+	// the decoder consumes a byte and leaves the rest
+	decodeFunc := func(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+		if buf[0] == '.' {
+			return nil, 0, nil
+		}
+		if buf[0] == '!' {
+			return nil, 0, errors.New("bad")
+		}
+		return []interface{}{string(buf[:1])}, 1, nil
+	}
+	c := newController("", "", 65535, "", DecodeFuncFactory(decodeFunc))
+	packets := []gopacket.Packet{
+		testUDPPacket(t, time.Now(), []byte("ab..")),
+		testUDPPacket(t, time.Now(), []byte("!a")),
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, "a", records[0].Bodies[0])
+	assert.Equal(t, "b", records[1].Bodies[0])
+	assert.Equal(t, ErrUDPLeftover, records[2].Err)
+	assert.Equal(t, []byte(".."), records[2].Buffer)
+	assert.EqualError(t, records[3].Err, "bad")
+	assert.Equal(t, []byte("!a"), records[3].Buffer)
+}
+
+func TestControllerFlushByPacketTime(t *testing.T) {
+	// the packets of an old file, the streams are flushed by the packet time
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	syn := &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}
+
+	// the missing bytes arrive in the idle timeout
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, syn, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true}, []byte("abcdefghij")),
+		testTCPPacket(t, ts.Add(time.Second), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+	}
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, "abcdefghij", records[1].Bodies[0])
+	assert.Equal(t, 0, records[1].Skip)
+
+	// the missing bytes are skipped after the idle timeout, before the
+	// later udp datagram
+	c = newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packets = []gopacket.Packet{
+		testTCPPacket(t, ts, syn, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true}, []byte("abcdefghij")),
+		testUDPPacket(t, ts.Add(3*time.Second), []byte("0123456789")),
+	}
+	records = runTestController(t, c, packets)
+	assert.Len(t, records, 2)
+	assert.Equal(t, RecordType(RecordTypeTCP), records[0].Type)
+	assert.Equal(t, "abcdefghij", records[0].Bodies[0])
+	assert.Equal(t, 10, records[0].Skip)
+	assert.Equal(t, RecordType(RecordTypeUDP), records[1].Type)
+}
+
+func TestControllerCloseTimeout(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	c.SetLifecycle(true)
+	c.SetStreamOptions(streamOptions{closeTimeout: 10 * time.Second})
+	assert.Equal(t, defaultStreamOptions.idleTimeout, c.options.idleTimeout)
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testUDPPacket(t, ts.Add(11*time.Second), []byte("0123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	types := make([]string, 0)
+	for _, r := range records {
+		types = append(types, r.Type.String())
+	}
+	// the stream is closed by the timeout before the udp datagram
+	assert.Equal(t, []string{"conn", "tcp", "conn", "udp"}, types)
+	assert.Equal(t, ConnEventComplete, records[2].Bodies[0].(*ConnEvent).Event)
+}
+
+func TestSetStreamOptions(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	c.SetStreamOptions(streamOptions{idleTimeout: time.Minute, closeTimeout: time.Second, maxPages: 10})
+	assert.Equal(t, streamOptions{idleTimeout: time.Minute, closeTimeout: time.Minute, maxPages: 10}, c.options)
+}
+
+func TestControllerPickup(t *testing.T) {
+	// picked up in the middle of the streams, the records don't wait for
+	// the idle timeout or a later packet
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true}, []byte("abcdefghij")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true}, []byte("klmnopqrst")),
+		testUDPPacket(t, ts.Add(time.Second), []byte("0123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 4)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, -1, records[0].Skip)
+	assert.Equal(t, uint32(101), records[0].Seq)
+	assert.Equal(t, "abcdefghij", records[1].Bodies[0])
+	assert.Equal(t, -1, records[1].Skip)
+	assert.Equal(t, "klmnopqrst", records[2].Bodies[0])
+	assert.Equal(t, 0, records[2].Skip)
+	assert.Equal(t, uint32(111), records[2].Seq)
+	assert.Equal(t, RecordType(RecordTypeUDP), records[3].Type)
+}
+
+func TestControllerIdleConn(t *testing.T) {
+	// the connection idle longer than 2 minutes isn't forgotten before the
+	// close timeout, it isn't picked up again
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	c.SetLifecycle(true)
+	c.SetStreamOptions(streamOptions{closeTimeout: 10 * time.Minute})
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("0123456789")),
+		testTCPPacket(t, ts.Add(3*time.Minute), &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 111, ACK: true}, []byte("abcdefghij")),
+		testUDPPacket(t, ts.Add(3*time.Minute+time.Second), []byte("0123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	// the last is the complete event of the close
+	assert.Len(t, records, 5)
+	assert.Equal(t, RecordType(RecordTypeConn), records[0].Type)
+	assert.Equal(t, "0123456789", records[1].Bodies[0])
+	assert.Equal(t, "abcdefghij", records[2].Bodies[0])
+	assert.NoError(t, records[2].Err)
+	assert.Equal(t, 0, records[2].Skip)
+	assert.Equal(t, records[1].ConnID, records[2].ConnID)
+	assert.Equal(t, RecordType(RecordTypeUDP), records[3].Type)
+	assert.Equal(t, records[1].ConnID, records[4].ConnID)
+}
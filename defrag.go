@@ -0,0 +1,272 @@
+package fdump
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+// defragTimeout the fragments of a datagram are discarded if the datagram
+// isn't complete in the duration, it's the default of linux.
+const defragTimeout = 30 * time.Second
+
+// ipv6MaxFragments the fragments of an ipv6 datagram are discarded if there
+// are more than it.
+const ipv6MaxFragments = 8192
+
+// DefragStats the counters of the ip fragment reassembly.
+type DefragStats struct {
+	// Fragments the count of the received fragments.
+	Fragments uint64
+	// Reassembled the count of the datagrams reassembled from the fragments.
+	Reassembled uint64
+	// Incomplete the count of the datagrams waiting for their fragments now.
+	Incomplete uint64
+	// TimedOut the count of the datagrams discarded because their fragments
+	// didn't arrive in time.
+	TimedOut uint64
+	// Invalid the count of the fragments dropped by the sanity checks.
+	Invalid uint64
+}
+
+type fragmentKey struct {
+	net gopacket.Flow
+	id  uint32
+}
+
+type ipv6Fragment struct {
+	offset int
+	data   []byte
+}
+
+// ipv6Fragments the fragments of an ipv6 datagram.
+type ipv6Fragments struct {
+	fragments []ipv6Fragment
+	// total the length of the datagram, it's known after the last fragment
+	// arrived.
+	total    int
+	lastSeen time.Time
+}
+
+// defragmenter reassembles the fragmented ip datagrams, so the transport
+// layer of a large udp message is decoded from the whole datagram. The
+// ipv4 datagrams are reassembled by ip4defrag.
+type defragmenter struct {
+	ip4 *ip4defrag.IPv4Defragmenter
+	// ip4LastSeen the last seen time of the incomplete ipv4 datagrams, it
+	// follows the fragment lists of ip4defrag.
+	ip4LastSeen map[fragmentKey]time.Time
+	ip6         map[fragmentKey]*ipv6Fragments
+	// latest the latest timestamp of the packets, the fragments expire by
+	// the capture time, it works for the offline files too.
+	latest time.Time
+
+	mutex sync.Mutex
+	stats DefragStats
+}
+
+func newDefragmenter() *defragmenter {
+	return &defragmenter{
+		ip4:         ip4defrag.NewIPv4Defragmenter(),
+		ip4LastSeen: make(map[fragmentKey]time.Time),
+		ip6:         make(map[fragmentKey]*ipv6Fragments),
+	}
+}
+
+// Stats returns the counters.
+func (d *defragmenter) Stats() DefragStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	stats := d.stats
+	stats.Incomplete = uint64(len(d.ip4LastSeen) + len(d.ip6))
+	return stats
+}
+
+// defrag returns the packet itself if it isn't a fragment, the reassembled
+// packet if it's the last fragment of a datagram, otherwise nil.
+func (d *defragmenter) defrag(packet gopacket.Packet) gopacket.Packet {
+	if ts := packet.Metadata().Timestamp; ts.After(d.latest) {
+		d.latest = ts
+	}
+	// the first network layer, the inner ones of a tunnel are defragmented
+	// after the decapsulation
+	if ip6, ok := packet.NetworkLayer().(*layers.IPv6); ok {
+		if fragment := ipv6FragmentLayer(packet, ip6); fragment != nil {
+			return d.defragIPv6(packet, ip6, fragment)
+		}
+		return packet
+	}
+
+	ip4, ok := packet.NetworkLayer().(*layers.IPv4)
+	if !ok || (ip4.Flags&layers.IPv4MoreFragments == 0 && ip4.FragOffset == 0) {
+		return packet
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stats.Fragments++
+	ts := packet.Metadata().Timestamp
+	key := fragmentKey{net: ip4.NetworkFlow(), id: uint32(ip4.Id)}
+	out, err := d.ip4.DefragIPv4WithTimestamp(ip4, ts)
+	if err != nil {
+		log.Debugf("defrag ipv4 failed, err: %v", err)
+		d.stats.Invalid++
+		return nil
+	}
+	if out == nil {
+		d.ip4LastSeen[key] = ts
+		return nil
+	}
+	delete(d.ip4LastSeen, key)
+	d.stats.Reassembled++
+
+	return reassembledPacket(packet, layers.LayerTypeIPv4, out, out.Payload)
+}
+
+func (d *defragmenter) defragIPv6(packet gopacket.Packet, ip6 *layers.IPv6, fragment *layers.IPv6Fragment) gopacket.Packet {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stats.Fragments++
+	ts := packet.Metadata().Timestamp
+
+	offset := int(fragment.FragmentOffset) * 8
+	data := fragment.Payload
+	if !fragment.MoreFragments && offset == 0 {
+		// an atomic fragment, there is only one fragment
+		d.stats.Reassembled++
+		return reassembledPacket(packet, layers.LayerTypeIPv6, ipv6Header(ip6, fragment), data)
+	}
+	if offset+len(data) > 65535 || (fragment.MoreFragments && len(data)%8 != 0) {
+		d.stats.Invalid++
+		return nil
+	}
+
+	key := fragmentKey{net: ip6.NetworkFlow(), id: fragment.Identification}
+	fragments, ok := d.ip6[key]
+	if !ok {
+		fragments = &ipv6Fragments{}
+		d.ip6[key] = fragments
+	}
+	fragments.lastSeen = ts
+	if !fragment.MoreFragments {
+		if fragments.total != 0 && fragments.total != offset+len(data) {
+			log.Debugf("defrag ipv6 failed, the lengths of the datagram conflict")
+			d.stats.Invalid++
+			delete(d.ip6, key)
+			return nil
+		}
+		fragments.total = offset + len(data)
+	}
+	fragments.fragments = append(fragments.fragments, ipv6Fragment{
+		offset: offset,
+		data:   append([]byte{}, data...),
+	})
+	if len(fragments.fragments) > ipv6MaxFragments {
+		d.stats.Invalid++
+		delete(d.ip6, key)
+		return nil
+	}
+	if fragments.total == 0 {
+		return nil
+	}
+
+	payload, ok := fragments.build()
+	if !ok {
+		return nil
+	}
+	delete(d.ip6, key)
+	d.stats.Reassembled++
+	return reassembledPacket(packet, layers.LayerTypeIPv6, ipv6Header(ip6, fragment), payload)
+}
+
+// build returns the payload of the datagram if all the fragments arrived.
+// The earlier fragment wins if the fragments overlap.
+func (f *ipv6Fragments) build() ([]byte, bool) {
+	sort.SliceStable(f.fragments, func(i, j int) bool {
+		return f.fragments[i].offset < f.fragments[j].offset
+	})
+	payload := make([]byte, 0, f.total)
+	for _, fragment := range f.fragments {
+		if fragment.offset > len(payload) {
+			// a hole
+			return nil, false
+		}
+		end := fragment.offset + len(fragment.data)
+		if end > f.total {
+			end = f.total
+		}
+		if end > len(payload) {
+			payload = append(payload, fragment.data[len(payload)-fragment.offset:end-fragment.offset]...)
+		}
+	}
+	return payload, len(payload) == f.total
+}
+
+// ipv6FragmentLayer returns the fragment header in the extension headers of
+// ip6, nil if it isn't a fragment.
+func ipv6FragmentLayer(packet gopacket.Packet, ip6 *layers.IPv6) *layers.IPv6Fragment {
+	all := packet.Layers()
+	i := 0
+	for i < len(all) && all[i] != gopacket.Layer(ip6) {
+		i++
+	}
+	for _, layer := range all[i+1:] {
+		switch layer := layer.(type) {
+		case *layers.IPv6Fragment:
+			return layer
+		case *layers.IPv6HopByHop, *layers.IPv6Destination, *layers.IPv6Routing:
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func ipv6Header(ip6 *layers.IPv6, fragment *layers.IPv6Fragment) *layers.IPv6 {
+	return &layers.IPv6{
+		Version:      ip6.Version,
+		TrafficClass: ip6.TrafficClass,
+		FlowLabel:    ip6.FlowLabel,
+		NextHeader:   fragment.NextHeader,
+		HopLimit:     ip6.HopLimit,
+		SrcIP:        ip6.SrcIP,
+		DstIP:        ip6.DstIP,
+	}
+}
+
+// expire discard the incomplete datagrams not seen in defragTimeout before
+// the latest packet.
+func (d *defragmenter) expire() {
+	t := d.latest.Add(-defragTimeout)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ip4.DiscardOlderThan(t)
+	for key, lastSeen := range d.ip4LastSeen {
+		if lastSeen.Before(t) {
+			delete(d.ip4LastSeen, key)
+			d.stats.TimedOut++
+		}
+	}
+	for key, fragments := range d.ip6 {
+		if fragments.lastSeen.Before(t) {
+			delete(d.ip6, key)
+			d.stats.TimedOut++
+		}
+	}
+}
+
+// reassembledPacket decode the reassembled datagram to a packet, it has the
+// metadata of the last fragment.
+func reassembledPacket(last gopacket.Packet, layerType gopacket.LayerType, ip gopacket.SerializableLayer, payload []byte) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, gopacket.Payload(payload)); err != nil {
+		log.Errorf("serialize the reassembled datagram failed, err: %v", err)
+		return nil
+	}
+	return innerPacket(last, buf.Bytes(), layerType)
+}
@@ -0,0 +1,112 @@
+package fdump
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+// testUDPDatagram returns the udp header and the payload of a datagram.
+func testUDPDatagram(t *testing.T, ip gopacket.NetworkLayer, payload []byte) []byte {
+	udp := &layers.UDP{
+		SrcPort: 50123,
+		DstPort: 20001,
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(t, gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(payload)))
+	return buf.Bytes()
+}
+
+// testIPv4Fragments split the udp datagram into the fragments of size bytes.
+func testIPv4Fragments(t *testing.T, ts time.Time, id uint16, payload []byte, size int) []gopacket.Packet {
+	_, ip := testIPv4(testClientIP, testServerIP, layers.IPProtocolUDP)
+	datagram := testUDPDatagram(t, ip, payload)
+	packets := make([]gopacket.Packet, 0)
+	for offset := 0; offset < len(datagram); offset += size {
+		end := offset + size
+		flags := layers.IPv4MoreFragments
+		if end >= len(datagram) {
+			end = len(datagram)
+			flags = 0
+		}
+		eth, ip := testIPv4(testClientIP, testServerIP, layers.IPProtocolUDP)
+		ip.Id = id
+		ip.Flags = flags
+		ip.FragOffset = uint16(offset / 8)
+		packets = append(packets, testPacket(t, ts, eth, ip, gopacket.Payload(datagram[offset:end])))
+	}
+	return packets
+}
+
+// testIPv6Fragments split the udp datagram into the fragments of size bytes.
+func testIPv6Fragments(t *testing.T, ts time.Time, id uint32, payload []byte, size int) []gopacket.Packet {
+	src, dst := net.ParseIP("::1"), net.ParseIP("fe80::2")
+	ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 64, SrcIP: src, DstIP: dst}
+	datagram := testUDPDatagram(t, ip, payload)
+	packets := make([]gopacket.Packet, 0)
+	for offset := 0; offset < len(datagram); offset += size {
+		end := offset + size
+		more := true
+		if end >= len(datagram) {
+			end = len(datagram)
+			more = false
+		}
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv6,
+		}
+		ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolIPv6Fragment, HopLimit: 64, SrcIP: src, DstIP: dst}
+		// the fragment header: next header, reserved, offset and flag, id
+		header := []byte{byte(layers.IPProtocolUDP), 0, byte(offset >> 8), byte(offset), 0, 0, 0, 0}
+		if more {
+			header[3] |= 1
+		}
+		header[4], header[5], header[6], header[7] = byte(id>>24), byte(id>>16), byte(id>>8), byte(id)
+		packets = append(packets, testPacket(t, ts, eth, ip, gopacket.Payload(append(header, datagram[offset:end]...))))
+	}
+	return packets
+}
+
+func TestControllerDefragIPv4(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	ts := time.Now()
+	fragments := testIPv4Fragments(t, ts, 1, []byte("0123456789abcdefghij"), 16)
+	assert.Len(t, fragments, 2)
+	// the fragments are out of order, a datagram is incomplete
+	packets := []gopacket.Packet{fragments[1], fragments[0]}
+	packets = append(packets, testIPv4Fragments(t, ts, 2, []byte("0123456789abcdefghij"), 16)[0])
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 2)
+	assert.Equal(t, RecordType(RecordTypeUDP), records[0].Type)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, "abcdefghij", records[1].Bodies[0])
+	assert.Equal(t, "50123", records[0].Transport.Src().String())
+	assert.Equal(t, DefragStats{Fragments: 3, Reassembled: 1, Incomplete: 1}, c.defrag.Stats())
+
+	c.defrag.latest = ts.Add(defragTimeout + time.Second)
+	c.defrag.expire()
+	assert.Equal(t, DefragStats{Fragments: 3, Reassembled: 1, TimedOut: 1}, c.defrag.Stats())
+}
+
+func TestControllerDefragIPv6(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	ts := time.Now()
+	fragments := testIPv6Fragments(t, ts, 7, []byte("0123456789abcdefghij"), 8)
+	assert.Len(t, fragments, 4)
+	// a duplicated fragment
+	packets := []gopacket.Packet{fragments[0], fragments[2], fragments[2], fragments[3], fragments[1]}
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, "::1", records[0].Net.Src().String())
+	assert.Equal(t, DefragStats{Fragments: 5, Reassembled: 1}, c.defrag.Stats())
+}
@@ -93,6 +93,15 @@ Example:
   	a.Run()
   }
 
+If the protocol keeps state across the messages, implement a StreamDecoder
+and create the application by NewStreamApp. A StreamDecoder is created for
+every tcp stream and udp flow.
+
+To understand several protocols in one application, register a Protocol for
+every protocol to a Registry and create the application by NewRegistryApp.
+The streams are dispatched to the protocols by the ports, a BPF expression or
+a sniffing function.
+
 If you want to add your owner command flag, please use fdump.AppFlagSet.
 
 The framework use github.com/op/go-logging to write log. You can get the some log
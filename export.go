@@ -0,0 +1,249 @@
+package fdump
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// maxSegmentSize the payload of a record is split to segments of this size
+// when synthesize the tcp packets.
+const maxSegmentSize = 1460
+
+var (
+	exportSrcMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	exportDstMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// errUnsupportedEndpoint the endpoint of the record can't be exported.
+var errUnsupportedEndpoint = errors.New("unsupported endpoint")
+
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// exporter synthesize the link, ip and transport headers of the records.
+// It keeps the sequence of every tcp flow to make the exported stream
+// continuous.
+type exporter struct {
+	w    packetWriter
+	seqs map[[2]gopacket.Flow]uint32
+}
+
+// exportPcap export the records to the file. The file is in pcapng format if
+// the suffix is .pcapng, otherwise it's in pcap format.
+func exportPcap(records []*Record, filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = writePcap(f, records, strings.HasSuffix(filename, ".pcapng"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writePcap write the records to out, in pcapng format if pcapng is true.
+func writePcap(out io.Writer, records []*Record, pcapng bool) error {
+	e := &exporter{
+		seqs: make(map[[2]gopacket.Flow]uint32),
+	}
+	var ng *pcapgo.NgWriter
+	if pcapng {
+		w, err := pcapgo.NewNgWriter(out, layers.LinkTypeEthernet)
+		if err != nil {
+			return err
+		}
+		ng = w
+		e.w = w
+	} else {
+		w := pcapgo.NewWriterNanos(out)
+		err := w.WriteFileHeader(65535, layers.LinkTypeEthernet)
+		if err != nil {
+			return err
+		}
+		e.w = w
+	}
+
+	for _, record := range records {
+		err := e.write(record)
+		if err == errUnsupportedEndpoint {
+			log.Infof("skip record, net: %v, transport: %v", record.Net, record.Transport)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if ng != nil {
+		// the pcapng writer is buffered
+		return ng.Flush()
+	}
+	return nil
+}
+
+func (e *exporter) write(record *Record) error {
+	switch record.Type {
+	case RecordTypeTCP:
+		return e.writeTCP(record)
+	case RecordTypeUDP:
+		return e.writeUDP(record)
+	}
+	return errUnsupportedEndpoint
+}
+
+func (e *exporter) writeTCP(record *Record) error {
+	srcPort, dstPort, err := transportPorts(record.Transport)
+	if err != nil {
+		return err
+	}
+
+	key := [2]gopacket.Flow{record.Net, record.Transport}
+	reverseKey := [2]gopacket.Flow{record.Net.Reverse(), record.Transport.Reverse()}
+	seq := e.nextSeq(key)
+	ack := e.nextSeq(reverseKey)
+
+	payload := record.Buffer
+	for {
+		size := len(payload)
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(srcPort),
+			DstPort: layers.TCPPort(dstPort),
+			Seq:     seq,
+			Ack:     ack,
+			ACK:     true,
+			PSH:     size == len(payload),
+			Window:  65535,
+		}
+		err := e.writePacket(record, tcp, payload[:size])
+		if err != nil {
+			return err
+		}
+		seq += uint32(size)
+		payload = payload[size:]
+		if len(payload) == 0 {
+			break
+		}
+	}
+
+	e.seqs[key] = seq
+	return nil
+}
+
+func (e *exporter) nextSeq(key [2]gopacket.Flow) uint32 {
+	seq, ok := e.seqs[key]
+	if !ok {
+		seq = 1
+		e.seqs[key] = seq
+	}
+	return seq
+}
+
+func (e *exporter) writeUDP(record *Record) error {
+	srcPort, dstPort, err := transportPorts(record.Transport)
+	if err != nil {
+		return err
+	}
+
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(srcPort),
+		DstPort: layers.UDPPort(dstPort),
+	}
+	return e.writePacket(record, udp, record.Buffer)
+}
+
+type checksumLayer interface {
+	gopacket.SerializableLayer
+	SetNetworkLayerForChecksum(gopacket.NetworkLayer) error
+}
+
+func (e *exporter) writePacket(record *Record, transport checksumLayer, payload []byte) error {
+	data, err := serializePacket(record, transport, payload)
+	if err != nil {
+		return err
+	}
+
+	ci := gopacket.CaptureInfo{
+		Timestamp:     record.Seen,
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	return e.w.WritePacket(ci, data)
+}
+
+// serializePacket synthesize the ethernet and ip headers of the transport
+// layer and the payload of the record.
+func serializePacket(record *Record, transport checksumLayer, payload []byte) ([]byte, error) {
+	protocol := layers.IPProtocolTCP
+	if record.Type == RecordTypeUDP {
+		protocol = layers.IPProtocolUDP
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC: exportSrcMAC,
+		DstMAC: exportDstMAC,
+	}
+	var ip gopacket.SerializableLayer
+	src, dst := record.Net.Src(), record.Net.Dst()
+	switch {
+	case src.EndpointType() == layers.EndpointIPv4 && dst.EndpointType() == layers.EndpointIPv4:
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Flags:    layers.IPv4DontFragment,
+			Protocol: protocol,
+			SrcIP:    net.IP(src.Raw()),
+			DstIP:    net.IP(dst.Raw()),
+		}
+		transport.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	case src.EndpointType() == layers.EndpointIPv6 && dst.EndpointType() == layers.EndpointIPv6:
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: protocol,
+			SrcIP:      net.IP(src.Raw()),
+			DstIP:      net.IP(dst.Raw()),
+		}
+		transport.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	default:
+		return nil, errUnsupportedEndpoint
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(payload))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func transportPorts(transport gopacket.Flow) (src, dst uint16, err error) {
+	srcRaw, dstRaw := transport.Src().Raw(), transport.Dst().Raw()
+	if len(srcRaw) != 2 || len(dstRaw) != 2 {
+		err = errUnsupportedEndpoint
+		return
+	}
+	src = binary.BigEndian.Uint16(srcRaw)
+	dst = binary.BigEndian.Uint16(dstRaw)
+	return
+}
@@ -0,0 +1,123 @@
+package fdump
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFlows(t *testing.T, src, dst string, transportType gopacket.EndpointType) (gopacket.Flow, gopacket.Flow) {
+	netFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(net.ParseIP(src)),
+		layers.NewIPEndpoint(net.ParseIP(dst)))
+	assert.NoError(t, err)
+	var transportFlow gopacket.Flow
+	if transportType == layers.EndpointUDPPort {
+		transportFlow, err = gopacket.FlowFromEndpoints(
+			layers.NewUDPPortEndpoint(50123),
+			layers.NewUDPPortEndpoint(20001))
+	} else {
+		transportFlow, err = gopacket.FlowFromEndpoints(
+			layers.NewTCPPortEndpoint(50123),
+			layers.NewTCPPortEndpoint(20001))
+	}
+	assert.NoError(t, err)
+	return netFlow, transportFlow
+}
+
+func readPcap(t *testing.T, filename string) []gopacket.Packet {
+	f, err := os.Open(filename)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var source *gopacket.PacketSource
+	if filepath.Ext(filename) == ".pcapng" {
+		r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+		assert.NoError(t, err)
+		source = gopacket.NewPacketSource(r, r.LinkType())
+	} else {
+		r, err := pcapgo.NewReader(f)
+		assert.NoError(t, err)
+		source = gopacket.NewPacketSource(r, r.LinkType())
+	}
+
+	packets := make([]gopacket.Packet, 0)
+	for packet := range source.Packets() {
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestExportPcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	udpNet, udpTransport := testFlows(t, "::1", "::2", layers.EndpointUDPPort)
+	seen := time.Unix(1500000000, 0)
+	big := make([]byte, maxSegmentSize+10)
+	records := []*Record{
+		&Record{Type: RecordTypeTCP, Net: netFlow, Transport: transportFlow, Seen: seen, Buffer: []byte("request")},
+		&Record{Type: RecordTypeTCP, Net: netFlow.Reverse(), Transport: transportFlow.Reverse(), Seen: seen, Buffer: []byte("response")},
+		&Record{Type: RecordTypeTCP, Net: netFlow, Transport: transportFlow, Seen: seen, Buffer: big},
+		&Record{Type: RecordTypeUDP, Net: udpNet, Transport: udpTransport, Seen: seen, Buffer: []byte("udp")},
+	}
+
+	for _, name := range []string{"records.pcap", "records.pcapng"} {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, exportPcap(records, filename))
+
+		packets := readPcap(t, filename)
+		assert.Equal(t, 5, len(packets))
+
+		tcp0 := packets[0].Layer(layers.LayerTypeTCP).(*layers.TCP)
+		assert.Equal(t, uint32(1), tcp0.Seq)
+		assert.Equal(t, layers.TCPPort(50123), tcp0.SrcPort)
+		assert.Equal(t, []byte("request"), tcp0.Payload)
+		assert.Equal(t, seen.UnixNano(), packets[0].Metadata().Timestamp.UnixNano())
+
+		tcp1 := packets[1].Layer(layers.LayerTypeTCP).(*layers.TCP)
+		assert.Equal(t, uint32(1), tcp1.Seq)
+		assert.Equal(t, uint32(8), tcp1.Ack)
+		assert.Equal(t, "10.2.2.2", packets[1].NetworkLayer().NetworkFlow().Src().String())
+
+		tcp2 := packets[2].Layer(layers.LayerTypeTCP).(*layers.TCP)
+		tcp3 := packets[3].Layer(layers.LayerTypeTCP).(*layers.TCP)
+		assert.Equal(t, uint32(8), tcp2.Seq)
+		assert.Equal(t, maxSegmentSize, len(tcp2.Payload))
+		assert.Equal(t, uint32(8+maxSegmentSize), tcp3.Seq)
+		assert.Equal(t, 10, len(tcp3.Payload))
+		assert.True(t, tcp3.PSH)
+
+		udp := packets[4].Layer(layers.LayerTypeUDP).(*layers.UDP)
+		assert.Equal(t, []byte("udp"), udp.Payload)
+		assert.Equal(t, "::1", packets[4].NetworkLayer().NetworkFlow().Src().String())
+	}
+}
+
+// testFailWriter fails every write, like a full disk
+type testFailWriter struct{}
+
+func (testFailWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestWritePcapError(t *testing.T) {
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	records := []*Record{
+		&Record{Type: RecordTypeTCP, Net: netFlow, Transport: transportFlow, Seen: time.Now(), Buffer: []byte("request")},
+	}
+	// the pcapng writer is buffered, the error is returned by the flush
+	assert.Error(t, writePcap(testFailWriter{}, records, true))
+	assert.Error(t, writePcap(testFailWriter{}, records, false))
+}
@@ -0,0 +1,269 @@
+// Package framing split the frames of a stream for fdump, so a decoder only
+// parses the payload of a whole frame. A SplitFunc finds the frame at the
+// beginning of the buffer, such as a frame with a length field in its
+// header, a varint length prefix, a delimiter or a TLV. It composes with a
+// BodyFunc into a fdump.DecodeFunc, which returns fdump.ErrPkgNoEnough until
+// the frame is whole and ErrFrameTooLarge if the frame exceeds the max size.
+//
+// Decode the frames with a 4 bytes big endian length of the whole frame:
+//
+//	split := framing.Length{Size: 4, Inclusive: true}.SplitFunc()
+//	a := fdump.NewApp(framing.DecodeFunc(split, body), brief, detail, nil, briefAttributes)
+//
+//	func body(net, transport gopacket.Flow, frame, payload []byte) ([]interface{}, error) {
+//		return []interface{}{string(payload)}, nil
+//	}
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+)
+
+// DefaultMaxSize the max frame size if it isn't set.
+const DefaultMaxSize = 16 << 20
+
+// errors
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrMalformed     = errors.New("malformed frame")
+)
+
+// SplitFunc returns the length of the frame at the beginning of buf and its
+// payload. It returns fdump.ErrPkgNoEnough if buf doesn't contain a whole
+// frame. The constructors of the SplitFuncs panic if the config is invalid.
+type SplitFunc func(buf []byte) (n int, payload []byte, err error)
+
+// BodyFunc parse the payload of a whole frame to the bodies of the record.
+// The frame and the payload are only valid in the call, copy them if they
+// are kept in the bodies.
+type BodyFunc func(net, transport gopacket.Flow, frame, payload []byte) (bodies []interface{}, err error)
+
+// DecodeFunc returns a fdump.DecodeFunc split the frame by split and parse
+// its payload by body. A copy of the payload is the body of the record if
+// body is nil.
+func DecodeFunc(split SplitFunc, body BodyFunc) fdump.DecodeFunc {
+	return func(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+		n, payload, err := split(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		if body == nil {
+			return []interface{}{append([]byte{}, payload...)}, n, nil
+		}
+		bodies, err = body(net, transport, buf[:n], payload)
+		if err == fdump.ErrPkgNoEnough {
+			// the frame is whole, the payload is short
+			err = ErrMalformed
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return bodies, n, nil
+	}
+}
+
+func maxSize(max int) int {
+	if max <= 0 {
+		return DefaultMaxSize
+	}
+	return max
+}
+
+// readUint read an unsigned integer of size bytes, size is 1 to 8.
+func readUint(buf []byte, size int, order binary.ByteOrder) uint64 {
+	switch size {
+	case 1:
+		return uint64(buf[0])
+	case 2:
+		return uint64(order.Uint16(buf))
+	case 4:
+		return uint64(order.Uint32(buf))
+	case 8:
+		return order.Uint64(buf)
+	}
+	var u uint64
+	for i := 0; i < size; i++ {
+		if order == binary.LittleEndian {
+			u |= uint64(buf[i]) << (8 * uint(i))
+		} else {
+			u = u<<8 | uint64(buf[i])
+		}
+	}
+	return u
+}
+
+// Length the frames have a length field in the header.
+type Length struct {
+	// Offset the offset of the length field.
+	Offset int
+	// Size the byte size of the length field, 1 to 8.
+	Size int
+	// Order the byte order of the length field, big endian if it's nil.
+	Order binary.ByteOrder
+	// HeaderSize the payload begins after the header, Offset+Size if it's 0.
+	HeaderSize int
+	// Inclusive the length counts the whole frame, otherwise it counts the
+	// bytes after the header.
+	Inclusive bool
+	// Adjust is added to the length, such as the length of a trailer which
+	// isn't counted.
+	Adjust int
+	// Max the max frame size, DefaultMaxSize if it's 0.
+	Max int
+}
+
+// SplitFunc returns the SplitFunc of l. It panics if Size isn't 1 to 8 or
+// Offset or HeaderSize is negative.
+func (l Length) SplitFunc() SplitFunc {
+	if l.Size < 1 || l.Size > 8 {
+		panic("framing: the size of the length field must be 1 to 8")
+	}
+	if l.Offset < 0 || l.HeaderSize < 0 {
+		panic("framing: negative offset or header size")
+	}
+	order := l.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	header := l.HeaderSize
+	if header == 0 {
+		header = l.Offset + l.Size
+	}
+	max := maxSize(l.Max)
+
+	return func(buf []byte) (n int, payload []byte, err error) {
+		if len(buf) < l.Offset+l.Size {
+			return 0, nil, fdump.ErrPkgNoEnough
+		}
+		length := readUint(buf[l.Offset:], l.Size, order)
+		if length > uint64(max) {
+			return 0, nil, ErrFrameTooLarge
+		}
+		n = int(length) + l.Adjust
+		if !l.Inclusive {
+			n += header
+		}
+		if n < header || n < l.Offset+l.Size {
+			return 0, nil, ErrMalformed
+		}
+		if n > max {
+			return 0, nil, ErrFrameTooLarge
+		}
+		if len(buf) < n {
+			return 0, nil, fdump.ErrPkgNoEnough
+		}
+		return n, buf[header:n], nil
+	}
+}
+
+// Varint returns a SplitFunc of the frames prefixed by a varint length of
+// the payload, such as the delimited protobuf messages.
+func Varint(max int) SplitFunc {
+	max = maxSize(max)
+	return func(buf []byte) (n int, payload []byte, err error) {
+		length, header := binary.Uvarint(buf)
+		if header == 0 {
+			if len(buf) >= binary.MaxVarintLen64 {
+				return 0, nil, ErrMalformed
+			}
+			return 0, nil, fdump.ErrPkgNoEnough
+		}
+		if header < 0 {
+			return 0, nil, ErrMalformed
+		}
+		if length > uint64(max) || header+int(length) > max {
+			return 0, nil, ErrFrameTooLarge
+		}
+		n = header + int(length)
+		if len(buf) < n {
+			return 0, nil, fdump.ErrPkgNoEnough
+		}
+		return n, buf[header:n], nil
+	}
+}
+
+// Delimiter returns a SplitFunc of the frames terminated by delim, the
+// payload doesn't include delim. It returns ErrFrameTooLarge if there is no
+// delim in max bytes. It panics if delim is empty.
+func Delimiter(delim []byte, max int) SplitFunc {
+	if len(delim) == 0 {
+		panic("framing: empty delimiter")
+	}
+	delim = append([]byte{}, delim...)
+	max = maxSize(max)
+	return func(buf []byte) (n int, payload []byte, err error) {
+		search := buf
+		if len(search) > max {
+			search = search[:max]
+		}
+		i := bytes.Index(search, delim)
+		if i < 0 {
+			if len(buf) >= max {
+				return 0, nil, ErrFrameTooLarge
+			}
+			return 0, nil, fdump.ErrPkgNoEnough
+		}
+		return i + len(delim), buf[:i], nil
+	}
+}
+
+// Line returns a SplitFunc of the lines terminated by "\n" or "\r\n", the
+// payload doesn't include the line ending.
+func Line(max int) SplitFunc {
+	split := Delimiter([]byte("\n"), max)
+	return func(buf []byte) (n int, payload []byte, err error) {
+		n, payload, err = split(buf)
+		if err == nil {
+			payload = bytes.TrimSuffix(payload, []byte("\r"))
+		}
+		return
+	}
+}
+
+// TLV the frames are a type, a length of the value and the value.
+type TLV struct {
+	// TypeSize the byte size of the type, 0 to 8.
+	TypeSize int
+	// LengthSize the byte size of the length, 1 to 8.
+	LengthSize int
+	// Order the byte order of the type and the length, big endian if it's
+	// nil.
+	Order binary.ByteOrder
+	// Inclusive the length counts the type and the length, otherwise it
+	// counts the value.
+	Inclusive bool
+	// Max the max frame size, DefaultMaxSize if it's 0.
+	Max int
+}
+
+// SplitFunc returns the SplitFunc of t, the payload is the value. It panics
+// if TypeSize isn't 0 to 8 or LengthSize isn't 1 to 8.
+func (t TLV) SplitFunc() SplitFunc {
+	if t.TypeSize < 0 || t.TypeSize > 8 {
+		panic("framing: the size of the type must be 0 to 8")
+	}
+	return Length{
+		Offset:    t.TypeSize,
+		Size:      t.LengthSize,
+		Order:     t.Order,
+		Inclusive: t.Inclusive,
+		Max:       t.Max,
+	}.SplitFunc()
+}
+
+// Type returns the type of a frame split by t.
+func (t TLV) Type(frame []byte) uint64 {
+	if t.TypeSize <= 0 || len(frame) < t.TypeSize {
+		return 0
+	}
+	order := t.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	return readUint(frame, t.TypeSize, order)
+}
@@ -0,0 +1,112 @@
+package framing
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+// testSplit check every prefix of buf isn't enough, and the frame is split
+// from buf.
+func testSplit(t *testing.T, split SplitFunc, buf []byte, frameLen int, payload string) {
+	for i := 0; i < frameLen; i++ {
+		_, _, err := split(buf[:i])
+		assert.Equal(t, fdump.ErrPkgNoEnough, err, "length %d", i)
+	}
+	n, p, err := split(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, frameLen, n)
+	assert.Equal(t, payload, string(p))
+}
+
+func TestLength(t *testing.T) {
+	buf := []byte{0, 0, 0, 9, 'h', 'e', 'l', 'l', 'o', 'x'}
+	testSplit(t, Length{Size: 4, Inclusive: true}.SplitFunc(), buf, 9, "hello")
+	testSplit(t, Length{Size: 4, Inclusive: true, HeaderSize: 6}.SplitFunc(), buf, 9, "llo")
+
+	// a magic, a 3 bytes little endian length of the body, a flag
+	buf = []byte{0xca, 0xfe, 2, 0, 0, 1, 'h', 'i', 'x'}
+	testSplit(t, Length{Offset: 2, Size: 3, Order: binary.LittleEndian, HeaderSize: 6}.SplitFunc(), buf, 8, "hi")
+	// a 2 bytes checksum follows the body
+	testSplit(t, Length{Offset: 2, Size: 3, Order: binary.LittleEndian, HeaderSize: 6, Adjust: 1}.SplitFunc(), buf, 9, "hix")
+
+	_, _, err := Length{Size: 4, Max: 8}.SplitFunc()([]byte{0, 0, 0, 5})
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, _, err = Length{Size: 4}.SplitFunc()([]byte{0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, _, err = Length{Size: 4, Inclusive: true}.SplitFunc()([]byte{0, 0, 0, 2})
+	assert.Equal(t, ErrMalformed, err)
+	assert.Panics(t, func() { Length{Size: 9}.SplitFunc() })
+	assert.Panics(t, func() { Length{}.SplitFunc() })
+	assert.Panics(t, func() { Length{Offset: -1, Size: 4}.SplitFunc() })
+}
+
+func TestVarint(t *testing.T) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	buf = append(buf[:binary.PutUvarint(buf, 200)], strings.Repeat("a", 200)...)
+	testSplit(t, Varint(0), buf, 202, strings.Repeat("a", 200))
+
+	_, _, err := Varint(100)(buf)
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, _, err = Varint(0)([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, ErrMalformed, err)
+}
+
+func TestDelimiter(t *testing.T) {
+	testSplit(t, Delimiter([]byte("\x00\x00"), 0), []byte("abc\x00\x00d"), 5, "abc")
+	testSplit(t, Line(0), []byte("GET\r\nHost"), 5, "GET")
+	testSplit(t, Line(0), []byte("PING\n"), 5, "PING")
+
+	_, _, err := Line(4)([]byte("abcd"))
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, _, err = Line(4)([]byte("abc"))
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	_, _, err = Line(4)([]byte("abcdef\n"))
+	assert.Equal(t, ErrFrameTooLarge, err)
+
+	assert.Panics(t, func() { Delimiter(nil, 0) })
+}
+
+func TestTLV(t *testing.T) {
+	tlv := TLV{TypeSize: 1, LengthSize: 2}
+	buf := []byte{7, 0, 3, 'a', 'b', 'c', 1}
+	testSplit(t, tlv.SplitFunc(), buf, 6, "abc")
+	assert.Equal(t, uint64(7), tlv.Type(buf))
+
+	tlv = TLV{TypeSize: 2, LengthSize: 2, Order: binary.LittleEndian, Inclusive: true}
+	buf = []byte{1, 2, 6, 0, 'a', 'b'}
+	testSplit(t, tlv.SplitFunc(), buf, 6, "ab")
+	assert.Equal(t, uint64(0x0201), tlv.Type(buf))
+
+	assert.Panics(t, func() { TLV{TypeSize: 9, LengthSize: 2}.SplitFunc() })
+	assert.Panics(t, func() { TLV{TypeSize: 1}.SplitFunc() })
+}
+
+func TestDecodeFunc(t *testing.T) {
+	split := Length{Size: 1}.SplitFunc()
+	buf := []byte{2, 'h', 'i', 0}
+
+	bodies, n, err := DecodeFunc(split, nil)(gopacket.Flow{}, gopacket.Flow{}, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []interface{}{[]byte("hi")}, bodies)
+
+	decode := DecodeFunc(split, func(net, transport gopacket.Flow, frame, payload []byte) ([]interface{}, error) {
+		if len(payload) < 3 {
+			return nil, fdump.ErrPkgNoEnough
+		}
+		return []interface{}{string(frame)}, nil
+	})
+	_, _, err = decode(gopacket.Flow{}, gopacket.Flow{}, buf[:2])
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	_, _, err = decode(gopacket.Flow{}, gopacket.Flow{}, buf)
+	assert.Equal(t, ErrMalformed, err)
+	bodies, n, err = decode(gopacket.Flow{}, gopacket.Flow{}, []byte{3, 'a', 'b', 'c'})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []interface{}{"\x03abc"}, bodies)
+}
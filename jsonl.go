@@ -0,0 +1,143 @@
+package fdump
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+type jsonFlow struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+type jsonTunnel struct {
+	Type      string    `json:"type"`
+	Net       jsonFlow  `json:"net"`
+	Transport *jsonFlow `json:"transport,omitempty"`
+	ID        uint32    `json:"id,omitempty"`
+}
+
+// jsonRecord the JSON Lines form of a record
+type jsonRecord struct {
+	Type      string            `json:"type"`
+	Net       jsonFlow          `json:"net"`
+	Transport jsonFlow          `json:"transport"`
+	Seen      time.Time         `json:"seen"`
+	Interface string            `json:"interface,omitempty"`
+	Tunnel    *jsonTunnel       `json:"tunnel,omitempty"`
+	Protocol  string            `json:"protocol,omitempty"`
+	ConnID    uint64            `json:"conn_id,omitempty"`
+	Direction string            `json:"direction"`
+	LatencyUS int64             `json:"latency_us,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Skip      int               `json:"skip,omitempty"`
+	Length    int               `json:"length,omitempty"`
+	CapLen    int               `json:"capture_length,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Seq       uint32            `json:"seq,omitempty"`
+	SeqEnd    uint32            `json:"seq_end,omitempty"`
+	Buffer    string            `json:"buffer"`
+	Bodies    []json.RawMessage `json:"bodies"`
+}
+
+func record2JSON(record *Record) *jsonRecord {
+	r := &jsonRecord{
+		Type: record.Type.String(),
+		Net: jsonFlow{
+			Src: record.Net.Src().String(),
+			Dst: record.Net.Dst().String(),
+		},
+		Transport: jsonFlow{
+			Src: record.Transport.Src().String(),
+			Dst: record.Transport.Dst().String(),
+		},
+		Seen:      record.Seen,
+		Interface: record.Interface,
+		Protocol:  record.Protocol,
+		ConnID:    record.ConnID,
+		Direction: record.Direction.String(),
+		LatencyUS: int64(record.Latency / time.Microsecond),
+		Skip:      record.Skip,
+		Length:    record.Length,
+		CapLen:    record.CaptureLength,
+		Truncated: record.Truncated,
+		Seq:       record.Seq,
+		SeqEnd:    record.SeqEnd,
+		Buffer:    hex.EncodeToString(record.Buffer),
+		Bodies:    make([]json.RawMessage, 0, len(record.Bodies)),
+	}
+
+	if record.Err != nil {
+		r.Error = record.Err.Error()
+	}
+	if t := record.Tunnel; t != nil {
+		r.Tunnel = &jsonTunnel{
+			Type: t.Type,
+			Net:  jsonFlow{Src: t.Net.Src().String(), Dst: t.Net.Dst().String()},
+			ID:   t.ID,
+		}
+		if t.Transport != (gopacket.Flow{}) {
+			r.Tunnel.Transport = &jsonFlow{Src: t.Transport.Src().String(), Dst: t.Transport.Dst().String()}
+		}
+	}
+
+	for _, body := range record.Bodies {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			// keep the line valid even if the body can't be marshaled
+			log.Debugf("marshal body failed, err: %v", err)
+			buf, _ = json.Marshal(fmt.Sprintf("%+v", body))
+		}
+		r.Bodies = append(r.Bodies, buf)
+	}
+	return r
+}
+
+// jsonWriter write every record as a line of JSON
+type jsonWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// newJSONWriter create a writer to the file, `-` is the stdout.
+func newJSONWriter(filename string) (*jsonWriter, error) {
+	var w io.WriteCloser
+	if filename == "-" {
+		w = os.Stdout
+	} else {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	return &jsonWriter{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}, nil
+}
+
+func (w *jsonWriter) Update(record *Record) {
+	if record == nil {
+		return
+	}
+
+	err := w.enc.Encode(record2JSON(record))
+	if err != nil {
+		log.Errorf("write record failed, err: %v", err)
+	}
+}
+
+func (w *jsonWriter) Close() error {
+	if w.w == os.Stdout {
+		return nil
+	}
+	return w.w.Close()
+}
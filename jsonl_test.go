@@ -0,0 +1,78 @@
+package fdump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord2JSON(t *testing.T) {
+	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
+	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
+	transportSrc := layers.NewTCPPortEndpoint(50123)
+	transportDst := layers.NewTCPPortEndpoint(20001)
+	net, err := gopacket.FlowFromEndpoints(netSrc, netDst)
+	assert.NoError(t, err)
+	transport, err := gopacket.FlowFromEndpoints(transportSrc, transportDst)
+	assert.NoError(t, err)
+	seen := time.Now()
+	record := &Record{
+		Type:      RecordTypeTCP,
+		Net:       net,
+		Transport: transport,
+		Seen:      seen,
+		Bodies:    []interface{}{"hello", func() {}},
+		Buffer:    []byte{1, 2, 0xab},
+	}
+
+	r := record2JSON(record)
+	assert.Equal(t, "tcp", r.Type)
+	assert.Equal(t, "127.0.0.1", r.Net.Src)
+	assert.Equal(t, "10.2.2.2", r.Net.Dst)
+	assert.Equal(t, "50123", r.Transport.Src)
+	assert.Equal(t, "20001", r.Transport.Dst)
+	assert.Equal(t, seen, r.Seen)
+	assert.Equal(t, "0102ab", r.Buffer)
+	assert.Equal(t, 2, len(r.Bodies))
+	assert.Equal(t, `"hello"`, string(r.Bodies[0]))
+	assert.True(t, json.Valid(r.Bodies[1]))
+	assert.Nil(t, r.Tunnel)
+
+	record.Tunnel = &Tunnel{Type: TunnelGRE, Net: net, ID: 7}
+	r = record2JSON(record)
+	assert.Equal(t, &jsonTunnel{Type: "gre", Net: jsonFlow{Src: "127.0.0.1", Dst: "10.2.2.2"}, ID: 7}, r.Tunnel)
+}
+
+func TestJSONWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "records.jsonl")
+	w, err := newJSONWriter(filename)
+	assert.NoError(t, err)
+
+	w.Update(&Record{Type: RecordTypeUDP, Bodies: []interface{}{1}})
+	w.Update(nil)
+	w.Update(&Record{Type: RecordTypeTCP, Bodies: []interface{}{2}})
+	assert.NoError(t, w.Close())
+
+	buf, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	r := &jsonRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), r))
+	assert.Equal(t, "udp", r.Type)
+	assert.Equal(t, `1`, string(r.Bodies[0]))
+}
@@ -0,0 +1,750 @@
+// Package layout encode and decode the binary frames by the tags of a struct,
+// so the frame header needn't be encoded and decoded field by field. The
+// fields are laid out in order, the numbers use the network byte order by
+// default.
+//
+//	type Header struct {
+//		Len     uint32 `fdump:"frame"` // the length of the whole frame
+//		Command uint16 `fdump:"little"`
+//		NameLen uint8
+//		Name    string `fdump:"len=NameLen"`
+//		Magic   [4]byte
+//		Body    []byte `fdump:"rest"`
+//	}
+//
+//	codec := layout.MustNew(&Header{})
+//	a := fdump.NewApp(codec.DecodeFunc(), brief, detail, &fdump.ReplayHook{PreSend: codec.PreSendHook()}, briefAttributes)
+//
+// The options of the `fdump` tag, separated by comma, the field tagged with
+// `fdump:"-"` is ignored:
+//
+//	big       big endian, the default
+//	little    little endian, a struct field passes it to its fields
+//	size=N    the byte size of an int or uint, or the fixed length of a string
+//	          or []byte, a fixed string is padded with NUL
+//	len=Field the length of a string or []byte, or the count of a slice, is the
+//	          value of the Field before it, the Field is set on encoding
+//	frame     the field holds the length of the whole frame, it must be an
+//	          integer of the top struct and the fields before it are fixed
+//	frame=F   the field F of a struct field holds the length of the frame,
+//	          such as a header struct embedded in the frame struct
+//	adjust=N  add N to the frame length, such as the length of a header if the
+//	          frame field only counts the body
+//	rest      a string or []byte takes the remaining bytes of the frame, it
+//	          must be the last field and a frame field is required
+//
+// The supported field types are bool, the fixed size integers, the floats,
+// string, []byte, the arrays, the slices and the structs.
+package layout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+)
+
+// ErrMalformed the frame doesn't match the layout.
+var ErrMalformed = errors.New("malformed frame")
+
+const tagName = "fdump"
+
+type fieldKind int
+
+const (
+	kindNumber fieldKind = iota
+	kindBytes
+	kindArray
+	kindSlice
+	kindStruct
+)
+
+type field struct {
+	name   string
+	index  int
+	typ    reflect.Type
+	kind   fieldKind
+	order  binary.ByteOrder
+	size   int
+	length int // the index of the field holds the length, -1 if none
+	rest   bool
+	frame  bool
+	// frameName the field holds the frame length of a struct field
+	frameName string
+	adjust    int
+	elem      *field
+	layout    *structLayout
+}
+
+type structLayout struct {
+	typ    reflect.Type
+	fields []*field
+	// lengthOf the index of the field holding the length to the field
+	lengthOf map[int]*field
+}
+
+// Codec encode and decode the frames of a struct type.
+type Codec struct {
+	typ    reflect.Type
+	layout *structLayout
+	// frame the field holds the frame length and its offset
+	frame       *field
+	frameOffset int
+	adjust      int
+}
+
+// New returns a Codec of the type of v, v is a struct or a pointer to struct.
+func New(v interface{}) (*Codec, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("layout: %v isn't a struct", typ)
+	}
+
+	l, err := newStructLayout(typ, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	c := &Codec{typ: typ, layout: l}
+
+	offset := 0
+	for i, f := range l.fields {
+		if f.rest && i != len(l.fields)-1 {
+			return nil, fmt.Errorf("layout: the rest field %s isn't the last field", f.name)
+		}
+		if f.frame {
+			if c.frame != nil {
+				return nil, fmt.Errorf("layout: more than one frame fields")
+			}
+			if offset < 0 {
+				return nil, fmt.Errorf("layout: the fields before the frame field %s aren't fixed", f.name)
+			}
+			c.frame = f
+			c.frameOffset = offset
+			c.adjust = f.adjust
+			if f.frameName != "" {
+				sub, subOffset := f.layout.find(f.frameName)
+				if sub == nil || !sub.isInteger() || subOffset < 0 {
+					return nil, fmt.Errorf("layout: the frame field %s of %s isn't a fixed integer", f.frameName, f.name)
+				}
+				c.frame = sub
+				c.frameOffset += subOffset
+			}
+		}
+		if offset >= 0 {
+			if size := f.fixedSize(); size >= 0 {
+				offset += size
+			} else {
+				offset = -1
+			}
+		}
+	}
+	if c.frame == nil {
+		for _, f := range l.fields {
+			if f.rest {
+				return nil, fmt.Errorf("layout: the rest field %s needs a frame field", f.name)
+			}
+		}
+	}
+	return c, nil
+}
+
+// MustNew like New but panics if v can't be laid out.
+func MustNew(v interface{}) *Codec {
+	c, err := New(v)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func newStructLayout(typ reflect.Type, order binary.ByteOrder) (*structLayout, error) {
+	l := &structLayout{typ: typ, lengthOf: make(map[int]*field)}
+	names := make(map[string]int)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get(tagName)
+		if tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		f := &field{name: sf.Name, index: i, typ: sf.Type, order: order, length: -1}
+		lengthName := ""
+		for _, opt := range strings.Split(tag, ",") {
+			key, value := opt, ""
+			if j := strings.Index(opt, "="); j >= 0 {
+				key, value = opt[:j], opt[j+1:]
+			}
+			var err error
+			switch key {
+			case "":
+			case "big":
+				f.order = binary.BigEndian
+			case "little":
+				f.order = binary.LittleEndian
+			case "size":
+				f.size, err = strconv.Atoi(value)
+				if err == nil && f.size <= 0 {
+					err = errors.New("not positive")
+				}
+			case "len":
+				lengthName = value
+			case "frame":
+				f.frame = true
+				f.frameName = value
+			case "adjust":
+				f.adjust, err = strconv.Atoi(value)
+			case "rest":
+				f.rest = true
+			default:
+				err = errors.New("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("layout: the option %q of the field %s: %v", opt, sf.Name, err)
+			}
+		}
+
+		if err := f.init(f.typ); err != nil {
+			return nil, fmt.Errorf("layout: the field %s: %v", sf.Name, err)
+		}
+		if lengthName != "" {
+			j, ok := names[lengthName]
+			if !ok {
+				return nil, fmt.Errorf("layout: the length field %s of %s isn't before it", lengthName, sf.Name)
+			}
+			holder := l.fields[j]
+			if !holder.isInteger() {
+				return nil, fmt.Errorf("layout: the length field %s isn't an integer", lengthName)
+			}
+			if (f.kind != kindBytes && f.kind != kindSlice) || (f.kind == kindBytes && f.size > 0) || f.rest {
+				return nil, fmt.Errorf("layout: the field %s can't have a length field", sf.Name)
+			}
+			f.length = holder.index
+			l.lengthOf[holder.index] = f
+		}
+		if f.frame && f.frameName != "" && f.kind != kindStruct {
+			return nil, fmt.Errorf("layout: the field %s isn't a struct", sf.Name)
+		}
+		if f.frame && f.frameName == "" && !f.isInteger() {
+			return nil, fmt.Errorf("layout: the frame field %s isn't an integer", sf.Name)
+		}
+		if f.rest && f.kind != kindBytes {
+			return nil, fmt.Errorf("layout: the rest field %s isn't a string or []byte", sf.Name)
+		}
+		if f.kind == kindBytes && f.size == 0 && lengthName == "" && !f.rest {
+			return nil, fmt.Errorf("layout: the length of the field %s is unknown", sf.Name)
+		}
+		if f.kind == kindSlice && lengthName == "" {
+			return nil, fmt.Errorf("layout: the count of the field %s is unknown", sf.Name)
+		}
+
+		names[sf.Name] = len(l.fields)
+		l.fields = append(l.fields, f)
+	}
+	return l, nil
+}
+
+// init set the kind and the size of f by typ.
+func (f *field) init(typ reflect.Type) error {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		f.kind = kindNumber
+		f.size = 1
+	case reflect.Int16, reflect.Uint16:
+		f.kind = kindNumber
+		f.size = 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		f.kind = kindNumber
+		f.size = 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		f.kind = kindNumber
+		f.size = 8
+	case reflect.Int, reflect.Uint:
+		f.kind = kindNumber
+		if f.size != 1 && f.size != 2 && f.size != 4 && f.size != 8 {
+			return errors.New("the size of int and uint must be 1, 2, 4 or 8")
+		}
+	case reflect.String:
+		f.kind = kindBytes
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			f.kind = kindBytes
+			return nil
+		}
+		f.kind = kindSlice
+		return f.initElem(typ.Elem())
+	case reflect.Array:
+		f.kind = kindArray
+		return f.initElem(typ.Elem())
+	case reflect.Struct:
+		f.kind = kindStruct
+		l, err := newStructLayout(typ, f.order)
+		if err != nil {
+			return err
+		}
+		for _, sub := range l.fields {
+			if sub.frame || sub.rest {
+				return fmt.Errorf("the nested field %s can't be a frame or rest field", sub.name)
+			}
+		}
+		f.layout = l
+	default:
+		return fmt.Errorf("the type %v isn't supported", typ)
+	}
+	return nil
+}
+
+func (f *field) initElem(typ reflect.Type) error {
+	f.elem = &field{name: f.name, typ: typ, order: f.order, length: -1}
+	if typ.Kind() == reflect.Int || typ.Kind() == reflect.Uint {
+		f.elem.size = f.size
+	}
+	if err := f.elem.init(typ); err != nil {
+		return err
+	}
+	// the elements have no length field, and an element of 0 byte makes a
+	// huge count cost nothing in the buffer
+	if (f.elem.kind == kindBytes && f.elem.size == 0) || f.elem.kind == kindSlice {
+		return fmt.Errorf("the length of the elements of %s is unknown", f.name)
+	}
+	if f.elem.minSize() == 0 {
+		return fmt.Errorf("the elements of %s are empty", f.name)
+	}
+	return nil
+}
+
+func (f *field) isInteger() bool {
+	switch f.typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// fixedSize returns the byte size of the field, -1 if it's variable.
+func (f *field) fixedSize() int {
+	switch f.kind {
+	case kindNumber:
+		return f.size
+	case kindBytes:
+		if f.size > 0 {
+			return f.size
+		}
+	case kindArray:
+		if size := f.elem.fixedSize(); size >= 0 {
+			return size * f.typ.Len()
+		}
+	case kindStruct:
+		return f.layout.fixedSize()
+	}
+	return -1
+}
+
+// minSize returns the minimum byte size of the field.
+func (f *field) minSize() int {
+	switch f.kind {
+	case kindNumber:
+		return f.size
+	case kindBytes:
+		return f.size
+	case kindArray:
+		return f.elem.minSize() * f.typ.Len()
+	case kindStruct:
+		size := 0
+		for _, sub := range f.layout.fields {
+			size += sub.minSize()
+		}
+		return size
+	}
+	return 0
+}
+
+// find returns the field of the name and its offset, the offset is -1 if
+// the fields before it aren't fixed.
+func (l *structLayout) find(name string) (*field, int) {
+	offset := 0
+	for _, f := range l.fields {
+		if f.name == name {
+			return f, offset
+		}
+		if size := f.fixedSize(); size >= 0 && offset >= 0 {
+			offset += size
+		} else {
+			offset = -1
+		}
+	}
+	return nil, -1
+}
+
+func (l *structLayout) fixedSize() int {
+	size := 0
+	for _, f := range l.fields {
+		s := f.fixedSize()
+		if s < 0 {
+			return -1
+		}
+		size += s
+	}
+	return size
+}
+
+// Unmarshal decode a frame at the beginning of buf to v, v is a pointer to
+// the struct of the codec. It returns the byte size of the frame.
+// ErrPkgNoEnough is returned if buf doesn't contain a whole frame.
+func (c *Codec) Unmarshal(buf []byte, v interface{}) (n int, err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != c.typ {
+		return 0, fmt.Errorf("layout: %T isn't a pointer to %v", v, c.typ)
+	}
+
+	d := &decoder{buf: buf}
+	if c.frame != nil {
+		end := c.frameOffset + c.frame.size
+		if len(buf) < end {
+			return 0, fdump.ErrPkgNoEnough
+		}
+		length := int64(c.frame.uint(buf[c.frameOffset:])) + int64(c.adjust)
+		if length < int64(end) || length > math.MaxInt32 {
+			return 0, ErrMalformed
+		}
+		if int64(len(buf)) < length {
+			return 0, fdump.ErrPkgNoEnough
+		}
+		d.buf = buf[:length]
+		d.framed = true
+	}
+
+	// decode to a new value, v is untouched if the frame isn't decoded
+	nv := reflect.New(c.typ).Elem()
+	if err = d.decodeStruct(c.layout, nv); err != nil {
+		return 0, err
+	}
+	rv.Elem().Set(nv)
+	if d.framed {
+		return len(d.buf), nil
+	}
+	return d.off, nil
+}
+
+// Decode decode a frame at the beginning of buf to a new pointer to the
+// struct of the codec.
+func (c *Codec) Decode(buf []byte) (v interface{}, n int, err error) {
+	v = reflect.New(c.typ).Interface()
+	n, err = c.Unmarshal(buf, v)
+	if err != nil {
+		v = nil
+	}
+	return
+}
+
+// DecodeFunc returns a fdump.DecodeFunc decode a frame to a pointer to the
+// struct of the codec as the body of the record.
+func (c *Codec) DecodeFunc() fdump.DecodeFunc {
+	return func(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+		v, n, err := c.Decode(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []interface{}{v}, n, nil
+	}
+}
+
+// Marshal encode v, the struct of the codec or a pointer to it, to a frame.
+// The length fields and the frame field are set by the fields they describe,
+// v isn't modified.
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Type() != c.typ {
+		return nil, fmt.Errorf("layout: %T isn't %v", v, c.typ)
+	}
+
+	e := &encoder{}
+	if err := e.encodeStruct(c.layout, rv); err != nil {
+		return nil, err
+	}
+	if c.frame != nil {
+		length := int64(len(e.buf)) - int64(c.adjust)
+		if length < 0 || !c.frame.fits(uint64(length)) {
+			return nil, fmt.Errorf("layout: the frame length %d overflows the field %s", length, c.frame.name)
+		}
+		c.frame.putUint(e.buf[c.frameOffset:], uint64(length))
+	}
+	return e.buf, nil
+}
+
+// PreSendHook returns a fdump.PreSendHook encode the first body of the
+// struct of the codec to the buffer of the record before it's replayed, so
+// the edited fields are sent.
+func (c *Codec) PreSendHook() fdump.PreSendHook {
+	return func(conn net.Conn, record *fdump.Record) error {
+		for _, body := range record.Bodies {
+			rv := reflect.ValueOf(body)
+			if rv.Kind() != reflect.Ptr || rv.Elem().Type() != c.typ {
+				continue
+			}
+			buf, err := c.Marshal(body)
+			if err != nil {
+				return err
+			}
+			record.Buffer = buf
+			return nil
+		}
+		return nil
+	}
+}
+
+type decoder struct {
+	buf    []byte
+	off    int
+	framed bool
+}
+
+// need check there are n bytes left.
+func (d *decoder) need(n int) error {
+	if n <= len(d.buf)-d.off {
+		return nil
+	}
+	if d.framed {
+		// the frame is whole, the field is out of it
+		return ErrMalformed
+	}
+	return fdump.ErrPkgNoEnough
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if err := d.need(n); err != nil {
+		return nil, err
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decoder) decodeStruct(l *structLayout, v reflect.Value) error {
+	for _, f := range l.fields {
+		fv := v.Field(f.index)
+		length := -1
+		if f.length >= 0 {
+			lv := v.Field(f.length)
+			if lv.Kind() >= reflect.Int && lv.Kind() <= reflect.Int64 {
+				if lv.Int() < 0 {
+					return ErrMalformed
+				}
+				length = int(lv.Int())
+			} else {
+				if lv.Uint() > math.MaxInt32 {
+					return ErrMalformed
+				}
+				length = int(lv.Uint())
+			}
+		}
+		if err := d.decodeField(f, fv, length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeField(f *field, v reflect.Value, length int) error {
+	switch f.kind {
+	case kindNumber:
+		b, err := d.next(f.size)
+		if err != nil {
+			return err
+		}
+		f.setNumber(v, f.uint(b))
+	case kindBytes:
+		switch {
+		case f.rest:
+			length = len(d.buf) - d.off
+		case length < 0:
+			length = f.size
+		}
+		b, err := d.next(length)
+		if err != nil {
+			return err
+		}
+		if f.typ.Kind() == reflect.String {
+			if f.size > 0 {
+				b = trimNUL(b)
+			}
+			v.SetString(string(b))
+		} else {
+			v.SetBytes(append([]byte{}, b...))
+		}
+	case kindArray:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decodeField(f.elem, v.Index(i), -1); err != nil {
+				return err
+			}
+		}
+	case kindSlice:
+		// check the bytes before allocating the elements
+		if err := d.need(length * f.elem.minSize()); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(f.typ, length, length)
+		for i := 0; i < length; i++ {
+			if err := d.decodeField(f.elem, s.Index(i), -1); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case kindStruct:
+		return d.decodeStruct(f.layout, v)
+	}
+	return nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encodeStruct(l *structLayout, v reflect.Value) error {
+	for _, f := range l.fields {
+		fv := v.Field(f.index)
+		if target, ok := l.lengthOf[f.index]; ok {
+			length := v.Field(target.index).Len()
+			if !f.fits(uint64(length)) {
+				return fmt.Errorf("layout: the length %d of %s overflows the field %s", length, target.name, f.name)
+			}
+			b := make([]byte, f.size)
+			f.putUint(b, uint64(length))
+			e.buf = append(e.buf, b...)
+			continue
+		}
+		if err := e.encodeField(f, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeField(f *field, v reflect.Value) error {
+	switch f.kind {
+	case kindNumber:
+		b := make([]byte, f.size)
+		f.putUint(b, f.number(v))
+		e.buf = append(e.buf, b...)
+	case kindBytes:
+		var b []byte
+		if f.typ.Kind() == reflect.String {
+			b = []byte(v.String())
+		} else {
+			b = v.Bytes()
+		}
+		if f.size > 0 && f.length < 0 {
+			if len(b) > f.size {
+				return fmt.Errorf("layout: the length %d of %s is larger than %d", len(b), f.name, f.size)
+			}
+			e.buf = append(e.buf, b...)
+			e.buf = append(e.buf, make([]byte, f.size-len(b))...)
+			return nil
+		}
+		e.buf = append(e.buf, b...)
+	case kindArray, kindSlice:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encodeField(f.elem, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case kindStruct:
+		return e.encodeStruct(f.layout, v)
+	}
+	return nil
+}
+
+// uint read an unsigned number of the size of f from b.
+func (f *field) uint(b []byte) uint64 {
+	switch f.size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(f.order.Uint16(b))
+	case 4:
+		return uint64(f.order.Uint32(b))
+	}
+	return f.order.Uint64(b)
+}
+
+func (f *field) putUint(b []byte, u uint64) {
+	switch f.size {
+	case 1:
+		b[0] = byte(u)
+	case 2:
+		f.order.PutUint16(b, uint16(u))
+	case 4:
+		f.order.PutUint32(b, uint32(u))
+	default:
+		f.order.PutUint64(b, u)
+	}
+}
+
+// fits check u can be stored in the field.
+func (f *field) fits(u uint64) bool {
+	if f.size >= 8 {
+		return true
+	}
+	bits := uint(f.size * 8)
+	switch f.typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits--
+	}
+	return u < 1<<bits
+}
+
+// setNumber set the raw bits u to v.
+func (f *field) setNumber(v reflect.Value, u uint64) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// sign extend
+		shift := uint(64 - f.size*8)
+		v.SetInt(int64(u<<shift) >> shift)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(u)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(u))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(u))
+	}
+}
+
+// number returns the raw bits of v.
+func (f *field) number(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Float32:
+		return uint64(math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		return math.Float64bits(v.Float())
+	}
+	return v.Uint()
+}
+
+func trimNUL(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,219 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+type testItem struct {
+	ID    uint16 `fdump:"little"`
+	Score float32
+}
+
+type testFrame struct {
+	Len     uint32 `fdump:"frame"`
+	Command uint16 `fdump:"little"`
+	Flag    bool
+	Code    int8
+	Magic   [2]byte
+	Tag     string `fdump:"size=4"`
+	Seq     int    `fdump:"size=2"`
+	NameLen uint8
+	Name    string `fdump:"len=NameLen"`
+	Count   uint8
+	Items   []testItem `fdump:"len=Count"`
+	ignored int
+	Skip    int    `fdump:"-"`
+	Body    []byte `fdump:"rest"`
+}
+
+func testValue() *testFrame {
+	return &testFrame{
+		Command: 0x0102,
+		Flag:    true,
+		Code:    -2,
+		Magic:   [2]byte{0xca, 0xfe},
+		Tag:     "ab",
+		Seq:     -1,
+		Name:    "foo",
+		Items:   []testItem{{ID: 1, Score: 1.5}, {ID: 2, Score: -2}},
+		Body:    []byte("hello"),
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	codec, err := New(testFrame{})
+	assert.NoError(t, err)
+
+	buf, err := codec.Marshal(testValue())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0, 0, 0, 38, // frame
+		0x02, 0x01, // little endian
+		1, 0xfe, 0xca, 0xfe,
+		'a', 'b', 0, 0, // padded
+		0xff, 0xff,
+		3, 'f', 'o', 'o',
+		2, 1, 0, 0x3f, 0xc0, 0, 0, 2, 0, 0xc0, 0, 0, 0,
+		'h', 'e', 'l', 'l', 'o',
+	}, buf)
+
+	for i := 0; i < len(buf); i++ {
+		_, _, err := codec.Decode(buf[:i])
+		assert.Equal(t, fdump.ErrPkgNoEnough, err, "length %d", i)
+	}
+
+	v, n, err := codec.Decode(append(buf, 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	expect := testValue()
+	expect.Len = 38
+	expect.NameLen = 3
+	expect.Count = 2
+	assert.Equal(t, expect, v)
+
+	bodies, n, err := codec.DecodeFunc()(gopacket.Flow{}, gopacket.Flow{}, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, []interface{}{expect}, bodies)
+
+	// edit the body and replay it
+	bodies[0].(*testFrame).Body = []byte("hi")
+	record := &fdump.Record{Bodies: bodies, Buffer: buf}
+	assert.NoError(t, codec.PreSendHook()(nil, record))
+	assert.Len(t, record.Buffer, 35)
+	assert.Equal(t, byte(35), record.Buffer[3])
+}
+
+func TestUnframed(t *testing.T) {
+	type header struct {
+		Len  uint16 `fdump:"little"`
+		Data []byte `fdump:"len=Len"`
+	}
+	codec := MustNew(&header{})
+	buf, err := codec.Marshal(header{Data: []byte("abc")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3, 0, 'a', 'b', 'c'}, buf)
+
+	h := &header{}
+	_, err = codec.Unmarshal(buf[:4], h)
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	n, err := codec.Unmarshal(append(buf, 'd'), h)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("abc"), h.Data)
+}
+
+func TestEmbeddedFrame(t *testing.T) {
+	type Header struct {
+		Magic uint16
+		Len   uint16
+	}
+	type packet struct {
+		Header `fdump:"frame=Len"`
+		Body   string `fdump:"rest"`
+	}
+	codec := MustNew(packet{})
+	buf, err := codec.Marshal(packet{Header: Header{Magic: 1}, Body: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 7, 'a', 'b', 'c'}, buf)
+
+	p := &packet{}
+	_, err = codec.Unmarshal(buf[:6], p)
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	n, err := codec.Unmarshal(append(buf, 'd'), p)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, &packet{Header: Header{Magic: 1, Len: 7}, Body: "abc"}, p)
+}
+
+func TestMalformed(t *testing.T) {
+	codec := MustNew(&testFrame{})
+	// the frame is shorter than the frame field
+	_, _, err := codec.Decode([]byte{0, 0, 0, 2, 0, 0})
+	assert.Equal(t, ErrMalformed, err)
+
+	// the name is out of the frame
+	buf, err := codec.Marshal(testValue())
+	assert.NoError(t, err)
+	buf[3] = 18
+	buf[16] = 200
+	_, _, err = codec.Decode(buf)
+	assert.Equal(t, ErrMalformed, err)
+
+	// the adjusted frame length counts the body only
+	type adjusted struct {
+		Len  uint8  `fdump:"frame,adjust=1"`
+		Body []byte `fdump:"rest"`
+	}
+	c := MustNew(adjusted{})
+	buf, err = c.Marshal(adjusted{Body: []byte("ab")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 'a', 'b'}, buf)
+	_, err = c.Marshal(adjusted{Body: make([]byte, 300)})
+	assert.Error(t, err)
+}
+
+func TestNewError(t *testing.T) {
+	cases := []interface{}{
+		1,
+		struct{ A int }{},
+		struct{ A string }{},
+		struct{ A []uint16 }{},
+		struct {
+			A []byte `fdump:"rest"`
+		}{},
+		struct {
+			A string `fdump:"len=B"`
+			B uint8
+		}{},
+		struct {
+			A string `fdump:"len=B"`
+			B uint8  `fdump:"frame"`
+		}{},
+		struct {
+			A uint8
+			B []byte `fdump:"len=A"`
+			C uint8  `fdump:"frame"`
+		}{},
+		struct {
+			A uint8 `fdump:"unknown"`
+		}{},
+		struct {
+			A map[string]string
+		}{},
+		// the elements are variable
+		struct {
+			A uint8
+			B []string `fdump:"len=A"`
+		}{},
+		struct {
+			A uint8
+			B [][]byte `fdump:"len=A"`
+		}{},
+		struct {
+			A [2]string
+		}{},
+		struct {
+			A uint8
+			B [][]uint16 `fdump:"len=A"`
+		}{},
+		// the elements are empty
+		struct {
+			A uint8
+			B []struct{} `fdump:"len=A"`
+		}{},
+		struct {
+			A uint8
+			B [][0]uint16 `fdump:"len=A"`
+		}{},
+	}
+	for i, c := range cases {
+		_, err := New(c)
+		assert.Error(t, err, "case %d", i)
+	}
+	assert.Panics(t, func() { MustNew(1) })
+}
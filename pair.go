@@ -0,0 +1,95 @@
+package fdump
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// PairFunc returns the key to pair a request with its response and whether
+// the record is a request. The key, such as a sequence or a command field of
+// the bodies, only needs to be unique in a connection, the flows are part of
+// the pair key. Return an empty key if the record can't be paired.
+type PairFunc func(record *Record) (key string, isRequest bool)
+
+// pairTimeout the request will be forgotten if there is no response in the
+// duration.
+const pairTimeout = time.Minute
+
+type pairKey struct {
+	net       gopacket.Flow
+	transport gopacket.Flow
+	key       string
+}
+
+// pairer links every response to its request.
+type pairer struct {
+	pairFunc  PairFunc
+	requests  map[pairKey]*Record
+	lastSweep time.Time
+}
+
+func newPairer(pairFunc PairFunc) *pairer {
+	return &pairer{
+		pairFunc: pairFunc,
+		requests: make(map[pairKey]*Record),
+	}
+}
+
+// pair set the Pair and Latency of the record if it's the response of a
+// request seen before. The Pair of the request is left to the consumer of the
+// records, because the request may have been published already.
+func (p *pairer) pair(record *Record) {
+	key, isRequest := p.pairFunc(record)
+	if key == "" {
+		return
+	}
+
+	if isRequest {
+		p.sweep(record.Seen)
+		p.requests[pairKey{record.Net, record.Transport, key}] = record
+		return
+	}
+
+	k := pairKey{record.Net.Reverse(), record.Transport.Reverse(), key}
+	request, ok := p.requests[k]
+	if !ok {
+		return
+	}
+	delete(p.requests, k)
+
+	record.Pair = request
+	record.Latency = record.Seen.Sub(request.Seen)
+}
+
+// sweep forget the requests without response in pairTimeout
+func (p *pairer) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < pairTimeout {
+		return
+	}
+	p.lastSweep = now
+
+	for k, request := range p.requests {
+		if now.Sub(request.Seen) > pairTimeout {
+			delete(p.requests, k)
+		}
+	}
+}
+
+// pairRecords pair the records and link the requests to the responses.
+func pairRecords(records []*Record, pairFunc PairFunc) {
+	if pairFunc == nil {
+		return
+	}
+
+	p := newPairer(pairFunc)
+	for _, record := range records {
+		if record.Err != nil || record.Type == RecordTypeConn {
+			continue
+		}
+		p.pair(record)
+		if record.Pair != nil {
+			record.Pair.Pair = record
+		}
+	}
+}
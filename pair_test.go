@@ -0,0 +1,54 @@
+package fdump
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPairFunc the body is "req:<key>" or "rsp:<key>"
+func testPairFunc(record *Record) (string, bool) {
+	if len(record.Bodies) == 0 {
+		return "", false
+	}
+	str := record.Bodies[0].(string)
+	return str[4:], strings.HasPrefix(str, "req:")
+}
+
+func TestPairRecords(t *testing.T) {
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	now := time.Now()
+	req1 := &Record{Net: netFlow, Transport: transportFlow, Seen: now, Bodies: []interface{}{"req:1"}}
+	req2 := &Record{Net: netFlow, Transport: transportFlow, Seen: now, Bodies: []interface{}{"req:2"}}
+	rsp2 := &Record{Net: netFlow.Reverse(), Transport: transportFlow.Reverse(), Seen: now.Add(time.Millisecond), Bodies: []interface{}{"rsp:2"}}
+	// the same direction as the request, it's not a response
+	rsp1 := &Record{Net: netFlow, Transport: transportFlow, Seen: now.Add(time.Second), Bodies: []interface{}{"rsp:1"}}
+	other := &Record{Net: netFlow, Transport: transportFlow, Seen: now}
+
+	pairRecords([]*Record{req1, req2, rsp2, rsp1, other}, testPairFunc)
+	assert.Equal(t, rsp2, req2.Pair)
+	assert.Equal(t, req2, rsp2.Pair)
+	assert.Equal(t, time.Millisecond, rsp2.Latency)
+	assert.Equal(t, time.Duration(0), req2.Latency)
+	assert.Nil(t, req1.Pair)
+	assert.Nil(t, rsp1.Pair)
+	assert.Nil(t, other.Pair)
+}
+
+func TestPairerSweep(t *testing.T) {
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	now := time.Now()
+	p := newPairer(testPairFunc)
+	p.pair(&Record{Net: netFlow, Transport: transportFlow, Seen: now, Bodies: []interface{}{"req:1"}})
+	assert.Equal(t, 1, len(p.requests))
+
+	p.pair(&Record{Net: netFlow, Transport: transportFlow, Seen: now.Add(2 * pairTimeout), Bodies: []interface{}{"req:2"}})
+	assert.Equal(t, 1, len(p.requests))
+
+	rsp := &Record{Net: netFlow.Reverse(), Transport: transportFlow.Reverse(), Seen: now.Add(2 * pairTimeout), Bodies: []interface{}{"rsp:1"}}
+	p.pair(rsp)
+	assert.Nil(t, rsp.Pair)
+}
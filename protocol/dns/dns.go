@@ -0,0 +1,253 @@
+// Package dns decode the DNS messages for fdump by the layers.DNS of the
+// gopacket. A message is the payload of an udp packet, or has a 2 bytes length
+// prefix in a tcp stream. It's also the reference of the decoders of the udp
+// protocols.
+//
+// Use the Decode with fdump.NewApp:
+//
+//	a := fdump.NewApp(dns.Decode, dns.Brief, dns.Detail, nil, dns.BriefAttributes())
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/tenfyzhong/fdump"
+)
+
+var rcodeNames = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeNoErr:    "NOERROR",
+	layers.DNSResponseCodeFormErr:  "FORMERR",
+	layers.DNSResponseCodeServFail: "SERVFAIL",
+	layers.DNSResponseCodeNXDomain: "NXDOMAIN",
+	layers.DNSResponseCodeNotImp:   "NOTIMP",
+	layers.DNSResponseCodeRefused:  "REFUSED",
+	layers.DNSResponseCodeYXDomain: "YXDOMAIN",
+	layers.DNSResponseCodeYXRRSet:  "YXRRSET",
+	layers.DNSResponseCodeNXRRSet:  "NXRRSET",
+	layers.DNSResponseCodeNotAuth:  "NOTAUTH",
+	layers.DNSResponseCodeNotZone:  "NOTZONE",
+	layers.DNSResponseCodeBadVers:  "BADVERS",
+}
+
+var opcodeNames = map[layers.DNSOpCode]string{
+	layers.DNSOpCodeQuery:  "QUERY",
+	layers.DNSOpCodeIQuery: "IQUERY",
+	layers.DNSOpCodeStatus: "STATUS",
+	layers.DNSOpCodeNotify: "NOTIFY",
+	layers.DNSOpCodeUpdate: "UPDATE",
+}
+
+// RCode returns the name of the response code as the dig
+func RCode(rcode layers.DNSResponseCode) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+func opcode(opcode layers.DNSOpCode) string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPCODE%d", opcode)
+}
+
+// Decode decode a DNS message to a *layers.DNS, it implements the
+// fdump.DecodeFunc.
+func Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	data := buf
+	if transport.EndpointType() == layers.EndpointTCPPort {
+		if len(buf) < 2 {
+			err = fdump.ErrPkgNoEnough
+			return
+		}
+		length := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+length {
+			err = fdump.ErrPkgNoEnough
+			return
+		}
+		data = buf[2 : 2+length]
+		n = 2 + length
+	} else {
+		n = len(buf)
+	}
+
+	dns := &layers.DNS{}
+	err = dns.DecodeFromBytes(data, gopacket.NilDecodeFeedback)
+	if err != nil {
+		n = 0
+		return
+	}
+	bodies = append(bodies, dns)
+	return
+}
+
+// BriefAttributes returns the brief columns of the Brief
+func BriefAttributes() []*fdump.BriefColumnAttribute {
+	return []*fdump.BriefColumnAttribute{
+		&fdump.BriefColumnAttribute{
+			Title:    "Name",
+			MaxWidth: 40,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Type",
+			MaxWidth: 6,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "RCode",
+			MaxWidth: 9,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Answers",
+			MaxWidth: 7,
+		},
+	}
+}
+
+// Brief returns the query name, the query type, the response code and the
+// count of the answers. It implements the fdump.BriefFunc.
+func Brief(record *fdump.Record) []string {
+	dns := recordDNS(record)
+	if dns == nil {
+		return nil
+	}
+
+	results := make([]string, 4)
+	if len(dns.Questions) > 0 {
+		results[0] = string(dns.Questions[0].Name)
+		results[1] = dns.Questions[0].Type.String()
+	}
+	if dns.QR {
+		results[2] = RCode(dns.ResponseCode)
+		results[3] = strconv.Itoa(len(dns.Answers))
+	}
+	return results
+}
+
+// Detail returns the message like the output of the dig. It implements the
+// fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	dns := recordDNS(record)
+	if dns == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n",
+		opcode(dns.OpCode), RCode(dns.ResponseCode), dns.ID)
+	flags := make([]string, 0, 5)
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{dns.QR, "qr"},
+		{dns.AA, "aa"},
+		{dns.TC, "tc"},
+		{dns.RD, "rd"},
+		{dns.RA, "ra"},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	fmt.Fprintf(&b, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(dns.Questions), len(dns.Answers), len(dns.Authorities), len(dns.Additionals))
+
+	if len(dns.Questions) > 0 {
+		b.WriteString("\n;; QUESTION SECTION:\n")
+		for _, q := range dns.Questions {
+			fmt.Fprintf(&b, ";%s.\t\t%s\t%s\n", q.Name, q.Class, q.Type)
+		}
+	}
+	section(&b, "ANSWER", dns.Answers)
+	section(&b, "AUTHORITY", dns.Authorities)
+	section(&b, "ADDITIONAL", dns.Additionals)
+	return b.String()
+}
+
+func section(b *strings.Builder, name string, records []layers.DNSResourceRecord) {
+	if len(records) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n;; %s SECTION:\n", name)
+	for _, rr := range records {
+		if rr.Type == layers.DNSTypeOPT {
+			fmt.Fprintf(b, ";; OPT PSEUDOSECTION: udp: %d\n", rr.Class)
+			continue
+		}
+		fmt.Fprintf(b, "%s.\t%d\t%s\t%s\t%s\n", rr.Name, rr.TTL, rr.Class, rr.Type, rdata(&rr))
+	}
+}
+
+// rdata returns the data of the resource record as the dig
+func rdata(rr *layers.DNSResourceRecord) string {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return net.IP(rr.IP).String()
+	case layers.DNSTypeNS:
+		return string(rr.NS) + "."
+	case layers.DNSTypeCNAME:
+		return string(rr.CNAME) + "."
+	case layers.DNSTypePTR:
+		return string(rr.PTR) + "."
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s.", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s.", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s. %s. %d %d %d %d %d", rr.SOA.MName, rr.SOA.RName,
+			rr.SOA.Serial, rr.SOA.Refresh, rr.SOA.Retry, rr.SOA.Expire, rr.SOA.Minimum)
+	case layers.DNSTypeTXT:
+		txts := make([]string, 0, len(rr.TXTs))
+		for _, txt := range rr.TXTs {
+			txts = append(txts, strconv.Quote(string(txt)))
+		}
+		return strings.Join(txts, " ")
+	}
+	return fmt.Sprintf("\\# %d %x", len(rr.Data), rr.Data)
+}
+
+// Pair pair the response with the query by the id and the question, it
+// implements the fdump.PairFunc.
+func Pair(record *fdump.Record) (key string, isRequest bool) {
+	dns := recordDNS(record)
+	if dns == nil {
+		return
+	}
+	key = fmt.Sprintf("dns:%d", dns.ID)
+	if len(dns.Questions) > 0 {
+		q := dns.Questions[0]
+		key += fmt.Sprintf(":%s:%s", q.Name, q.Type)
+	}
+	return key, !dns.QR
+}
+
+// Protocol returns the protocol to register to a fdump.Registry. It matches
+// the port 53.
+func Protocol() *fdump.Protocol {
+	return &fdump.Protocol{
+		Name:            "dns",
+		NewDecoder:      fdump.DecodeFuncFactory(Decode),
+		Brief:           Brief,
+		Detail:          Detail,
+		BriefAttributes: BriefAttributes(),
+		Pair:            Pair,
+		Ports: []fdump.PortRange{
+			fdump.PortRange{Min: 53, Max: 53},
+		},
+	}
+}
+
+func recordDNS(record *fdump.Record) *layers.DNS {
+	if record == nil || len(record.Bodies) == 0 {
+		return nil
+	}
+	dns, _ := record.Bodies[0].(*layers.DNS)
+	return dns
+}
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+func testMessage(t *testing.T, dns *layers.DNS) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true})
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func testResponse() *layers.DNS {
+	return &layers.DNS{
+		ID:           1234,
+		QR:           true,
+		RD:           true,
+		RA:           true,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions: []layers.DNSQuestion{
+			layers.DNSQuestion{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+		Answers: []layers.DNSResourceRecord{
+			layers.DNSResourceRecord{
+				Name:  []byte("example.com"),
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   300,
+				IP:    net.ParseIP("93.184.216.34").To4(),
+			},
+			layers.DNSResourceRecord{
+				Name:  []byte("example.com"),
+				Type:  layers.DNSTypeMX,
+				Class: layers.DNSClassIN,
+				TTL:   300,
+				MX:    layers.DNSMX{Preference: 10, Name: []byte("mail.example.com")},
+			},
+		},
+	}
+}
+
+func testTransport(t *testing.T, tcp bool) gopacket.Flow {
+	var transport gopacket.Flow
+	var err error
+	if tcp {
+		transport, err = gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(50123), layers.NewTCPPortEndpoint(53))
+	} else {
+		transport, err = gopacket.FlowFromEndpoints(layers.NewUDPPortEndpoint(50123), layers.NewUDPPortEndpoint(53))
+	}
+	assert.NoError(t, err)
+	return transport
+}
+
+func TestDecodeUDP(t *testing.T) {
+	data := testMessage(t, testResponse())
+	bodies, n, err := Decode(gopacket.Flow{}, testTransport(t, false), data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), n)
+
+	record := &fdump.Record{Bodies: bodies}
+	assert.Equal(t, []string{"example.com", "A", "NOERROR", "2"}, Brief(record))
+	detail := Detail(record)
+	assert.Contains(t, detail, ";; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1234\n")
+	assert.Contains(t, detail, ";; flags: qr rd ra; QUERY: 1, ANSWER: 2, AUTHORITY: 0, ADDITIONAL: 0\n")
+	assert.Contains(t, detail, ";example.com.\t\tIN\tA\n")
+	assert.Contains(t, detail, "example.com.\t300\tIN\tA\t93.184.216.34\n")
+	assert.Contains(t, detail, "example.com.\t300\tIN\tMX\t10 mail.example.com.\n")
+
+	key, isRequest := Pair(record)
+	assert.Equal(t, "dns:1234:example.com:A", key)
+	assert.False(t, isRequest)
+
+	_, _, err = Decode(gopacket.Flow{}, testTransport(t, false), data[:5])
+	assert.Error(t, err)
+}
+
+func TestDecodeTCP(t *testing.T) {
+	query := testResponse()
+	query.QR = false
+	query.Answers = nil
+	data := testMessage(t, query)
+	buf := make([]byte, 2, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	buf = append(buf, data...)
+
+	transport := testTransport(t, true)
+	_, _, err := Decode(gopacket.Flow{}, transport, buf[:len(buf)-1])
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+
+	bodies, n, err := Decode(gopacket.Flow{}, transport, append(buf, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	record := &fdump.Record{Bodies: bodies}
+	assert.Equal(t, []string{"example.com", "A", "", ""}, Brief(record))
+	_, isRequest := Pair(record)
+	assert.True(t, isRequest)
+}
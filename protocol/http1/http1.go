@@ -0,0 +1,492 @@
+// Package http1 decode the HTTP/1.0 and HTTP/1.1 messages for fdump. The
+// messages are framed by the Content-Length or the chunked transfer encoding,
+// several messages pipelined in a keep-alive connection are decoded one by
+// one.
+//
+// Use the Decode with fdump.NewApp:
+//
+//	a := fdump.NewApp(http1.Decode, http1.Brief, http1.Detail, nil, http1.BriefAttributes())
+//
+// or the decoders created by NewDecoderFactory with fdump.NewStreamApp, which
+// remember the request methods to frame the responses of the HEAD requests,
+// and wait for the end of the stream to frame the responses without length.
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+)
+
+// maxHeaderSize the header larger than this is treated as an error
+const maxHeaderSize = 1 << 20
+
+// maxBodySize the body larger than this is treated as an error, the body of
+// a response ended by the close of the connection is cut.
+const maxBodySize = 16 << 20
+
+var (
+	// ErrHeaderTooLarge the header isn't finished in maxHeaderSize bytes
+	ErrHeaderTooLarge = errors.New("http header too large")
+	// ErrBodyTooLarge the body is larger than maxBodySize
+	ErrBodyTooLarge = errors.New("http body too large")
+
+	headerEnd     = []byte("\r\n\r\n")
+	responseBegin = []byte("HTTP/")
+	methods       = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+)
+
+// Message a decoded HTTP request or response
+type Message struct {
+	IsRequest     bool
+	Method        string `json:",omitempty"`
+	URL           string `json:",omitempty"`
+	StatusCode    int    `json:",omitempty"`
+	Status        string `json:",omitempty"`
+	Proto         string
+	Header        http.Header
+	ContentLength int64
+	Chunked       bool
+	Close         bool
+	Body          []byte // the body with the chunked encoding removed
+	// Truncated the body ended by the close of the connection is cut at the
+	// max body size.
+	Truncated bool `json:",omitempty"`
+
+	// Seq is the order of the request in the connection, a response has the
+	// Seq of its request. It's zero if decoded by the Decode.
+	Seq int `json:",omitempty"`
+}
+
+// Decode decode a request or a response, it implements the fdump.DecodeFunc.
+// The response of a HEAD request has no body, but Decode doesn't know the
+// request, and the body of a response without the length is all the bytes
+// received. Use the decoders created by NewDecoderFactory for them.
+func Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	bodies, n, _, err = decode(buf, "", true)
+	return
+}
+
+// decode decode a message, the method is the method of the request if the
+// message is a response. The body of a response without the length ends at
+// the end of the stream, it's all the bytes if final is true. The need is the
+// length of the message if it's known but not all received.
+func decode(buf []byte, method string, final bool) (bodies []interface{}, n int, need int, err error) {
+	if bytes.Index(buf, headerEnd) < 0 {
+		if len(buf) > maxHeaderSize {
+			err = ErrHeaderTooLarge
+			return
+		}
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+
+	reader := bytes.NewReader(buf)
+	br := bufio.NewReaderSize(reader, len(buf))
+	var message *Message
+	var body io.Reader
+	untilClose := false
+	if bytes.HasPrefix(buf, responseBegin) {
+		var rsp *http.Response
+		rsp, err = readResponse(br, method)
+		if err == nil {
+			message, body = responseMessage(rsp), rsp.Body
+			untilClose = rsp.ContentLength < 0 && !message.Chunked && rsp.Body != http.NoBody
+		}
+	} else {
+		var req *http.Request
+		req, err = http.ReadRequest(br)
+		if err == nil {
+			message, body = requestMessage(req), req.Body
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	// all the buffer is read by the bufio.Reader
+	header := len(buf) - br.Buffered()
+	switch {
+	case message.ContentLength > maxBodySize:
+		err = ErrBodyTooLarge
+		return
+	case body != http.NoBody && message.ContentLength > 0 && header+int(message.ContentLength) > len(buf):
+		need = header + int(message.ContentLength)
+		err = fdump.ErrPkgNoEnough
+		return
+	case untilClose && !final:
+		if len(buf)-header <= maxBodySize {
+			need = header + maxBodySize + 1
+			err = fdump.ErrPkgNoEnough
+			return
+		}
+		message.Body = append([]byte{}, buf[header:header+maxBodySize]...)
+		message.Truncated = true
+		return []interface{}{message}, len(buf), 0, nil
+	}
+
+	message.Body, err = ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+	if err != nil {
+		return
+	}
+	if len(message.Body) > maxBodySize {
+		if !untilClose {
+			err = ErrBodyTooLarge
+			return
+		}
+		message.Body = message.Body[:maxBodySize]
+		message.Truncated = true
+		n = len(buf)
+	} else {
+		n = len(buf) - br.Buffered() - reader.Len()
+	}
+	bodies = append(bodies, message)
+	return
+}
+
+func requestMessage(req *http.Request) *Message {
+	return &Message{
+		IsRequest:     true,
+		Method:        req.Method,
+		URL:           req.RequestURI,
+		Proto:         req.Proto,
+		Header:        req.Header,
+		ContentLength: req.ContentLength,
+		Chunked:       isChunked(req.TransferEncoding),
+		Close:         req.Close,
+	}
+}
+
+// readResponse read the header of a response, the method frames the
+// response of a HEAD request.
+func readResponse(br *bufio.Reader, method string) (*http.Response, error) {
+	var req *http.Request
+	if method != "" {
+		req = &http.Request{Method: method}
+	}
+	return http.ReadResponse(br, req)
+}
+
+func responseMessage(rsp *http.Response) *Message {
+	return &Message{
+		StatusCode:    rsp.StatusCode,
+		Status:        rsp.Status,
+		Proto:         rsp.Proto,
+		Header:        rsp.Header,
+		ContentLength: rsp.ContentLength,
+		Chunked:       isChunked(rsp.TransferEncoding),
+		Close:         rsp.Close,
+	}
+}
+
+func isChunked(encodings []string) bool {
+	return len(encodings) > 0 && encodings[0] == "chunked"
+}
+
+// NewDecoderFactory returns a fdump.StreamDecoderFactory. The decoders of the
+// both directions of a connection share the methods of the pipelined
+// requests, so the responses of the HEAD requests are framed right.
+func NewDecoderFactory() fdump.StreamDecoderFactory {
+	methods := &pendingMethods{
+		methods: make(map[[2]gopacket.Flow]*pipeline),
+	}
+	return func(net, transport gopacket.Flow) fdump.StreamDecoder {
+		d := &decoder{
+			methods:    methods,
+			key:        [2]gopacket.Flow{net, transport},
+			reverseKey: [2]gopacket.Flow{net.Reverse(), transport.Reverse()},
+		}
+		// the requests of the stream are in the key, its responses are in
+		// the reverseKey, the pipeline is removed when both decoders close
+		methods.attach(d.key)
+		methods.attach(d.reverseKey)
+		return d
+	}
+}
+
+// pendingMethods the methods of the requests without response, keyed by the
+// flows of the requests.
+type pendingMethods struct {
+	mutex   sync.Mutex
+	methods map[[2]gopacket.Flow]*pipeline
+}
+
+// pipeline the requests of a connection
+type pipeline struct {
+	methods   []string // the methods without response
+	requests  int      // the count of the requests
+	responses int      // the count of the responses
+	refs      int      // the count of the decoders attached
+}
+
+func (p *pendingMethods) attach(key [2]gopacket.Flow) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	l, ok := p.methods[key]
+	if !ok {
+		l = &pipeline{}
+		p.methods[key] = l
+	}
+	l.refs++
+}
+
+func (p *pendingMethods) detach(key [2]gopacket.Flow) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	l, ok := p.methods[key]
+	if !ok {
+		return
+	}
+	l.refs--
+	if l.refs <= 0 {
+		delete(p.methods, key)
+	}
+}
+
+// push add a request and returns its seq
+func (p *pendingMethods) push(key [2]gopacket.Flow, method string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	l, ok := p.methods[key]
+	if !ok {
+		return 0
+	}
+	l.methods = append(l.methods, method)
+	l.requests++
+	return l.requests
+}
+
+// peek returns the method of the first request without response
+func (p *pendingMethods) peek(key [2]gopacket.Flow) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	l, ok := p.methods[key]
+	if !ok || len(l.methods) == 0 {
+		return ""
+	}
+	return l.methods[0]
+}
+
+// pop remove the first request and returns the seq of the response
+func (p *pendingMethods) pop(key [2]gopacket.Flow) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	l, ok := p.methods[key]
+	if !ok {
+		return 0
+	}
+	if len(l.methods) > 0 {
+		l.methods = l.methods[1:]
+	}
+	l.responses++
+	return l.responses
+}
+
+type decoder struct {
+	methods    *pendingMethods
+	key        [2]gopacket.Flow
+	reverseKey [2]gopacket.Flow
+	// need the length of the message being received, it isn't parsed again
+	// until the buffer is so long
+	need int
+	// discard the rest of the stream after the cut body ended by the close
+	discard bool
+}
+
+func (d *decoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	return d.decode(buf, false)
+}
+
+// Finish implements the fdump.Finisher, the response without the length
+// ends.
+func (d *decoder) Finish(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	return d.decode(buf, true)
+}
+
+// Gap implements the fdump.GapHandler, the buffer is dropped.
+func (d *decoder) Gap(net, transport gopacket.Flow, skip int) {
+	d.need = 0
+	d.discard = false
+}
+
+func (d *decoder) decode(buf []byte, final bool) (bodies []interface{}, n int, err error) {
+	if d.discard {
+		return nil, len(buf), nil
+	}
+	if len(buf) < d.need && !final {
+		return nil, 0, fdump.ErrPkgNoEnough
+	}
+	bodies, n, d.need, err = decode(buf, d.methods.peek(d.reverseKey), final)
+	if err != nil || len(bodies) == 0 {
+		return
+	}
+
+	message := bodies[0].(*Message)
+	d.discard = message.Truncated
+	if message.IsRequest {
+		message.Seq = d.methods.push(d.key, message.Method)
+	} else if message.StatusCode >= 200 || message.StatusCode == http.StatusSwitchingProtocols {
+		// the 1xx responses are followed by the final response
+		message.Seq = d.methods.pop(d.reverseKey)
+	}
+	return
+}
+
+func (d *decoder) Close() {
+	d.methods.detach(d.key)
+	d.methods.detach(d.reverseKey)
+}
+
+// Sniff returns true if the payload begins with a request line or a status
+// line, it implements the fdump.SniffFunc.
+func Sniff(net, transport gopacket.Flow, payload []byte) bool {
+	if bytes.HasPrefix(payload, responseBegin) {
+		return true
+	}
+	for _, method := range methods {
+		if bytes.HasPrefix(payload, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// BriefAttributes returns the brief columns of the Brief
+func BriefAttributes() []*fdump.BriefColumnAttribute {
+	return []*fdump.BriefColumnAttribute{
+		&fdump.BriefColumnAttribute{
+			Title:    "Method",
+			MaxWidth: 7,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Status",
+			MaxWidth: 6,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "URL",
+			MaxWidth: 40,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Content-Type",
+			MaxWidth: 24,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Length",
+			MaxWidth: 8,
+		},
+	}
+}
+
+// Brief returns the method, status, url, content type and body length of the
+// message, it implements the fdump.BriefFunc.
+func Brief(record *fdump.Record) []string {
+	message := recordMessage(record)
+	if message == nil {
+		return nil
+	}
+
+	results := make([]string, 5)
+	if message.IsRequest {
+		results[0] = message.Method
+		results[2] = message.URL
+	} else {
+		results[1] = fmt.Sprintf("%d", message.StatusCode)
+	}
+	results[3] = message.Header.Get("Content-Type")
+	results[4] = fmt.Sprintf("%d", len(message.Body))
+	return results
+}
+
+// Detail returns the message in the wire format, the body is shown as a hex
+// dump if it's not text. It implements the fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	message := recordMessage(record)
+	if message == nil {
+		return ""
+	}
+
+	result := ""
+	if message.IsRequest {
+		result += fmt.Sprintf("%s %s %s\n", message.Method, message.URL, message.Proto)
+	} else {
+		result += fmt.Sprintf("%s %s\n", message.Proto, message.Status)
+	}
+
+	keys := make([]string, 0, len(message.Header))
+	for key := range message.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range message.Header[key] {
+			result += fmt.Sprintf("%s: %s\n", key, value)
+		}
+	}
+	result += "\n"
+
+	if utf8.Valid(message.Body) {
+		result += string(message.Body)
+	} else {
+		result += hex.Dump(message.Body)
+	}
+	if message.Truncated {
+		result += fmt.Sprintf("\n(truncated at %d bytes)\n", maxBodySize)
+	}
+	return result
+}
+
+// Pair pair the requests and responses by the Seq, it implements the
+// fdump.PairFunc. The pipelined requests are paired if they are decoded by
+// the decoders created by NewDecoderFactory.
+func Pair(record *fdump.Record) (key string, isRequest bool) {
+	message := recordMessage(record)
+	if message == nil || (!message.IsRequest && message.StatusCode < 200) {
+		return
+	}
+	return fmt.Sprintf("http:%d", message.Seq), message.IsRequest
+}
+
+// Protocol returns the protocol to register to a fdump.Registry. It matches
+// the ports 80 and 8080, or the payload begins with an HTTP/1.x message.
+func Protocol() *fdump.Protocol {
+	return &fdump.Protocol{
+		Name:            "http",
+		NewDecoder:      NewDecoderFactory(),
+		Brief:           Brief,
+		Detail:          Detail,
+		BriefAttributes: BriefAttributes(),
+		Pair:            Pair,
+		Ports: []fdump.PortRange{
+			fdump.PortRange{Min: 80, Max: 80},
+			fdump.PortRange{Min: 8080, Max: 8080},
+		},
+		Sniff: Sniff,
+	}
+}
+
+func recordMessage(record *fdump.Record) *Message {
+	if record == nil || len(record.Bodies) == 0 {
+		return nil
+	}
+	message, _ := record.Bodies[0].(*Message)
+	return message
+}
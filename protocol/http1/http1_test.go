@@ -0,0 +1,198 @@
+package http1
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+func testFlows(t *testing.T) (gopacket.Flow, gopacket.Flow) {
+	netFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(net.ParseIP("127.0.0.1")),
+		layers.NewIPEndpoint(net.ParseIP("10.2.2.2")))
+	assert.NoError(t, err)
+	transportFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewTCPPortEndpoint(50123),
+		layers.NewTCPPortEndpoint(80))
+	assert.NoError(t, err)
+	return netFlow, transportFlow
+}
+
+func TestDecodePipelined(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	first := "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"
+	second := "GET /b HTTP/1.1\r\nHost: x\r\n\r\n"
+	buf := []byte(first + second + "GET /c HT")
+
+	bodies, n, err := Decode(netFlow, transportFlow, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(first), n)
+	message := bodies[0].(*Message)
+	assert.True(t, message.IsRequest)
+	assert.Equal(t, "POST", message.Method)
+	assert.Equal(t, "/a", message.URL)
+	assert.Equal(t, []byte("hello"), message.Body)
+
+	buf = buf[n:]
+	bodies, n, err = Decode(netFlow, transportFlow, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(second), n)
+	assert.Equal(t, "/b", bodies[0].(*Message).URL)
+
+	_, _, err = Decode(netFlow, transportFlow, buf[n:])
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+}
+
+func TestDecodeChunked(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	rsp := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+
+	_, _, err := Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte(rsp[:len(rsp)-10]))
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+
+	bodies, n, err := Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte(rsp))
+	assert.NoError(t, err)
+	assert.Equal(t, len(rsp), n)
+	message := bodies[0].(*Message)
+	assert.False(t, message.IsRequest)
+	assert.Equal(t, 200, message.StatusCode)
+	assert.True(t, message.Chunked)
+	assert.Equal(t, []byte("hello world"), message.Body)
+
+	record := &fdump.Record{Bodies: bodies}
+	assert.Equal(t, []string{"", "200", "", "text/plain", "11"}, Brief(record))
+	assert.Contains(t, Detail(record), "HTTP/1.1 200 OK\nContent-Type: text/plain\n")
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	_, _, err := Decode(netFlow, transportFlow, []byte("HTTP/1.1 abc\r\n\r\n"))
+	assert.Error(t, err)
+	assert.NotEqual(t, fdump.ErrPkgNoEnough, err)
+}
+
+func TestDecoderUntilClose(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	server := NewDecoderFactory()(netFlow.Reverse(), transportFlow.Reverse())
+	rsp := []byte("HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nhello")
+
+	// the body ends at the end of the stream
+	_, _, err := server.Decode(netFlow.Reverse(), transportFlow.Reverse(), rsp)
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	rsp = append(rsp, "\r\n\r\nGET / HTTP/1.1\r\n\r\n"...)
+	_, _, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), rsp)
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+
+	bodies, n, err := server.(fdump.Finisher).Finish(netFlow.Reverse(), transportFlow.Reverse(), rsp)
+	assert.NoError(t, err)
+	assert.Equal(t, len(rsp), n)
+	message := bodies[0].(*Message)
+	assert.Equal(t, []byte("hello\r\n\r\nGET / HTTP/1.1\r\n\r\n"), message.Body)
+	assert.False(t, message.Truncated)
+
+	// the body longer than the max size is cut, the rest is discarded
+	rsp = append([]byte("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n"), make([]byte, maxBodySize+10)...)
+	bodies, n, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), rsp)
+	assert.NoError(t, err)
+	assert.Equal(t, len(rsp), n)
+	assert.True(t, bodies[0].(*Message).Truncated)
+	assert.Len(t, bodies[0].(*Message).Body, maxBodySize)
+	rest := []byte("HTTP/1.1 200 OK\r\n")
+	bodies, n, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), rest)
+	assert.NoError(t, err)
+	assert.Equal(t, len(rest), n)
+	assert.Empty(t, bodies)
+	server.Close()
+}
+
+func TestDecoderContentLength(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	client := NewDecoderFactory()(netFlow, transportFlow)
+	req := []byte("POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\nhello")
+
+	// the header is parsed once, the length is waited for
+	_, _, err := client.Decode(netFlow, transportFlow, req)
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	assert.Equal(t, len(req)-5+100, client.(*decoder).need)
+	req = append(req, make([]byte, 95)...)
+	bodies, n, err := client.Decode(netFlow, transportFlow, req)
+	assert.NoError(t, err)
+	assert.Equal(t, len(req), n)
+	assert.Len(t, bodies[0].(*Message).Body, 100)
+	assert.Equal(t, 0, client.(*decoder).need)
+
+	_, _, err = client.Decode(netFlow, transportFlow, []byte("POST / HTTP/1.1\r\nContent-Length: 1000000000\r\n\r\n"))
+	assert.Equal(t, ErrBodyTooLarge, err)
+	client.Close()
+}
+
+func TestDecoderHead(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+
+	req := "HEAD /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n"
+	bodies, n, err := client.Decode(netFlow, transportFlow, []byte(req))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bodies[0].(*Message).Seq)
+	bodies, _, err = client.Decode(netFlow, transportFlow, []byte(req[n:]))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, bodies[0].(*Message).Seq)
+
+	// the response of HEAD has the Content-Length but no body
+	head := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"
+	get := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	bodies, n, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte(head+get))
+	assert.NoError(t, err)
+	assert.Equal(t, len(head), n)
+	assert.Equal(t, 1, bodies[0].(*Message).Seq)
+	key, isRequest := Pair(&fdump.Record{Bodies: bodies})
+	assert.Equal(t, "http:1", key)
+	assert.False(t, isRequest)
+
+	bodies, n, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte(get))
+	assert.NoError(t, err)
+	assert.Equal(t, len(get), n)
+	assert.Equal(t, 2, bodies[0].(*Message).Seq)
+	assert.Equal(t, []byte("hello"), bodies[0].(*Message).Body)
+
+	client.Close()
+	server.Close()
+	assert.Empty(t, client.(*decoder).methods.methods)
+}
+
+func TestDecoderClose(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+
+	// the server closes first, the client decodes after that
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	methods := client.(*decoder).methods
+	_, _, err := server.Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	assert.NoError(t, err)
+	server.Close()
+	_, _, err = client.Decode(netFlow, transportFlow, []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	assert.NoError(t, err)
+	client.Close()
+	assert.Empty(t, methods.methods)
+
+	// the stream of a direction only
+	server = newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	_, _, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), []byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	assert.NoError(t, err)
+	server.Close()
+	assert.Empty(t, methods.methods)
+}
+
+func TestSniff(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	assert.True(t, Sniff(netFlow, transportFlow, []byte("GET / HTTP/1.1\r\n")))
+	assert.True(t, Sniff(netFlow, transportFlow, []byte("HTTP/1.0 200 OK\r\n")))
+	assert.False(t, Sniff(netFlow, transportFlow, []byte("*3\r\n$3\r\nSET\r\n")))
+}
@@ -0,0 +1,834 @@
+// Package http2 decode the HTTP/2 frames and the gRPC messages for fdump. The
+// frames of a tcp stream are reassembled and the header blocks are decoded by
+// the HPACK dynamic table of the stream, so every direction of every
+// connection has its own table. The multiplexed streams are split into
+// separate records: a record for every header block, and for every gRPC
+// message or every body of the other requests and responses. The responses
+// have the path of their requests.
+//
+// Use the NewDecoderFactory with fdump.NewStreamApp:
+//
+//	a := fdump.NewStreamApp(http2.NewDecoderFactory(), http2.Brief, http2.Detail, nil, http2.BriefAttributes())
+//
+// The records loaded from a file are decoded again frame by frame, the frames
+// of a message split by the frames without any record are lost.
+package http2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Preface the connection preface sent by the client
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// the frame types
+const (
+	FrameData         = 0x0
+	FrameHeaders      = 0x1
+	FramePriority     = 0x2
+	FrameRSTStream    = 0x3
+	FrameSettings     = 0x4
+	FramePushPromise  = 0x5
+	FramePing         = 0x6
+	FrameGoAway       = 0x7
+	FrameWindowUpdate = 0x8
+	FrameContinuation = 0x9
+)
+
+// the frame flags
+const (
+	flagEndStream  = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+const (
+	frameHeaderLength = 9
+	// maxFrameSize the max frame size can be negotiated by the SETTINGS
+	maxFrameSize = 1<<24 - 1
+	// maxBodySize the body larger than this is truncated
+	maxBodySize = 16 << 20
+	// maxTableSize the decoder accept any table size the peer allowed, the
+	// SETTINGS aren't tracked
+	maxTableSize = 1 << 24
+	// grpcPrefixLength the compressed flag and the length of a gRPC message
+	grpcPrefixLength = 5
+)
+
+var (
+	// ErrFrameTooLarge the length of the frame is larger than the max frame
+	// size
+	ErrFrameTooLarge = errors.New("http2 frame too large")
+	// ErrMalformed the padding or the fields are longer than the frame
+	ErrMalformed = errors.New("http2 malformed frame")
+	// ErrBodyTooLarge the body or the gRPC message is larger than the max
+	// body size, the Message has the bytes before the max size
+	ErrBodyTooLarge = errors.New("http2 body too large")
+)
+
+// the types of the messages
+const (
+	TypeHeaders  = "headers"
+	TypeTrailers = "trailers"
+	TypeMessage  = "message" // a gRPC message
+	TypeBody     = "body"    // the body of a request or response which isn't gRPC
+	TypeRST      = "rst_stream"
+	TypeGoAway   = "goaway"
+)
+
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// GRPCCode returns the name of the grpc-status, such as NOT_FOUND
+func GRPCCode(status string) string {
+	for i, name := range grpcCodes {
+		if status == fmt.Sprintf("%d", i) {
+			return name
+		}
+	}
+	return status
+}
+
+var errCodes = []string{
+	"NO_ERROR", "PROTOCOL_ERROR", "INTERNAL_ERROR", "FLOW_CONTROL_ERROR",
+	"SETTINGS_TIMEOUT", "STREAM_CLOSED", "FRAME_SIZE_ERROR", "REFUSED_STREAM",
+	"CANCEL", "COMPRESSION_ERROR", "CONNECT_ERROR", "ENHANCE_YOUR_CALM",
+	"INADEQUATE_SECURITY", "HTTP_1_1_REQUIRED",
+}
+
+// ErrCodeName returns the name of the error code of a RST_STREAM or GOAWAY
+func ErrCodeName(code uint32) string {
+	if int(code) < len(errCodes) {
+		return errCodes[code]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// HeaderField a decoded header field
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Message a header block, a gRPC message, a body or an error of a stream
+type Message struct {
+	Type      string
+	StreamID  uint32
+	IsRequest bool
+	EndStream bool
+
+	// Path is the :path of the request of the stream, the responses have it
+	// if the request is decoded.
+	Path   string        `json:",omitempty"`
+	Method string        `json:",omitempty"`
+	Status string        `json:",omitempty"` // the :status of the response
+	Header []HeaderField `json:",omitempty"` // the fields of a header block
+
+	// GRPCStatus and GRPCMessage are the grpc-status and grpc-message of the
+	// trailers.
+	GRPCStatus  string `json:",omitempty"`
+	GRPCMessage string `json:",omitempty"`
+
+	// Data is the gRPC message without the length prefix, or the body
+	Data       []byte `json:",omitempty"`
+	Compressed bool   `json:",omitempty"` // the gRPC message is compressed and can't be decompressed
+	Truncated  bool   `json:",omitempty"` // the data is cut at the max body size
+	// Index is the order of the gRPC message in its stream and direction,
+	// from 1.
+	Index int `json:",omitempty"`
+
+	ErrCode uint32 `json:",omitempty"` // the error code of a RST_STREAM or GOAWAY
+	Err     string `json:",omitempty"` // the header block can't be decoded or the data is too large
+}
+
+// NewDecoderFactory returns a fdump.StreamDecoderFactory. The decoders of the
+// both directions of a connection share the requests of the streams, so the
+// responses have the paths of their requests.
+func NewDecoderFactory() fdump.StreamDecoderFactory {
+	conns := &conns{
+		states: make(map[[2]gopacket.Flow]*connState),
+	}
+	return func(net, transport gopacket.Flow) fdump.StreamDecoder {
+		d := &decoder{
+			conns:   conns,
+			key:     connKey(net, transport),
+			streams: make(map[uint32]*streamData),
+			hpack:   newHPACK(),
+		}
+		d.state = conns.attach(d.key)
+		return d
+	}
+}
+
+func newHPACK() *hpack.Decoder {
+	decoder := hpack.NewDecoder(4096, nil)
+	decoder.SetAllowedMaxDynamicTableSize(maxTableSize)
+	return decoder
+}
+
+// connKey returns the same key for the both directions
+func connKey(net, transport gopacket.Flow) [2]gopacket.Flow {
+	src, dst := net.Endpoints()
+	if dst.LessThan(src) || (src == dst && transport.Dst().LessThan(transport.Src())) {
+		return [2]gopacket.Flow{net.Reverse(), transport.Reverse()}
+	}
+	return [2]gopacket.Flow{net, transport}
+}
+
+// request the request of a stream
+type request struct {
+	method   string
+	path     string
+	grpc     bool
+	ended    int // the count of the directions ended
+	encoding [2]string
+}
+
+// connState the requests of the streams of a connection
+type connState struct {
+	requests map[uint32]*request
+	refs     int
+}
+
+// conns the states of the connections keyed by the connKey
+type conns struct {
+	mutex  sync.Mutex
+	states map[[2]gopacket.Flow]*connState
+}
+
+func (c *conns) attach(key [2]gopacket.Flow) *connState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.states[key]
+	if !ok {
+		state = &connState{
+			requests: make(map[uint32]*request),
+		}
+		c.states[key] = state
+	}
+	state.refs++
+	return state
+}
+
+func (c *conns) detach(key [2]gopacket.Flow) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.states[key]
+	if !ok {
+		return
+	}
+	state.refs--
+	if state.refs <= 0 {
+		delete(c.states, key)
+	}
+}
+
+// streamData the data of a stream in the direction of the decoder
+type streamData struct {
+	buf      []byte
+	messages int
+	// skip the rest bytes of the gRPC message cut at the max body size
+	skip int
+	// truncated the body is cut at the max body size, the rest is dropped
+	truncated bool
+}
+
+type decoder struct {
+	conns     *conns
+	key       [2]gopacket.Flow
+	state     *connState
+	hpack     *hpack.Decoder
+	streams   map[uint32]*streamData
+	queue     []*Message // the gRPC messages of a frame not returned
+	started   bool       // the preface is checked
+	direction fdump.Direction
+}
+
+// SetDirection implements the fdump.DirectionSetter
+func (d *decoder) SetDirection(connID uint64, direction fdump.Direction) {
+	d.direction = direction
+}
+
+// Gap implements the fdump.GapHandler. The HPACK table is lost with the
+// bytes, the header blocks are decoded by a new table, the fields indexed to
+// the lost table can't be decoded.
+func (d *decoder) Gap(net, transport gopacket.Flow, skip int) {
+	d.hpack = newHPACK()
+	d.streams = make(map[uint32]*streamData)
+	d.queue = nil
+	d.started = true
+}
+
+func (d *decoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	if len(d.queue) > 0 {
+		bodies = append(bodies, d.queue[0])
+		d.queue = d.queue[1:]
+		return
+	}
+
+	if !d.started {
+		if len(buf) < len(Preface) && strings.HasPrefix(Preface, string(buf)) {
+			err = fdump.ErrPkgNoEnough
+			return
+		}
+		d.started = true
+		if bytes.HasPrefix(buf, []byte(Preface)) {
+			d.direction = fdump.DirectionClientToServer
+			n = len(Preface)
+			return
+		}
+	}
+
+	length, typ, flags, streamID, err := readFrameHeader(buf)
+	if err != nil {
+		return
+	}
+	n = frameHeaderLength + length
+	payload := buf[frameHeaderLength:n]
+
+	d.conns.mutex.Lock()
+	defer d.conns.mutex.Unlock()
+
+	var message *Message
+	switch typ {
+	case FrameHeaders, FramePushPromise:
+		message, n, err = d.decodeHeaders(buf, typ, flags, streamID, payload)
+	case FrameData:
+		message, err = d.decodeData(flags, streamID, payload)
+	case FrameRSTStream:
+		if len(payload) < 4 {
+			err = ErrMalformed
+			break
+		}
+		message = &Message{
+			Type:      TypeRST,
+			StreamID:  streamID,
+			IsRequest: d.isRequest(),
+			ErrCode:   binary.BigEndian.Uint32(payload),
+		}
+		d.setPath(message)
+		d.end(streamID, true)
+	case FrameGoAway:
+		if len(payload) < 8 {
+			err = ErrMalformed
+			break
+		}
+		message = &Message{
+			Type:      TypeGoAway,
+			StreamID:  binary.BigEndian.Uint32(payload) & 0x7fffffff, // the last stream
+			IsRequest: d.isRequest(),
+			ErrCode:   binary.BigEndian.Uint32(payload[4:]),
+			Data:      payload[8:],
+		}
+	}
+	if err != nil {
+		n = 0
+		return
+	}
+	if message != nil {
+		bodies = append(bodies, message)
+	}
+	return
+}
+
+func (d *decoder) Close() {
+	d.conns.detach(d.key)
+}
+
+// readFrameHeader returns the fields of the frame header if the frame is
+// complete.
+func readFrameHeader(buf []byte) (length int, typ, flags byte, streamID uint32, err error) {
+	if len(buf) < frameHeaderLength {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+	length = int(buf[0])<<16 | int(buf[1])<<8 | int(buf[2])
+	typ = buf[3]
+	flags = buf[4]
+	streamID = binary.BigEndian.Uint32(buf[5:]) & 0x7fffffff
+	if length > maxFrameSize {
+		err = ErrFrameTooLarge
+		return
+	}
+	if len(buf) < frameHeaderLength+length {
+		err = fdump.ErrPkgNoEnough
+	}
+	return
+}
+
+// unpad remove the padding of a DATA, HEADERS or PUSH_PROMISE frame
+func unpad(flags byte, payload []byte) ([]byte, error) {
+	if flags&flagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) < 1 || int(payload[0]) > len(payload)-1 {
+		return nil, ErrMalformed
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+func (d *decoder) isRequest() bool {
+	return d.direction == fdump.DirectionClientToServer
+}
+
+// side returns the index of the direction of the decoder
+func (d *decoder) side() int {
+	if d.isRequest() {
+		return 0
+	}
+	return 1
+}
+
+// setPath set the path of the request of the stream to the message
+func (d *decoder) setPath(message *Message) {
+	if req, ok := d.state.requests[message.StreamID]; ok {
+		message.Path = req.path
+		if !message.IsRequest {
+			message.Method = req.method
+		}
+	}
+}
+
+// end the stream in the direction of the decoder, the stream is forgotten if
+// the both directions are ended, or it's reset.
+func (d *decoder) end(streamID uint32, reset bool) {
+	delete(d.streams, streamID)
+	req, ok := d.state.requests[streamID]
+	if !ok {
+		return
+	}
+	req.ended++
+	if req.ended >= 2 || reset {
+		delete(d.state.requests, streamID)
+	}
+}
+
+// decodeHeaders decode the header block of a HEADERS or PUSH_PROMISE frame
+// and its CONTINUATION frames. It returns the length of all the frames.
+func (d *decoder) decodeHeaders(buf []byte, typ, flags byte, streamID uint32, payload []byte) (*Message, int, error) {
+	n := frameHeaderLength + len(payload)
+	block, err := unpad(flags, payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == FramePushPromise {
+		if len(block) < 4 {
+			return nil, 0, ErrMalformed
+		}
+		// the headers are the request of the promised stream
+		streamID = binary.BigEndian.Uint32(block) & 0x7fffffff
+		block = block[4:]
+	} else if flags&flagPriority != 0 {
+		if len(block) < 5 {
+			return nil, 0, ErrMalformed
+		}
+		block = block[5:]
+	}
+
+	endHeaders := flags&flagEndHeaders != 0
+	if !endHeaders {
+		block = append([]byte{}, block...)
+	}
+	for !endHeaders {
+		length, ctyp, cflags, _, err := readFrameHeader(buf[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		if ctyp != FrameContinuation {
+			return nil, 0, ErrMalformed
+		}
+		block = append(block, buf[n+frameHeaderLength:n+frameHeaderLength+length]...)
+		n += frameHeaderLength + length
+		endHeaders = cflags&flagEndHeaders != 0
+	}
+
+	message := &Message{
+		Type:      TypeHeaders,
+		StreamID:  streamID,
+		EndStream: typ == FrameHeaders && flags&flagEndStream != 0,
+	}
+	fields, err := d.hpack.DecodeFull(block)
+	if err != nil {
+		// the table is out of sync, the next blocks may be decoded if they
+		// don't refer to it
+		d.hpack = newHPACK()
+		message.Err = err.Error()
+	}
+	pseudo := false
+	for _, field := range fields {
+		switch field.Name {
+		case ":method":
+			message.IsRequest = true
+			message.Method = field.Value
+		case ":path":
+			message.Path = field.Value
+		case ":status":
+			message.Status = field.Value
+		case "grpc-status":
+			message.GRPCStatus = field.Value
+		case "grpc-message":
+			message.GRPCMessage = field.Value
+		}
+		pseudo = pseudo || field.IsPseudo()
+		message.Header = append(message.Header, HeaderField{Name: field.Name, Value: field.Value})
+	}
+
+	switch {
+	case message.IsRequest && typ == FrameHeaders:
+		d.direction = fdump.DirectionClientToServer
+	case message.Status != "":
+		d.direction = fdump.DirectionServerToClient
+	case !pseudo && message.Err == "":
+		message.Type = TypeTrailers
+		message.IsRequest = d.isRequest()
+	}
+
+	if message.IsRequest {
+		req := &request{
+			method: message.Method,
+			path:   message.Path,
+		}
+		d.state.requests[streamID] = req
+	}
+	req, ok := d.state.requests[streamID]
+	if ok {
+		for _, field := range message.Header {
+			switch field.Name {
+			case "content-type":
+				req.grpc = req.grpc || strings.HasPrefix(field.Value, "application/grpc")
+			case "grpc-encoding":
+				req.encoding[d.side()] = field.Value
+			}
+		}
+	}
+	d.setPath(message)
+
+	if message.EndStream {
+		d.end(streamID, false)
+	}
+	return message, n, nil
+}
+
+// decodeData buffer the data of the stream. It returns the first gRPC message
+// completed and queue the others, or the body if the stream is ended.
+func (d *decoder) decodeData(flags byte, streamID uint32, payload []byte) (*Message, error) {
+	data, err := unpad(flags, payload)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := d.streams[streamID]
+	if !ok {
+		s = &streamData{}
+		d.streams[streamID] = s
+	}
+	if s.skip > 0 {
+		skip := s.skip
+		if skip > len(data) {
+			skip = len(data)
+		}
+		data = data[skip:]
+		s.skip -= skip
+	}
+
+	endStream := flags&flagEndStream != 0
+	req, ok := d.state.requests[streamID]
+	if ok && req.grpc {
+		s.buf = append(s.buf, data...)
+		messages := d.grpcMessages(s, streamID, req.encoding[d.side()])
+		if endStream {
+			d.end(streamID, false)
+			if len(messages) > 0 {
+				messages[len(messages)-1].EndStream = true
+			}
+		}
+		if len(messages) == 0 {
+			return nil, nil
+		}
+		d.queue = append(d.queue, messages[1:]...)
+		return messages[0], nil
+	}
+
+	if s.truncated {
+		if endStream {
+			d.end(streamID, false)
+		}
+		return nil, nil
+	}
+	if len(s.buf)+len(data) > maxBodySize {
+		// the rest of the body is dropped
+		message := &Message{
+			Type:      TypeBody,
+			StreamID:  streamID,
+			IsRequest: d.isRequest(),
+			EndStream: endStream,
+			Data:      append(s.buf, data[:maxBodySize-len(s.buf)]...),
+			Truncated: true,
+			Err:       ErrBodyTooLarge.Error(),
+		}
+		d.setPath(message)
+		s.buf = nil
+		s.truncated = true
+		if endStream {
+			d.end(streamID, false)
+		}
+		return message, nil
+	}
+	s.buf = append(s.buf, data...)
+	if !endStream {
+		return nil, nil
+	}
+	message := &Message{
+		Type:      TypeBody,
+		StreamID:  streamID,
+		IsRequest: d.isRequest(),
+		EndStream: true,
+		Data:      s.buf,
+	}
+	d.setPath(message)
+	d.end(streamID, false)
+	return message, nil
+}
+
+// grpcMessages split the complete gRPC messages from the buffer
+func (d *decoder) grpcMessages(s *streamData, streamID uint32, encoding string) []*Message {
+	messages := make([]*Message, 0)
+	for len(s.buf) >= grpcPrefixLength {
+		length := int(binary.BigEndian.Uint32(s.buf[1:]))
+		if length > maxBodySize {
+			// the message is cut, its rest is skipped
+			s.messages++
+			message := &Message{
+				Type:       TypeMessage,
+				StreamID:   streamID,
+				IsRequest:  d.isRequest(),
+				Data:       s.buf[grpcPrefixLength:],
+				Compressed: s.buf[0] == 1,
+				Index:      s.messages,
+				Truncated:  true,
+				Err:        ErrBodyTooLarge.Error(),
+			}
+			if len(message.Data) > maxBodySize {
+				message.Data = message.Data[:maxBodySize]
+			}
+			d.setPath(message)
+			messages = append(messages, message)
+			s.skip = grpcPrefixLength + length - len(s.buf)
+			s.buf = nil
+			break
+		}
+		if len(s.buf) < grpcPrefixLength+length {
+			break
+		}
+		s.messages++
+		message := &Message{
+			Type:       TypeMessage,
+			StreamID:   streamID,
+			IsRequest:  d.isRequest(),
+			Data:       s.buf[grpcPrefixLength : grpcPrefixLength+length],
+			Compressed: s.buf[0] == 1,
+			Index:      s.messages,
+		}
+		if message.Compressed && encoding == "gzip" {
+			data, err := gunzip(message.Data)
+			if err == nil {
+				message.Data = data
+				message.Compressed = false
+			}
+		}
+		d.setPath(message)
+		messages = append(messages, message)
+		s.buf = s.buf[grpcPrefixLength+length:]
+	}
+	return messages
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(reader, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
+
+// Sniff returns true if the payload begins with the connection preface, it
+// implements the fdump.SniffFunc.
+func Sniff(net, transport gopacket.Flow, payload []byte) bool {
+	return bytes.HasPrefix(payload, []byte(Preface))
+}
+
+// BriefAttributes returns the brief columns of the Brief
+func BriefAttributes() []*fdump.BriefColumnAttribute {
+	return []*fdump.BriefColumnAttribute{
+		&fdump.BriefColumnAttribute{
+			Title:    "Stream",
+			MaxWidth: 6,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Type",
+			MaxWidth: 10,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Path",
+			MaxWidth: 40,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Status",
+			MaxWidth: 20,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Length",
+			MaxWidth: 8,
+		},
+	}
+}
+
+// Brief returns the stream id, the type, the path, the status and the data
+// length of the message. It implements the fdump.BriefFunc.
+func Brief(record *fdump.Record) []string {
+	message := recordMessage(record)
+	if message == nil {
+		return nil
+	}
+
+	results := make([]string, 5)
+	results[0] = fmt.Sprintf("%d", message.StreamID)
+	results[1] = message.Type
+	results[2] = message.Path
+	switch {
+	case message.GRPCStatus != "":
+		results[3] = GRPCCode(message.GRPCStatus)
+	case message.Status != "":
+		results[3] = message.Status
+	case message.Type == TypeRST || message.Type == TypeGoAway:
+		results[3] = ErrCodeName(message.ErrCode)
+	case message.Err != "":
+		results[3] = message.Err
+	}
+	if message.Type == TypeMessage || message.Type == TypeBody {
+		results[4] = fmt.Sprintf("%d", len(message.Data))
+	}
+	return results
+}
+
+// Detail returns the header fields or the data of the message, the data is
+// shown as a hex dump if it's not text. It implements the fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	message := recordMessage(record)
+	if message == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stream: %d\nType: %s\n", message.StreamID, message.Type)
+	if message.Path != "" {
+		fmt.Fprintf(&b, "Path: %s\n", message.Path)
+	}
+	if message.Index > 0 {
+		fmt.Fprintf(&b, "Message: %d\n", message.Index)
+	}
+	if message.Compressed {
+		b.WriteString("Compressed: true\n")
+	}
+	if message.Truncated {
+		b.WriteString("Truncated: true\n")
+	}
+	if message.EndStream {
+		b.WriteString("End stream: true\n")
+	}
+	if message.Type == TypeRST || message.Type == TypeGoAway {
+		fmt.Fprintf(&b, "Error code: %s\n", ErrCodeName(message.ErrCode))
+	}
+	if message.GRPCStatus != "" {
+		fmt.Fprintf(&b, "gRPC status: %s %s\n", GRPCCode(message.GRPCStatus), message.GRPCMessage)
+	}
+	if message.Err != "" {
+		fmt.Fprintf(&b, "Error: %s\n", message.Err)
+	}
+	b.WriteString("\n")
+
+	for _, field := range message.Header {
+		fmt.Fprintf(&b, "%s: %s\n", field.Name, field.Value)
+	}
+	if len(message.Data) > 0 {
+		if utf8.Valid(message.Data) {
+			b.Write(message.Data)
+		} else {
+			b.WriteString(hex.Dump(message.Data))
+		}
+	}
+	return b.String()
+}
+
+// Pair pair the response headers with the request headers, and the gRPC
+// messages of the responses with the messages of the requests by the order in
+// the stream. It implements the fdump.PairFunc.
+func Pair(record *fdump.Record) (key string, isRequest bool) {
+	message := recordMessage(record)
+	if message == nil {
+		return
+	}
+	switch message.Type {
+	case TypeHeaders:
+		if message.IsRequest || message.Status != "" {
+			key = fmt.Sprintf("h2:%d:headers", message.StreamID)
+		}
+	case TypeMessage:
+		key = fmt.Sprintf("h2:%d:message:%d", message.StreamID, message.Index)
+	case TypeBody:
+		key = fmt.Sprintf("h2:%d:body", message.StreamID)
+	}
+	return key, message.IsRequest
+}
+
+// Protocol returns the protocol to register to a fdump.Registry. It matches
+// the port 50051 used by the gRPC examples, or the payload begins with the
+// connection preface.
+func Protocol() *fdump.Protocol {
+	return &fdump.Protocol{
+		Name:            "http2",
+		NewDecoder:      NewDecoderFactory(),
+		Brief:           Brief,
+		Detail:          Detail,
+		BriefAttributes: BriefAttributes(),
+		Pair:            Pair,
+		Ports: []fdump.PortRange{
+			fdump.PortRange{Min: 50051, Max: 50051},
+		},
+		Sniff: Sniff,
+	}
+}
+
+func recordMessage(record *fdump.Record) *Message {
+	if record == nil || len(record.Bodies) == 0 {
+		return nil
+	}
+	message, _ := record.Bodies[0].(*Message)
+	return message
+}
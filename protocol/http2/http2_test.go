@@ -0,0 +1,230 @@
+package http2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+	"golang.org/x/net/http2/hpack"
+)
+
+func testFlows(t *testing.T) (gopacket.Flow, gopacket.Flow) {
+	netFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(net.ParseIP("127.0.0.1")),
+		layers.NewIPEndpoint(net.ParseIP("10.2.2.2")))
+	assert.NoError(t, err)
+	transportFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewTCPPortEndpoint(50123),
+		layers.NewTCPPortEndpoint(50051))
+	assert.NoError(t, err)
+	return netFlow, transportFlow
+}
+
+func testFrame(typ, flags byte, streamID uint32, payload []byte) []byte {
+	buf := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[5:], streamID)
+	return append(buf, payload...)
+}
+
+func testHeaders(t *testing.T, encoder *hpack.Encoder, buf *bytes.Buffer, fields ...string) []byte {
+	buf.Reset()
+	for i := 0; i+1 < len(fields); i += 2 {
+		assert.NoError(t, encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}))
+	}
+	return append([]byte{}, buf.Bytes()...)
+}
+
+func testGRPC(data string) []byte {
+	buf := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+	return append(buf, data...)
+}
+
+// testDecodeAll decode the buffer like the fdump stream, it returns the
+// messages.
+func testDecodeAll(t *testing.T, d fdump.StreamDecoder, net, transport gopacket.Flow, buf []byte) []*Message {
+	messages := make([]*Message, 0)
+	for {
+		bodies, n, err := d.Decode(net, transport, buf)
+		if err == fdump.ErrPkgNoEnough {
+			break
+		}
+		assert.NoError(t, err)
+		if n == 0 && len(bodies) == 0 {
+			break
+		}
+		buf = buf[n:]
+		for _, body := range bodies {
+			messages = append(messages, body.(*Message))
+		}
+	}
+	assert.Len(t, buf, 0)
+	return messages
+}
+
+func TestDecodeGRPC(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	var clientBuf, serverBuf bytes.Buffer
+	clientEncoder := hpack.NewEncoder(&clientBuf)
+	serverEncoder := hpack.NewEncoder(&serverBuf)
+
+	req := []byte(Preface)
+	req = append(req, testFrame(FrameSettings, 0, 0, nil)...)
+	for _, streamID := range []uint32{1, 3} {
+		block := testHeaders(t, clientEncoder, &clientBuf,
+			":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello",
+			":authority", "localhost:50051", "content-type", "application/grpc")
+		// the header block is split into a CONTINUATION frame
+		req = append(req, testFrame(FrameHeaders, 0, streamID, block[:3])...)
+		req = append(req, testFrame(FrameContinuation, flagEndHeaders, streamID, block[3:])...)
+	}
+	// two messages of the stream 1 in a frame, a message of the stream 3 is
+	// split into two frames
+	req = append(req, testFrame(FrameData, flagEndStream, 1, append(testGRPC("a"), testGRPC("bb")...))...)
+	data := testGRPC("world")
+	req = append(req, testFrame(FrameData, 0, 3, data[:4])...)
+	req = append(req, testFrame(FrameData, flagEndStream, 3, data[4:])...)
+
+	_, _, err := client.Decode(netFlow, transportFlow, req[:10])
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+	messages := testDecodeAll(t, client, netFlow, transportFlow, req)
+	assert.Len(t, messages, 5)
+	for _, message := range messages[:2] {
+		assert.Equal(t, TypeHeaders, message.Type)
+		assert.True(t, message.IsRequest)
+		assert.Equal(t, "/helloworld.Greeter/SayHello", message.Path)
+	}
+	assert.Equal(t, []byte("a"), messages[2].Data)
+	assert.Equal(t, 1, messages[2].Index)
+	assert.Equal(t, []byte("bb"), messages[3].Data)
+	assert.Equal(t, 2, messages[3].Index)
+	assert.True(t, messages[3].EndStream)
+	assert.Equal(t, uint32(3), messages[4].StreamID)
+	assert.Equal(t, []byte("world"), messages[4].Data)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", messages[4].Path)
+
+	record := &fdump.Record{Bodies: []interface{}{messages[4]}}
+	assert.Equal(t, []string{"3", TypeMessage, "/helloworld.Greeter/SayHello", "", "5"}, Brief(record))
+	key, isRequest := Pair(record)
+	assert.Equal(t, "h2:3:message:1", key)
+	assert.True(t, isRequest)
+
+	rsp := testFrame(FrameSettings, 0, 0, nil)
+	block := testHeaders(t, serverEncoder, &serverBuf, ":status", "200", "content-type", "application/grpc")
+	rsp = append(rsp, testFrame(FrameHeaders, flagEndHeaders, 3, block)...)
+	rsp = append(rsp, testFrame(FrameData, 0, 3, testGRPC("hello world"))...)
+	block = testHeaders(t, serverEncoder, &serverBuf, "grpc-status", "5", "grpc-message", "not found")
+	rsp = append(rsp, testFrame(FrameHeaders, flagEndHeaders|flagEndStream, 3, block)...)
+	rsp = append(rsp, testFrame(FrameRSTStream, 0, 1, []byte{0, 0, 0, 8})...)
+
+	messages = testDecodeAll(t, server, netFlow.Reverse(), transportFlow.Reverse(), rsp)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, TypeHeaders, messages[0].Type)
+	assert.False(t, messages[0].IsRequest)
+	assert.Equal(t, "200", messages[0].Status)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", messages[0].Path)
+	assert.Equal(t, []byte("hello world"), messages[1].Data)
+	key, isRequest = Pair(&fdump.Record{Bodies: []interface{}{messages[1]}})
+	assert.Equal(t, "h2:3:message:1", key)
+	assert.False(t, isRequest)
+	assert.Equal(t, TypeTrailers, messages[2].Type)
+	record = &fdump.Record{Bodies: []interface{}{messages[2]}}
+	assert.Equal(t, []string{"3", TypeTrailers, "/helloworld.Greeter/SayHello", "NOT_FOUND", ""}, Brief(record))
+	assert.Equal(t, TypeRST, messages[3].Type)
+	assert.Equal(t, "CANCEL", ErrCodeName(messages[3].ErrCode))
+
+	client.Close()
+	server.Close()
+}
+
+func TestDecodeBody(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	client := NewDecoderFactory()(netFlow, transportFlow)
+	client.(fdump.DirectionSetter).SetDirection(1, fdump.DirectionClientToServer)
+	var buf bytes.Buffer
+	encoder := hpack.NewEncoder(&buf)
+
+	block := testHeaders(t, encoder, &buf, ":method", "POST", ":path", "/upload", "content-type", "text/plain")
+	req := testFrame(FrameHeaders, flagEndHeaders, 1, block)
+	req = append(req, testFrame(FrameData, 0, 1, []byte("hello "))...)
+	// a padded frame
+	req = append(req, testFrame(FrameData, flagEndStream|flagPadded, 1, []byte("\x02world\x00\x00"))...)
+
+	messages := testDecodeAll(t, client, netFlow, transportFlow, req)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, TypeBody, messages[1].Type)
+	assert.Equal(t, "/upload", messages[1].Path)
+	assert.Equal(t, []byte("hello world"), messages[1].Data)
+	assert.Contains(t, Detail(&fdump.Record{Bodies: []interface{}{messages[1]}}), "hello world")
+	client.Close()
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	client := NewDecoderFactory()(netFlow, transportFlow)
+	client.(fdump.DirectionSetter).SetDirection(1, fdump.DirectionClientToServer)
+	var buf bytes.Buffer
+	encoder := hpack.NewEncoder(&buf)
+	chunk := make([]byte, maxFrameSize)
+
+	// a gRPC message larger than the max size is cut, the next is decoded
+	block := testHeaders(t, encoder, &buf, ":method", "POST", ":path", "/a.B/C", "content-type", "application/grpc")
+	req := testFrame(FrameHeaders, flagEndHeaders, 1, block)
+	large := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(large[1:], maxBodySize+10)
+	req = append(req, testFrame(FrameData, 0, 1, append(large, chunk[:maxFrameSize-5]...))...)
+	rest := maxBodySize + 10 - (maxFrameSize - 5)
+	req = append(req, testFrame(FrameData, 0, 1, append(chunk[:rest], testGRPC("next")...))...)
+	// a body larger than the max size is cut, the rest is dropped
+	block = testHeaders(t, encoder, &buf, ":method", "POST", ":path", "/upload", "content-type", "text/plain")
+	req = append(req, testFrame(FrameHeaders, flagEndHeaders, 3, block)...)
+	req = append(req, testFrame(FrameData, 0, 3, chunk)...)
+	req = append(req, testFrame(FrameData, 0, 3, chunk[:10])...)
+	req = append(req, testFrame(FrameData, flagEndStream, 3, chunk[:10])...)
+
+	messages := testDecodeAll(t, client, netFlow, transportFlow, req)
+	if !assert.Len(t, messages, 5) {
+		return
+	}
+	assert.True(t, messages[1].Truncated)
+	assert.Equal(t, ErrBodyTooLarge.Error(), messages[1].Err)
+	assert.Len(t, messages[1].Data, maxFrameSize-5)
+	assert.Equal(t, []byte("next"), messages[2].Data)
+	assert.Equal(t, 2, messages[2].Index)
+	assert.Equal(t, TypeBody, messages[4].Type)
+	assert.True(t, messages[4].Truncated)
+	assert.Len(t, messages[4].Data, maxBodySize)
+	client.Close()
+}
+
+func TestGunzipTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(make([]byte, maxBodySize+1))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	_, err = gunzip(buf.Bytes())
+	assert.Equal(t, ErrBodyTooLarge, err)
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	client := NewDecoderFactory()(netFlow, transportFlow)
+	_, _, err := client.Decode(netFlow, transportFlow, testFrame(FrameData, flagPadded, 1, []byte{9, 1}))
+	assert.Equal(t, ErrMalformed, err)
+}
+
+func TestSniff(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	assert.True(t, Sniff(netFlow, transportFlow, []byte(Preface)))
+	assert.False(t, Sniff(netFlow, transportFlow, []byte("GET / HTTP/1.1\r\n")))
+}
@@ -0,0 +1,1223 @@
+// Package mysql decode the MySQL client/server protocol for fdump. The packets
+// of the client and the server mean different things, and the handshake
+// negotiates the capabilities of the connection, so the decoders created by
+// NewDecoderFactory know the direction of the stream and share the state of
+// the connection with the decoder of the other direction. It decodes the
+// handshake, the commands, the OK/ERR/EOF packets, the result sets and the
+// prepared statements. The connections using TLS or compression are ignored
+// after the handshake.
+//
+// Use the NewDecoderFactory with fdump.NewStreamApp:
+//
+//	a := fdump.NewStreamApp(mysql.NewDecoderFactory(), mysql.Brief, mysql.Detail, nil, mysql.BriefAttributes())
+package mysql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+)
+
+// the commands of the client
+const (
+	ComSleep            = 0x00
+	ComQuit             = 0x01
+	ComInitDB           = 0x02
+	ComQuery            = 0x03
+	ComFieldList        = 0x04
+	ComCreateDB         = 0x05
+	ComDropDB           = 0x06
+	ComRefresh          = 0x07
+	ComShutdown         = 0x08
+	ComStatistics       = 0x09
+	ComProcessInfo      = 0x0a
+	ComConnect          = 0x0b
+	ComProcessKill      = 0x0c
+	ComDebug            = 0x0d
+	ComPing             = 0x0e
+	ComTime             = 0x0f
+	ComDelayedInsert    = 0x10
+	ComChangeUser       = 0x11
+	ComBinlogDump       = 0x12
+	ComTableDump        = 0x13
+	ComConnectOut       = 0x14
+	ComRegisterSlave    = 0x15
+	ComStmtPrepare      = 0x16
+	ComStmtExecute      = 0x17
+	ComStmtSendLongData = 0x18
+	ComStmtClose        = 0x19
+	ComStmtReset        = 0x1a
+	ComSetOption        = 0x1b
+	ComStmtFetch        = 0x1c
+	ComDaemon           = 0x1d
+	ComBinlogDumpGTID   = 0x1e
+	ComResetConnection  = 0x1f
+)
+
+var commandNames = []string{
+	"COM_SLEEP", "COM_QUIT", "COM_INIT_DB", "COM_QUERY", "COM_FIELD_LIST",
+	"COM_CREATE_DB", "COM_DROP_DB", "COM_REFRESH", "COM_SHUTDOWN",
+	"COM_STATISTICS", "COM_PROCESS_INFO", "COM_CONNECT", "COM_PROCESS_KILL",
+	"COM_DEBUG", "COM_PING", "COM_TIME", "COM_DELAYED_INSERT",
+	"COM_CHANGE_USER", "COM_BINLOG_DUMP", "COM_TABLE_DUMP", "COM_CONNECT_OUT",
+	"COM_REGISTER_SLAVE", "COM_STMT_PREPARE", "COM_STMT_EXECUTE",
+	"COM_STMT_SEND_LONG_DATA", "COM_STMT_CLOSE", "COM_STMT_RESET",
+	"COM_SET_OPTION", "COM_STMT_FETCH", "COM_DAEMON", "COM_BINLOG_DUMP_GTID",
+	"COM_RESET_CONNECTION",
+}
+
+// CommandName returns the name of the command, such as COM_QUERY
+func CommandName(command byte) string {
+	if int(command) < len(commandNames) {
+		return commandNames[command]
+	}
+	return fmt.Sprintf("COM_0x%02x", command)
+}
+
+// the types of the columns and the parameters
+const (
+	TypeDecimal    = 0x00
+	TypeTiny       = 0x01
+	TypeShort      = 0x02
+	TypeLong       = 0x03
+	TypeFloat      = 0x04
+	TypeDouble     = 0x05
+	TypeNull       = 0x06
+	TypeTimestamp  = 0x07
+	TypeLongLong   = 0x08
+	TypeInt24      = 0x09
+	TypeDate       = 0x0a
+	TypeTime       = 0x0b
+	TypeDatetime   = 0x0c
+	TypeYear       = 0x0d
+	TypeVarchar    = 0x0f
+	TypeBit        = 0x10
+	TypeJSON       = 0xf5
+	TypeNewDecimal = 0xf6
+	TypeEnum       = 0xf7
+	TypeSet        = 0xf8
+	TypeTinyBlob   = 0xf9
+	TypeMediumBlob = 0xfa
+	TypeLongBlob   = 0xfb
+	TypeBlob       = 0xfc
+	TypeVarString  = 0xfd
+	TypeString     = 0xfe
+	TypeGeometry   = 0xff
+)
+
+// the capability flags used by the decoder
+const (
+	clientConnectWithDB              = 0x00000008
+	clientCompress                   = 0x00000020
+	clientProtocol41                 = 0x00000200
+	clientSSL                        = 0x00000800
+	clientSecureConnection           = 0x00008000
+	clientPluginAuth                 = 0x00080000
+	clientPluginAuthLenencClientData = 0x00200000
+	clientSessionTrack               = 0x00800000
+	clientDeprecateEOF               = 0x01000000
+	clientQueryAttributes            = 0x08000000
+)
+
+// the headers of the packets
+const (
+	headerOK          = 0x00
+	headerLocalInfile = 0xfb
+	headerEOF         = 0xfe
+	headerErr         = 0xff
+	handshakeV10      = 0x0a
+)
+
+const (
+	serverMoreResultsExists       = 0x0008 // the status of the OK and EOF
+	flagUnsigned                  = 0x0020 // the flags of the column
+	paramUnsigned                 = 0x8000 // the type of the parameter
+	cursorParameterCountAvailable = 0x08   // the flags of the COM_STMT_EXECUTE
+)
+
+// defaultServerPort tell the client from the server if the direction is
+// unknown
+const defaultServerPort uint16 = 3306
+
+// maxRows the rows of a result set kept in the ResultSet, the others are only
+// counted.
+const maxRows = 100
+
+// maxColumns the most columns of a result set, the column count of the
+// COM_STMT_PREPARE_OK is 2 bytes.
+const maxColumns = 0xffff
+
+// minColumnLen the length of the shortest column definition packet, the
+// header and the empty strings.
+const minColumnLen = 4 + 17
+
+// ErrMalformed the packet is shorter than its fields
+var ErrMalformed = errors.New("mysql malformed packet")
+
+// the kinds of the messages
+const (
+	KindHandshake         = "Handshake"
+	KindHandshakeResponse = "HandshakeResponse"
+	KindSSLRequest        = "SSLRequest"
+	KindAuthSwitch        = "AuthSwitch"
+	KindAuthMoreData      = "AuthMoreData"
+	KindAuthData          = "AuthData"
+	KindCommand           = "Command"
+	KindData              = "Data"
+	KindOK                = "OK"
+	KindErr               = "ERR"
+	KindEOF               = "EOF"
+	KindResultSet         = "ResultSet"
+	KindStmtPrepareOK     = "StmtPrepareOK"
+	KindLocalInfile       = "LocalInfile"
+	KindResponse          = "Response"
+)
+
+// Message a decoded packet, or the packets of a result set
+type Message struct {
+	Kind       string
+	SequenceID uint8 // the sequence id of the first packet
+	Command    byte  // the command of a KindCommand, or the command replied
+
+	// Seq is the order of the command in the connection, a response has the
+	// Seq of its command. It's zero if the message isn't a command or a
+	// response.
+	Seq int `json:",omitempty"`
+
+	// Body is *Handshake, *HandshakeResponse, *AuthSwitch, *Command, *OK,
+	// *Err, *EOF, *ResultSet, *StmtPrepareOK, or the string of the
+	// COM_STATISTICS and the LOCAL INFILE request. It's nil for the other
+	// kinds.
+	Body interface{} `json:",omitempty"`
+}
+
+// Handshake the initial handshake of the server
+type Handshake struct {
+	ProtocolVersion uint8
+	ServerVersion   string
+	ConnectionID    uint32
+	Capabilities    uint32
+	Charset         uint8
+	Status          uint16
+	AuthPlugin      string
+}
+
+// HandshakeResponse the handshake response of the client
+type HandshakeResponse struct {
+	Capabilities uint32
+	MaxPacket    uint32
+	Charset      uint8
+	User         string
+	Database     string `json:",omitempty"`
+	AuthPlugin   string `json:",omitempty"`
+}
+
+// AuthSwitch the server asks the client to use another auth method
+type AuthSwitch struct {
+	Plugin string
+}
+
+// Command a command of the client
+type Command struct {
+	// Query is the sql of COM_QUERY and COM_STMT_PREPARE, or the sql of the
+	// statement of COM_STMT_EXECUTE if its prepare is decoded.
+	Query  string   `json:",omitempty"`
+	StmtID uint32   `json:",omitempty"` // the statement of COM_STMT_*
+	Params []*Param `json:",omitempty"` // the parameters of COM_STMT_EXECUTE or the attributes of COM_QUERY
+	Arg    string   `json:",omitempty"` // the argument of the other commands, such as the schema of COM_INIT_DB
+}
+
+// Param a parameter of a statement or an attribute of a query
+type Param struct {
+	Name  string `json:",omitempty"`
+	Type  byte
+	Value interface{} // nil if it's NULL or the type of the parameter is unknown
+}
+
+// OK an OK packet
+type OK struct {
+	AffectedRows uint64
+	LastInsertID uint64
+	Status       uint16
+	Warnings     uint16
+	Info         string `json:",omitempty"`
+}
+
+// Err an ERR packet
+type Err struct {
+	Code    uint16
+	State   string
+	Message string
+}
+
+func (e *Err) String() string {
+	if e.State == "" {
+		return fmt.Sprintf("ERROR %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.State, e.Message)
+}
+
+// EOF an EOF packet
+type EOF struct {
+	Warnings uint16
+	Status   uint16
+}
+
+// Column a column definition
+type Column struct {
+	Schema   string
+	Table    string
+	OrgTable string
+	Name     string
+	OrgName  string
+	Charset  uint16
+	Length   uint32
+	Type     byte
+	Flags    uint16
+	Decimals byte
+}
+
+// ResultSet the columns and the rows replied to a query. The rows of the text
+// protocol are strings, the values of the binary protocol are converted by
+// the column types. The NULL is nil.
+type ResultSet struct {
+	Binary   bool // replied to a COM_STMT_EXECUTE
+	Columns  []*Column
+	Rows     [][]interface{} // the first maxRows rows
+	RowCount int
+	Warnings uint16
+	Status   uint16
+	Err      *Err `json:",omitempty"` // the error stopped the rows
+}
+
+// StmtPrepareOK the reply of a COM_STMT_PREPARE
+type StmtPrepareOK struct {
+	StmtID   uint32
+	Params   []*Column
+	Columns  []*Column
+	Warnings uint16
+}
+
+// NewDecoderFactory returns a fdump.StreamDecoderFactory. The decoders of the
+// both directions of a connection share the state of the connection, such as
+// the capabilities, the commands waiting for the responses and the prepared
+// statements.
+func NewDecoderFactory() fdump.StreamDecoderFactory {
+	conns := &conns{
+		states: make(map[[2]gopacket.Flow]*connState),
+	}
+	return func(net, transport gopacket.Flow) fdump.StreamDecoder {
+		return &decoder{
+			conns: conns,
+		}
+	}
+}
+
+type phase int
+
+const (
+	phaseHandshake phase = iota // waiting for the handshake response
+	phaseAuth                   // waiting for the auth result
+	phaseCommand
+	phaseOpaque // encrypted or compressed, not decodable
+)
+
+// pending a command waiting for the response
+type pending struct {
+	command byte
+	seq     int
+	query   string     // the sql of a COM_STMT_PREPARE
+	stmt    *statement // the statement of a COM_STMT_EXECUTE or COM_STMT_FETCH
+}
+
+// statement a prepared statement
+type statement struct {
+	query   string
+	params  int
+	types   []uint16 // bound by the last execution
+	columns []*Column
+}
+
+// connState the state of a connection
+type connState struct {
+	phase        phase
+	serverCaps   uint32
+	capabilities uint32 // zero until the handshake response is decoded
+	pendings     []*pending
+	commands     int
+	statements   map[uint32]*statement
+	refs         int
+}
+
+// conns the states of the connections keyed by the flows of the client
+type conns struct {
+	mutex  sync.Mutex
+	states map[[2]gopacket.Flow]*connState
+}
+
+func (c *conns) attach(key [2]gopacket.Flow) *connState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.states[key]
+	if !ok {
+		state = &connState{
+			statements: make(map[uint32]*statement),
+		}
+		c.states[key] = state
+	}
+	state.refs++
+	return state
+}
+
+func (c *conns) detach(key [2]gopacket.Flow) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.states[key]
+	if !ok {
+		return
+	}
+	state.refs--
+	if state.refs <= 0 {
+		delete(c.states, key)
+	}
+}
+
+type decoder struct {
+	conns     *conns
+	direction fdump.Direction
+	client    bool
+	key       [2]gopacket.Flow
+	state     *connState
+}
+
+// SetDirection implements the fdump.DirectionSetter
+func (d *decoder) SetDirection(connID uint64, direction fdump.Direction) {
+	d.direction = direction
+}
+
+// Gap implements the fdump.GapHandler. The commands waiting for the responses
+// are forgotten, and a stream picked up in the middle is in the command
+// phase.
+func (d *decoder) Gap(net, transport gopacket.Flow, skip int) {
+	state := d.attach(net, transport)
+	d.conns.mutex.Lock()
+	defer d.conns.mutex.Unlock()
+	state.pendings = nil
+	if skip < 0 && state.phase != phaseOpaque {
+		state.phase = phaseCommand
+	}
+}
+
+// attach find the state of the connection when the first payload comes, the
+// direction is known then.
+func (d *decoder) attach(net, transport gopacket.Flow) *connState {
+	if d.state != nil {
+		return d.state
+	}
+	switch d.direction {
+	case fdump.DirectionClientToServer:
+		d.client = true
+	case fdump.DirectionServerToClient:
+		d.client = false
+	default:
+		// the client uses an ephemeral port if the server port isn't known
+		src, dst := srcPort(transport), dstPort(transport)
+		d.client = dst == defaultServerPort || (src != defaultServerPort && src > dst)
+	}
+	d.key = [2]gopacket.Flow{net, transport}
+	if !d.client {
+		d.key = [2]gopacket.Flow{net.Reverse(), transport.Reverse()}
+	}
+	d.state = d.conns.attach(d.key)
+	return d.state
+}
+
+func (d *decoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	state := d.attach(net, transport)
+	d.conns.mutex.Lock()
+	defer d.conns.mutex.Unlock()
+
+	if state.phase == phaseOpaque {
+		n = len(buf)
+		return
+	}
+
+	payload, sequenceID, n, err := readPacket(buf)
+	if err != nil {
+		return
+	}
+	var message *Message
+	if d.client {
+		message, err = d.decodeClient(payload, sequenceID)
+	} else {
+		message, n, err = d.decodeServer(buf, payload, sequenceID, n)
+	}
+	if err != nil {
+		n = 0
+		return
+	}
+	message.SequenceID = sequenceID
+	bodies = append(bodies, message)
+	return
+}
+
+func (d *decoder) Close() {
+	if d.state != nil {
+		d.conns.detach(d.key)
+	}
+}
+
+func (d *decoder) decodeClient(payload []byte, sequenceID uint8) (*Message, error) {
+	state := d.state
+	if state.phase == phaseHandshake && sequenceID == 0 {
+		// the handshake isn't captured
+		state.phase = phaseCommand
+	}
+
+	switch state.phase {
+	case phaseHandshake:
+		return d.decodeHandshakeResponse(payload)
+	case phaseAuth:
+		return &Message{Kind: KindAuthData}, nil
+	}
+
+	if sequenceID != 0 || len(payload) == 0 {
+		// the content of a LOAD DATA LOCAL INFILE
+		return &Message{Kind: KindData}, nil
+	}
+
+	message := &Message{
+		Kind:    KindCommand,
+		Command: payload[0],
+	}
+	cmd := &Command{}
+	message.Body = cmd
+	r := &reader{buf: payload[1:]}
+	p := &pending{command: message.Command}
+	var stmt *statement
+	var types []uint16
+	switch message.Command {
+	case ComQuery:
+		if state.capabilities&clientQueryAttributes != 0 {
+			count, _ := r.lenenc()
+			r.lenenc() // the parameter set count, always 1
+			if count > 0 {
+				cmd.Params, _ = readParams(r, int(count), nil, true)
+			}
+		}
+		cmd.Query = string(r.rest())
+	case ComStmtPrepare:
+		cmd.Query = string(r.rest())
+		p.query = cmd.Query
+	case ComStmtExecute:
+		cmd.StmtID = r.uint32()
+		flags := r.byte()
+		r.uint32() // the iteration count, always 1
+		stmt = state.statements[cmd.StmtID]
+		count := 0
+		if stmt != nil {
+			cmd.Query = stmt.query
+			count = stmt.params
+			types = stmt.types
+		}
+		if flags&cursorParameterCountAvailable != 0 {
+			c, _ := r.lenenc()
+			if c > uint64(r.len())*8 {
+				return nil, ErrMalformed
+			}
+			count = int(c)
+		}
+		if count > 0 && r.len() > 0 {
+			cmd.Params, types = readParams(r, count, types, state.capabilities&clientQueryAttributes != 0)
+		}
+		p.stmt = stmt
+	case ComStmtFetch, ComStmtClose, ComStmtReset, ComStmtSendLongData:
+		cmd.StmtID = r.uint32()
+		stmt = state.statements[cmd.StmtID]
+		if stmt != nil {
+			cmd.Query = stmt.query
+		}
+		p.stmt = stmt
+	case ComProcessKill:
+		cmd.Arg = fmt.Sprintf("%d", r.uint32())
+	default:
+		cmd.Arg = strings.TrimRight(string(r.rest()), "\x00")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch message.Command {
+	case ComStmtExecute:
+		if stmt != nil {
+			stmt.types = types
+		}
+	case ComStmtClose:
+		delete(state.statements, cmd.StmtID)
+	}
+
+	switch message.Command {
+	case ComQuit, ComStmtClose, ComStmtSendLongData:
+		// no response
+	default:
+		state.commands++
+		message.Seq = state.commands
+		p.seq = message.Seq
+		state.pendings = append(state.pendings, p)
+	}
+	return message, nil
+}
+
+func (d *decoder) decodeHandshakeResponse(payload []byte) (*Message, error) {
+	state := d.state
+	r := &reader{buf: payload}
+	response := &HandshakeResponse{}
+	message := &Message{
+		Kind: KindHandshakeResponse,
+		Body: response,
+	}
+
+	if len(payload) >= 2 && binary.LittleEndian.Uint16(payload)&clientProtocol41 != 0 {
+		response.Capabilities = r.uint32()
+		response.MaxPacket = r.uint32()
+		response.Charset = r.byte()
+		r.bytes(23) // filler
+		if r.err == nil && r.len() == 0 && response.Capabilities&clientSSL != 0 {
+			message.Kind = KindSSLRequest
+			state.phase = phaseOpaque
+			return message, nil
+		}
+		response.User = r.nulString()
+		switch {
+		case response.Capabilities&clientPluginAuthLenencClientData != 0:
+			r.lenencString()
+		case response.Capabilities&clientSecureConnection != 0:
+			r.bytes(int(r.byte()))
+		default:
+			r.nulString()
+		}
+		if response.Capabilities&clientConnectWithDB != 0 && r.len() > 0 {
+			response.Database = r.nulString()
+		}
+		if response.Capabilities&clientPluginAuth != 0 && r.len() > 0 {
+			response.AuthPlugin = r.nulString()
+		}
+	} else {
+		response.Capabilities = uint32(r.uint16())
+		response.MaxPacket = r.uint24()
+		response.User = r.nulString()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	state.capabilities = response.Capabilities
+	if state.serverCaps != 0 {
+		state.capabilities &= state.serverCaps
+	}
+	state.phase = phaseAuth
+	return message, nil
+}
+
+// decodeServer decode a response of the server. A result set has several
+// packets, it returns the length of all of them.
+func (d *decoder) decodeServer(buf, payload []byte, sequenceID uint8, n int) (*Message, int, error) {
+	state := d.state
+	if len(payload) == 0 {
+		return nil, 0, ErrMalformed
+	}
+	if state.phase == phaseHandshake && sequenceID != 0 {
+		// the handshake isn't captured
+		state.phase = phaseCommand
+	}
+
+	switch state.phase {
+	case phaseHandshake:
+		message, err := d.decodeHandshake(payload)
+		return message, n, err
+	case phaseAuth:
+		message, err := d.decodeAuth(payload)
+		return message, n, err
+	}
+
+	p := &pending{command: ComQuery}
+	if len(state.pendings) > 0 {
+		p = state.pendings[0]
+	}
+	message := &Message{
+		Command: p.command,
+		Seq:     p.seq,
+	}
+
+	var err error
+	more := false
+	switch {
+	case payload[0] == headerErr:
+		message.Kind = KindErr
+		message.Body, err = readErr(payload)
+	case payload[0] == headerOK && p.command == ComStmtPrepare:
+		var prepareOK *StmtPrepareOK
+		prepareOK, n, err = d.readPrepareOK(buf, payload, n)
+		if err == nil {
+			message.Kind = KindStmtPrepareOK
+			message.Body = prepareOK
+		}
+	case payload[0] == headerOK && p.command != ComStmtFetch:
+		var ok *OK
+		ok, err = readOK(payload, state.capabilities)
+		if err == nil {
+			message.Kind = KindOK
+			message.Body = ok
+			more = ok.Status&serverMoreResultsExists != 0
+		}
+	case isEOF(payload):
+		var eof *EOF
+		eof, err = readEOF(payload)
+		if err == nil {
+			message.Kind = KindEOF
+			message.Body = eof
+			more = eof.Status&serverMoreResultsExists != 0
+		}
+	case payload[0] == headerEOF:
+		// an AuthSwitchRequest of a COM_CHANGE_USER or a handshake not
+		// captured, a column count can't be so large
+		message.Kind = KindResponse
+	case payload[0] == headerLocalInfile && p.command == ComQuery:
+		message.Kind = KindLocalInfile
+		message.Body = string(payload[1:])
+		// the OK after the content of the file is the response
+		more = true
+	case p.command == ComQuery || p.command == ComStmtExecute ||
+		p.command == ComFieldList || p.command == ComStmtFetch:
+		var rs *ResultSet
+		rs, n, err = d.readResultSet(buf, payload, n, p)
+		if err == nil {
+			message.Kind = KindResultSet
+			message.Body = rs
+			more = rs.Status&serverMoreResultsExists != 0
+		}
+	case p.command == ComStatistics:
+		message.Kind = KindResponse
+		message.Body = string(payload)
+	default:
+		message.Kind = KindResponse
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(state.pendings) > 0 && !more {
+		state.pendings = state.pendings[1:]
+	}
+	if prepareOK, ok := message.Body.(*StmtPrepareOK); ok {
+		state.statements[prepareOK.StmtID] = &statement{
+			query:   p.query,
+			params:  len(prepareOK.Params),
+			columns: prepareOK.Columns,
+		}
+	}
+	return message, n, nil
+}
+
+func (d *decoder) decodeHandshake(payload []byte) (*Message, error) {
+	state := d.state
+	if payload[0] == headerErr {
+		e, err := readErr(payload)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Kind: KindErr, Body: e}, nil
+	}
+	if payload[0] != handshakeV10 {
+		return nil, fmt.Errorf("mysql unsupported protocol version %d", payload[0])
+	}
+
+	r := &reader{buf: payload}
+	handshake := &Handshake{}
+	handshake.ProtocolVersion = r.byte()
+	handshake.ServerVersion = r.nulString()
+	handshake.ConnectionID = r.uint32()
+	r.bytes(8) // auth-plugin-data-part-1
+	r.byte()   // filler
+	handshake.Capabilities = uint32(r.uint16())
+	if r.len() > 0 {
+		handshake.Charset = r.byte()
+		handshake.Status = r.uint16()
+		handshake.Capabilities |= uint32(r.uint16()) << 16
+		authLength := int(r.byte())
+		r.bytes(10) // reserved
+		if handshake.Capabilities&clientSecureConnection != 0 {
+			length := authLength - 8
+			if length < 13 {
+				length = 13
+			}
+			r.bytes(length)
+		}
+		if handshake.Capabilities&clientPluginAuth != 0 {
+			handshake.AuthPlugin = r.nulString()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	state.serverCaps = handshake.Capabilities
+	return &Message{Kind: KindHandshake, Body: handshake}, nil
+}
+
+func (d *decoder) decodeAuth(payload []byte) (*Message, error) {
+	state := d.state
+	switch payload[0] {
+	case headerOK:
+		ok, err := readOK(payload, state.capabilities)
+		if err != nil {
+			return nil, err
+		}
+		state.phase = phaseCommand
+		if state.capabilities&clientCompress != 0 {
+			state.phase = phaseOpaque
+		}
+		return &Message{Kind: KindOK, Body: ok}, nil
+	case headerErr:
+		e, err := readErr(payload)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Kind: KindErr, Body: e}, nil
+	case headerEOF:
+		r := &reader{buf: payload[1:]}
+		return &Message{
+			Kind: KindAuthSwitch,
+			Body: &AuthSwitch{Plugin: r.nulString()},
+		}, nil
+	}
+	return &Message{Kind: KindAuthMoreData}, nil
+}
+
+// nextPacket read the packet at n, it returns the new n.
+func nextPacket(buf []byte, n int) ([]byte, int, error) {
+	payload, _, m, err := readPacket(buf[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return payload, n + m, nil
+}
+
+// readColumns read the column definitions and the EOF following them
+func (d *decoder) readColumns(buf []byte, n int, count int) ([]*Column, int, error) {
+	if count < 0 || count > maxColumns {
+		return nil, 0, ErrMalformed
+	}
+	if len(buf)-n < count*minColumnLen {
+		return nil, 0, fdump.ErrPkgNoEnough
+	}
+	columns := make([]*Column, 0, count)
+	for i := 0; i < count; i++ {
+		payload, m, err := nextPacket(buf, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		column, err := readColumn(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		columns = append(columns, column)
+		n = m
+	}
+	if count == 0 || d.state.capabilities&clientDeprecateEOF != 0 {
+		return columns, n, nil
+	}
+
+	payload, m, err := nextPacket(buf, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	if isEOF(payload) {
+		// it isn't there if the CLIENT_DEPRECATE_EOF is set but the handshake
+		// isn't captured
+		n = m
+	}
+	return columns, n, nil
+}
+
+func (d *decoder) readPrepareOK(buf, payload []byte, n int) (*StmtPrepareOK, int, error) {
+	r := &reader{buf: payload[1:]}
+	prepareOK := &StmtPrepareOK{}
+	prepareOK.StmtID = r.uint32()
+	columns := int(r.uint16())
+	params := int(r.uint16())
+	r.byte() // reserved
+	if r.len() >= 2 {
+		prepareOK.Warnings = r.uint16()
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+
+	var err error
+	prepareOK.Params, n, err = d.readColumns(buf, n, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	prepareOK.Columns, n, err = d.readColumns(buf, n, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	return prepareOK, n, nil
+}
+
+// readResultSet read the column definitions, the rows and the terminator of a
+// result set. The COM_STMT_FETCH replies the rows only.
+func (d *decoder) readResultSet(buf, payload []byte, n int, p *pending) (*ResultSet, int, error) {
+	rs := &ResultSet{
+		Binary: p.command == ComStmtExecute || p.command == ComStmtFetch,
+	}
+
+	var err error
+	switch p.command {
+	case ComFieldList:
+		// the columns until the EOF
+		for !isTerminator(payload) {
+			column, err := readColumn(payload)
+			if err != nil {
+				return nil, 0, err
+			}
+			rs.Columns = append(rs.Columns, column)
+			payload, n, err = nextPacket(buf, n)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return rs, n, d.readTerminator(rs, payload)
+	case ComStmtFetch:
+		if p.stmt != nil {
+			rs.Columns = p.stmt.columns
+		}
+	default:
+		r := &reader{buf: payload}
+		count, _ := r.lenenc()
+		if r.err != nil {
+			return nil, 0, r.err
+		}
+		if count > maxColumns {
+			return nil, 0, ErrMalformed
+		}
+		rs.Columns, n, err = d.readColumns(buf, n, int(count))
+		if err != nil {
+			return nil, 0, err
+		}
+		payload, n, err = nextPacket(buf, n)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for !isTerminator(payload) {
+		if rs.RowCount < maxRows {
+			var row []interface{}
+			if rs.Binary {
+				row, err = readBinaryRow(payload, rs.Columns)
+			} else {
+				row, err = readTextRow(payload, len(rs.Columns))
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			rs.Rows = append(rs.Rows, row)
+		}
+		rs.RowCount++
+		payload, n, err = nextPacket(buf, n)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return rs, n, d.readTerminator(rs, payload)
+}
+
+// readTerminator read the EOF, OK or ERR packet ending the rows
+func (d *decoder) readTerminator(rs *ResultSet, payload []byte) error {
+	switch {
+	case payload[0] == headerErr:
+		e, err := readErr(payload)
+		if err != nil {
+			return err
+		}
+		rs.Err = e
+	case isEOF(payload):
+		eof, err := readEOF(payload)
+		if err != nil {
+			return err
+		}
+		rs.Warnings = eof.Warnings
+		rs.Status = eof.Status
+	default:
+		ok, err := readOK(payload, d.state.capabilities)
+		if err != nil {
+			return err
+		}
+		rs.Warnings = ok.Warnings
+		rs.Status = ok.Status
+	}
+	return nil
+}
+
+func srcPort(transport gopacket.Flow) uint16 {
+	raw := transport.Src().Raw()
+	if len(raw) != 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(raw)
+}
+
+func dstPort(transport gopacket.Flow) uint16 {
+	raw := transport.Dst().Raw()
+	if len(raw) != 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(raw)
+}
+
+// Sniff returns true if the payload is a handshake of the server or a
+// COM_QUERY, it implements the fdump.SniffFunc.
+func Sniff(net, transport gopacket.Flow, payload []byte) bool {
+	if len(payload) < 5 || payload[3] != 0 {
+		return false
+	}
+	length := int(payload[0]) | int(payload[1])<<8 | int(payload[2])<<16
+	switch payload[4] {
+	case handshakeV10:
+		return length == len(payload)-4 && strings.IndexByte(string(payload[5:]), 0) > 0
+	case ComQuery:
+		return length == len(payload)-4
+	}
+	return false
+}
+
+// BriefAttributes returns the brief columns of the Brief
+func BriefAttributes() []*fdump.BriefColumnAttribute {
+	return []*fdump.BriefColumnAttribute{
+		&fdump.BriefColumnAttribute{
+			Title:    "Type",
+			MaxWidth: 17,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "SQL",
+			MaxWidth: 48,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Result",
+			MaxWidth: 24,
+		},
+	}
+}
+
+// Brief returns the type of the message, the sql of a command and the summary
+// of a response. It implements the fdump.BriefFunc.
+func Brief(record *fdump.Record) []string {
+	message := recordMessage(record)
+	if message == nil {
+		return nil
+	}
+
+	results := make([]string, 3)
+	results[0] = message.Kind
+	switch body := message.Body.(type) {
+	case *Handshake:
+		results[1] = body.ServerVersion
+	case *HandshakeResponse:
+		results[1] = body.User
+	case *Command:
+		results[0] = CommandName(message.Command)
+		results[1] = body.Query
+		if results[1] == "" {
+			results[1] = body.Arg
+		}
+		if body.StmtID != 0 && body.Query == "" {
+			results[1] = fmt.Sprintf("stmt %d", body.StmtID)
+		}
+	case *OK:
+		results[2] = fmt.Sprintf("%d affected", body.AffectedRows)
+	case *Err:
+		results[2] = fmt.Sprintf("%d %s", body.Code, body.Message)
+	case *ResultSet:
+		results[2] = fmt.Sprintf("%d cols, %d rows", len(body.Columns), body.RowCount)
+		if body.Err != nil {
+			results[2] = fmt.Sprintf("%d %s", body.Err.Code, body.Err.Message)
+		}
+	case *StmtPrepareOK:
+		results[2] = fmt.Sprintf("stmt %d, %d params", body.StmtID, len(body.Params))
+	case string:
+		results[2] = body
+	}
+	results[1] = strings.Join(strings.Fields(results[1]), " ")
+	return results
+}
+
+// Detail returns the fields of the message, a result set is shown as a table.
+// It implements the fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	message := recordMessage(record)
+	if message == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Type: %s\nSequence: %d\n", message.Kind, message.SequenceID)
+	if message.Kind == KindCommand || message.Seq > 0 {
+		fmt.Fprintf(&b, "Command: %s\n", CommandName(message.Command))
+	}
+	if message.Seq > 0 {
+		fmt.Fprintf(&b, "Seq: %d\n", message.Seq)
+	}
+	b.WriteString("\n")
+
+	switch body := message.Body.(type) {
+	case *Handshake:
+		fmt.Fprintf(&b, "Protocol: %d\nServer: %s\nConnection: %d\nCapabilities: 0x%08x\nCharset: %d\nStatus: 0x%04x\nAuth plugin: %s\n",
+			body.ProtocolVersion, body.ServerVersion, body.ConnectionID, body.Capabilities, body.Charset, body.Status, body.AuthPlugin)
+	case *HandshakeResponse:
+		fmt.Fprintf(&b, "User: %s\nDatabase: %s\nCapabilities: 0x%08x\nMax packet: %d\nCharset: %d\nAuth plugin: %s\n",
+			body.User, body.Database, body.Capabilities, body.MaxPacket, body.Charset, body.AuthPlugin)
+	case *AuthSwitch:
+		fmt.Fprintf(&b, "Auth plugin: %s\n", body.Plugin)
+	case *Command:
+		if body.StmtID != 0 {
+			fmt.Fprintf(&b, "Statement: %d\n", body.StmtID)
+		}
+		if body.Arg != "" {
+			fmt.Fprintf(&b, "Argument: %s\n", body.Arg)
+		}
+		if body.Query != "" {
+			fmt.Fprintf(&b, "%s\n", body.Query)
+		}
+		for i, param := range body.Params {
+			name := param.Name
+			if name == "" {
+				name = fmt.Sprintf("?%d", i+1)
+			}
+			fmt.Fprintf(&b, "%s = %s\n", name, value(param.Value))
+		}
+	case *OK:
+		fmt.Fprintf(&b, "Affected rows: %d\nLast insert id: %d\nStatus: 0x%04x\nWarnings: %d\n",
+			body.AffectedRows, body.LastInsertID, body.Status, body.Warnings)
+		if body.Info != "" {
+			fmt.Fprintf(&b, "Info: %s\n", body.Info)
+		}
+	case *Err:
+		fmt.Fprintf(&b, "%s\n", body)
+	case *EOF:
+		fmt.Fprintf(&b, "Status: 0x%04x\nWarnings: %d\n", body.Status, body.Warnings)
+	case *StmtPrepareOK:
+		fmt.Fprintf(&b, "Statement: %d\nParams: %d\nWarnings: %d\n", body.StmtID, len(body.Params), body.Warnings)
+		if len(body.Columns) > 0 {
+			b.WriteString("\n")
+			table(&b, body.Columns, nil)
+		}
+	case *ResultSet:
+		table(&b, body.Columns, body.Rows)
+		if body.RowCount > len(body.Rows) {
+			fmt.Fprintf(&b, "... %d more rows\n", body.RowCount-len(body.Rows))
+		}
+		fmt.Fprintf(&b, "%d rows, %d warnings\n", body.RowCount, body.Warnings)
+		if body.Err != nil {
+			fmt.Fprintf(&b, "%s\n", body.Err)
+		}
+	case string:
+		fmt.Fprintf(&b, "%s\n", body)
+	}
+	return b.String()
+}
+
+// table write the columns and the rows like the mysql client
+func table(b *strings.Builder, columns []*Column, rows [][]interface{}) {
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = len(column.Name)
+	}
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(columns))
+		for j := range columns {
+			if j < len(row) {
+				cells[i][j] = value(row[j])
+			}
+			if len(cells[i][j]) > widths[j] {
+				widths[j] = len(cells[i][j])
+			}
+		}
+	}
+
+	line := "+"
+	for _, width := range widths {
+		line += strings.Repeat("-", width+2) + "+"
+	}
+	line += "\n"
+	writeRow := func(values []string) {
+		b.WriteString("|")
+		for i, v := range values {
+			fmt.Fprintf(b, " %-*s |", widths[i], v)
+		}
+		b.WriteString("\n")
+	}
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	b.WriteString(line)
+	writeRow(names)
+	b.WriteString(line)
+	for _, row := range cells {
+		writeRow(row)
+	}
+	if len(rows) > 0 {
+		b.WriteString(line)
+	}
+}
+
+func value(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Pair pair the responses with the commands by the Seq, it implements the
+// fdump.PairFunc.
+func Pair(record *fdump.Record) (key string, isRequest bool) {
+	message := recordMessage(record)
+	if message == nil || message.Seq == 0 || message.Kind == KindLocalInfile {
+		return
+	}
+	return fmt.Sprintf("mysql:%d", message.Seq), message.Kind == KindCommand
+}
+
+// Protocol returns the protocol to register to a fdump.Registry. It matches
+// the port 3306, or the payload begins with a handshake or a COM_QUERY.
+func Protocol() *fdump.Protocol {
+	return &fdump.Protocol{
+		Name:            "mysql",
+		NewDecoder:      NewDecoderFactory(),
+		Brief:           Brief,
+		Detail:          Detail,
+		BriefAttributes: BriefAttributes(),
+		Pair:            Pair,
+		Ports: []fdump.PortRange{
+			fdump.PortRange{Min: 3306, Max: 3306},
+		},
+		Sniff: Sniff,
+	}
+}
+
+func recordMessage(record *fdump.Record) *Message {
+	if record == nil || len(record.Bodies) == 0 {
+		return nil
+	}
+	message, _ := record.Bodies[0].(*Message)
+	return message
+}
@@ -0,0 +1,257 @@
+package mysql
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+func testFlows(t *testing.T) (gopacket.Flow, gopacket.Flow) {
+	netFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewIPEndpoint(net.ParseIP("127.0.0.1")),
+		layers.NewIPEndpoint(net.ParseIP("10.2.2.2")))
+	assert.NoError(t, err)
+	transportFlow, err := gopacket.FlowFromEndpoints(
+		layers.NewTCPPortEndpoint(50123),
+		layers.NewTCPPortEndpoint(3306))
+	assert.NoError(t, err)
+	return netFlow, transportFlow
+}
+
+func testPacket(sequenceID uint8, payload ...[]byte) []byte {
+	length := 0
+	for _, p := range payload {
+		length += len(p)
+	}
+	buf := []byte{byte(length), byte(length >> 8), byte(length >> 16), sequenceID}
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+func testString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func testColumn(name string, typ byte) []byte {
+	buf := []byte{}
+	for _, s := range []string{"def", "test", "t", "t", name, name} {
+		buf = append(buf, testString(s)...)
+	}
+	return append(buf, 0x0c, 0x21, 0x00, 0x0b, 0x00, 0x00, 0x00, typ, 0x00, 0x00, 0x00, 0x00, 0x00)
+}
+
+func testDecode(t *testing.T, d fdump.StreamDecoder, net, transport gopacket.Flow, buf []byte) *Message {
+	for i := 0; i < len(buf); i++ {
+		_, _, err := d.Decode(net, transport, buf[:i])
+		assert.Equal(t, fdump.ErrPkgNoEnough, err, "length %d", i)
+	}
+	bodies, n, err := d.Decode(net, transport, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	return bodies[0].(*Message)
+}
+
+func TestDecodeHandshakeAndQuery(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	client.(fdump.DirectionSetter).SetDirection(1, fdump.DirectionClientToServer)
+	server.(fdump.DirectionSetter).SetDirection(1, fdump.DirectionServerToClient)
+	decodeClient := func(buf []byte) *Message {
+		return testDecode(t, client, netFlow, transportFlow, buf)
+	}
+	decodeServer := func(buf []byte) *Message {
+		return testDecode(t, server, netFlow.Reverse(), transportFlow.Reverse(), buf)
+	}
+
+	// the capabilities: protocol41, secure connection, plugin auth
+	greeting := testPacket(0,
+		[]byte{handshakeV10}, []byte("8.0.33\x00"),
+		[]byte{7, 0, 0, 0}, make([]byte, 8), []byte{0},
+		[]byte{0x00, 0x82}, []byte{0x21}, []byte{0x02, 0x00}, []byte{0x08, 0x00},
+		[]byte{21}, make([]byte, 10), make([]byte, 13), []byte("mysql_native_password\x00"))
+	message := decodeServer(greeting)
+	assert.Equal(t, KindHandshake, message.Kind)
+	handshake := message.Body.(*Handshake)
+	assert.Equal(t, "8.0.33", handshake.ServerVersion)
+	assert.Equal(t, uint32(7), handshake.ConnectionID)
+	assert.Equal(t, "mysql_native_password", handshake.AuthPlugin)
+
+	response := testPacket(1,
+		[]byte{0x08, 0x82, 0x08, 0x00}, []byte{0, 0, 0, 1}, []byte{0x21}, make([]byte, 23),
+		[]byte("root\x00"), []byte{0}, []byte("test\x00"), []byte("mysql_native_password\x00"))
+	message = decodeClient(response)
+	assert.Equal(t, KindHandshakeResponse, message.Kind)
+	assert.Equal(t, "root", message.Body.(*HandshakeResponse).User)
+	assert.Equal(t, "test", message.Body.(*HandshakeResponse).Database)
+
+	message = decodeServer(testPacket(2, []byte{headerOK, 0, 0, 2, 0, 0, 0}))
+	assert.Equal(t, KindOK, message.Kind)
+
+	message = decodeClient(testPacket(0, []byte{ComQuery}, []byte("select id, name from t")))
+	assert.Equal(t, KindCommand, message.Kind)
+	assert.Equal(t, "select id, name from t", message.Body.(*Command).Query)
+	assert.Equal(t, 1, message.Seq)
+	record := &fdump.Record{Bodies: []interface{}{message}}
+	assert.Equal(t, []string{"COM_QUERY", "select id, name from t", ""}, Brief(record))
+	key, isRequest := Pair(record)
+	assert.Equal(t, "mysql:1", key)
+	assert.True(t, isRequest)
+
+	var rs []byte
+	rs = append(rs, testPacket(1, []byte{2})...)
+	rs = append(rs, testPacket(2, testColumn("id", TypeLong))...)
+	rs = append(rs, testPacket(3, testColumn("name", TypeVarString))...)
+	rs = append(rs, testPacket(4, []byte{headerEOF, 0, 0, 2, 0})...)
+	rs = append(rs, testPacket(5, testString("1"), testString("foo"))...)
+	rs = append(rs, testPacket(6, testString("2"), []byte{0xfb})...)
+	rs = append(rs, testPacket(7, []byte{headerEOF, 0, 0, 2, 0})...)
+	message = decodeServer(rs)
+	assert.Equal(t, KindResultSet, message.Kind)
+	assert.Equal(t, 1, message.Seq)
+	result := message.Body.(*ResultSet)
+	assert.Len(t, result.Columns, 2)
+	assert.Equal(t, "name", result.Columns[1].Name)
+	assert.Equal(t, 2, result.RowCount)
+	assert.Equal(t, [][]interface{}{{"1", "foo"}, {"2", nil}}, result.Rows)
+	record = &fdump.Record{Bodies: []interface{}{message}}
+	assert.Equal(t, []string{KindResultSet, "", "2 cols, 2 rows"}, Brief(record))
+	assert.Contains(t, Detail(record), "| 2  | NULL |")
+	key, isRequest = Pair(record)
+	assert.Equal(t, "mysql:1", key)
+	assert.False(t, isRequest)
+
+	message = decodeClient(testPacket(0, []byte{ComQuery}, []byte("selec")))
+	assert.Equal(t, 2, message.Seq)
+	message = decodeServer(testPacket(1, []byte{headerErr, 0x28, 0x04}, []byte("#42000"), []byte("syntax error")))
+	assert.Equal(t, KindErr, message.Kind)
+	assert.Equal(t, 2, message.Seq)
+	assert.Equal(t, "ERROR 1064 (42000): syntax error", message.Body.(*Err).String())
+
+	client.Close()
+	server.Close()
+}
+
+func TestDecodePreparedStatement(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+	// the direction is told by the port, the stream is picked up in the
+	// middle
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	client.(fdump.GapHandler).Gap(netFlow, transportFlow, -1)
+	decodeClient := func(buf []byte) *Message {
+		return testDecode(t, client, netFlow, transportFlow, buf)
+	}
+	decodeServer := func(buf []byte) *Message {
+		return testDecode(t, server, netFlow.Reverse(), transportFlow.Reverse(), buf)
+	}
+
+	message := decodeClient(testPacket(0, []byte{ComStmtPrepare}, []byte("select name from t where id = ?")))
+	assert.Equal(t, "COM_STMT_PREPARE", CommandName(message.Command))
+
+	var prepareOK []byte
+	prepareOK = append(prepareOK, testPacket(1, []byte{headerOK, 5, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0})...)
+	prepareOK = append(prepareOK, testPacket(2, testColumn("?", TypeVarString))...)
+	prepareOK = append(prepareOK, testPacket(3, []byte{headerEOF, 0, 0, 2, 0})...)
+	prepareOK = append(prepareOK, testPacket(4, testColumn("name", TypeVarString))...)
+	prepareOK = append(prepareOK, testPacket(5, []byte{headerEOF, 0, 0, 2, 0})...)
+	message = decodeServer(prepareOK)
+	assert.Equal(t, KindStmtPrepareOK, message.Kind)
+	assert.Equal(t, uint32(5), message.Body.(*StmtPrepareOK).StmtID)
+	assert.Len(t, message.Body.(*StmtPrepareOK).Params, 1)
+
+	execute := testPacket(0,
+		[]byte{ComStmtExecute, 5, 0, 0, 0, 0, 1, 0, 0, 0},
+		[]byte{0}, []byte{1}, []byte{TypeLongLong, 0}, []byte{42, 0, 0, 0, 0, 0, 0, 0})
+	message = decodeClient(execute)
+	cmd := message.Body.(*Command)
+	assert.Equal(t, "select name from t where id = ?", cmd.Query)
+	assert.Len(t, cmd.Params, 1)
+	assert.Equal(t, int64(42), cmd.Params[0].Value)
+
+	var rs []byte
+	rs = append(rs, testPacket(1, []byte{1})...)
+	rs = append(rs, testPacket(2, testColumn("name", TypeVarString))...)
+	rs = append(rs, testPacket(3, []byte{headerEOF, 0, 0, 2, 0})...)
+	rs = append(rs, testPacket(4, []byte{0, 0}, testString("foo"))...)
+	rs = append(rs, testPacket(5, []byte{headerEOF, 0, 0, 2, 0})...)
+	message = decodeServer(rs)
+	assert.Equal(t, KindResultSet, message.Kind)
+	assert.True(t, message.Body.(*ResultSet).Binary)
+	assert.Equal(t, [][]interface{}{{"foo"}}, message.Body.(*ResultSet).Rows)
+
+	// the new parameters aren't bound, the types of the last execution are
+	// used
+	execute = testPacket(0,
+		[]byte{ComStmtExecute, 5, 0, 0, 0, 0, 1, 0, 0, 0},
+		[]byte{0}, []byte{0}, []byte{7, 0, 0, 0, 0, 0, 0, 0})
+	message = decodeClient(execute)
+	assert.Equal(t, int64(7), message.Body.(*Command).Params[0].Value)
+
+	message = decodeClient(testPacket(0, []byte{ComStmtClose, 5, 0, 0, 0}))
+	assert.Equal(t, 0, message.Seq)
+
+	client.Close()
+	server.Close()
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	d := NewDecoderFactory()(netFlow.Reverse(), transportFlow.Reverse())
+	_, _, err := d.Decode(netFlow.Reverse(), transportFlow.Reverse(), testPacket(0, []byte{9, 1, 2}))
+	assert.Error(t, err)
+	assert.NotEqual(t, fdump.ErrPkgNoEnough, err)
+}
+
+func TestSniff(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	assert.True(t, Sniff(netFlow, transportFlow, testPacket(0, []byte{ComQuery}, []byte("select 1"))))
+	assert.True(t, Sniff(netFlow, transportFlow, testPacket(0, []byte{handshakeV10}, []byte("5.7.1\x00"))))
+	assert.False(t, Sniff(netFlow, transportFlow, []byte("GET / HTTP/1.1\r\n")))
+}
+
+func TestDecodeOversizedCount(t *testing.T) {
+	netFlow, transportFlow := testFlows(t)
+	newDecoder := NewDecoderFactory()
+	client := newDecoder(netFlow, transportFlow)
+	server := newDecoder(netFlow.Reverse(), transportFlow.Reverse())
+	client.(fdump.GapHandler).Gap(netFlow, transportFlow, -1)
+
+	// picked up before the auth finishes, the AuthSwitchRequest isn't a
+	// result set
+	authSwitch := testPacket(2, []byte{headerEOF}, []byte("mysql_native_password\x00"), make([]byte, 20))
+	message := testDecode(t, server, netFlow.Reverse(), transportFlow.Reverse(), authSwitch)
+	assert.Equal(t, KindResponse, message.Kind)
+
+	// the parameter count is far more than the bytes of the packet
+	execute := testPacket(0,
+		[]byte{ComStmtExecute, 5, 0, 0, 0, cursorParameterCountAvailable, 1, 0, 0, 0},
+		[]byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x0f}, []byte{0, 1})
+	_, _, err := client.Decode(netFlow, transportFlow, execute)
+	assert.Equal(t, ErrMalformed, err)
+	// the query attributes
+	client.(*decoder).state.capabilities |= clientQueryAttributes
+	query := testPacket(0, []byte{ComQuery, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1}, []byte("select 1"))
+	_, _, err = client.Decode(netFlow, transportFlow, query)
+	assert.Equal(t, ErrMalformed, err)
+	client.(*decoder).state.capabilities &^= clientQueryAttributes
+
+	// the column count is more than a result set can have
+	testDecode(t, client, netFlow, transportFlow, testPacket(0, []byte{ComQuery}, []byte("select 1")))
+	_, _, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), testPacket(1, []byte{0xfd, 0xff, 0xff, 0xff}))
+	assert.Equal(t, ErrMalformed, err)
+	// the columns aren't received yet
+	_, _, err = server.Decode(netFlow.Reverse(), transportFlow.Reverse(), testPacket(1, []byte{0xfc, 0xff, 0x00}))
+	assert.Equal(t, fdump.ErrPkgNoEnough, err)
+
+	client.Close()
+	server.Close()
+}
@@ -0,0 +1,416 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/tenfyzhong/fdump"
+)
+
+// maxPayloadLength the payload of this length is continued by the next packet
+const maxPayloadLength = 0xffffff
+
+// readPacket read a packet, the packets of the max length are joined with the
+// packets following them. It returns the payload, the sequence id of the first
+// packet and the length of all the packets.
+func readPacket(buf []byte) (payload []byte, sequenceID uint8, n int, err error) {
+	for {
+		if len(buf) < n+4 {
+			return nil, 0, 0, fdump.ErrPkgNoEnough
+		}
+		length := int(buf[n]) | int(buf[n+1])<<8 | int(buf[n+2])<<16
+		if n == 0 {
+			sequenceID = buf[3]
+		}
+		if len(buf) < n+4+length {
+			return nil, 0, 0, fdump.ErrPkgNoEnough
+		}
+		if n == 0 && length < maxPayloadLength {
+			return buf[4 : 4+length], sequenceID, 4 + length, nil
+		}
+		payload = append(payload, buf[n+4:n+4+length]...)
+		n += 4 + length
+		if length < maxPayloadLength {
+			return payload, sequenceID, n, nil
+		}
+	}
+}
+
+// reader read the fields of a payload. The reading after an error returns the
+// zero values, check the err after all the fields are read.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) len() int {
+	return len(r.buf)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *reader) uint24() uint32 {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// lenenc read a length encoded integer, the null is true if it's the 0xfb.
+func (r *reader) lenenc() (v uint64, null bool) {
+	switch b := r.byte(); b {
+	case 0xfb:
+		return 0, true
+	case 0xfc:
+		return uint64(r.uint16()), false
+	case 0xfd:
+		return uint64(r.uint24()), false
+	case 0xfe:
+		return r.uint64(), false
+	case 0xff:
+		r.err = ErrMalformed
+		return 0, false
+	default:
+		return uint64(b), false
+	}
+}
+
+func (r *reader) lenencString() (s string, null bool) {
+	length, null := r.lenenc()
+	if null || r.err != nil {
+		return "", null
+	}
+	if length > uint64(len(r.buf)) {
+		r.err = ErrMalformed
+		return "", false
+	}
+	return string(r.bytes(int(length))), false
+}
+
+// nulString read a string terminated by NUL, or the rest of the payload if
+// there is no NUL.
+func (r *reader) nulString() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		return string(r.rest())
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *reader) rest() []byte {
+	b := r.buf
+	r.buf = nil
+	return b
+}
+
+// readColumn read a column definition of the protocol 4.1
+func readColumn(payload []byte) (*Column, error) {
+	r := &reader{buf: payload}
+	r.lenencString() // catalog, always "def"
+	column := &Column{}
+	column.Schema, _ = r.lenencString()
+	column.Table, _ = r.lenencString()
+	column.OrgTable, _ = r.lenencString()
+	column.Name, _ = r.lenencString()
+	column.OrgName, _ = r.lenencString()
+	r.lenenc() // the length of the fixed fields, always 0x0c
+	column.Charset = r.uint16()
+	column.Length = r.uint32()
+	column.Type = r.byte()
+	column.Flags = r.uint16()
+	column.Decimals = r.byte()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return column, nil
+}
+
+// readOK read an OK packet, its header may be 0x00 or 0xfe.
+func readOK(payload []byte, capabilities uint32) (*OK, error) {
+	r := &reader{buf: payload[1:]}
+	ok := &OK{}
+	ok.AffectedRows, _ = r.lenenc()
+	ok.LastInsertID, _ = r.lenenc()
+	ok.Status = r.uint16()
+	ok.Warnings = r.uint16()
+	if capabilities&clientSessionTrack != 0 && r.len() > 0 {
+		ok.Info, _ = r.lenencString()
+	} else {
+		ok.Info = string(r.rest())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ok, nil
+}
+
+// readErr read an ERR packet
+func readErr(payload []byte) (*Err, error) {
+	r := &reader{buf: payload[1:]}
+	e := &Err{}
+	e.Code = r.uint16()
+	if r.len() > 0 && r.buf[0] == '#' {
+		r.byte()
+		e.State = string(r.bytes(5))
+	}
+	e.Message = string(r.rest())
+	if r.err != nil {
+		return nil, r.err
+	}
+	return e, nil
+}
+
+// readEOF read an EOF packet of the protocol 4.1
+func readEOF(payload []byte) (*EOF, error) {
+	r := &reader{buf: payload[1:]}
+	eof := &EOF{
+		Warnings: r.uint16(),
+		Status:   r.uint16(),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return eof, nil
+}
+
+// isEOF returns true if the payload is an EOF packet. The OK packet replacing
+// the EOF if the CLIENT_DEPRECATE_EOF is set has at least 7 bytes.
+func isEOF(payload []byte) bool {
+	return len(payload) == 5 && payload[0] == headerEOF
+}
+
+// isTerminator returns true if the payload ends the rows of a result set. A
+// row can't begin with 0xfe unless it's longer than a packet.
+func isTerminator(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	return payload[0] == headerErr || (payload[0] == headerEOF && len(payload) < maxPayloadLength)
+}
+
+// readTextRow read a row of a COM_QUERY result set, the NULL is nil.
+func readTextRow(payload []byte, columns int) ([]interface{}, error) {
+	r := &reader{buf: payload}
+	row := make([]interface{}, 0, columns)
+	for r.len() > 0 && r.err == nil {
+		s, null := r.lenencString()
+		if null {
+			row = append(row, nil)
+		} else {
+			row = append(row, s)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return row, nil
+}
+
+// readBinaryRow read a row of a COM_STMT_EXECUTE result set
+func readBinaryRow(payload []byte, columns []*Column) ([]interface{}, error) {
+	r := &reader{buf: payload}
+	r.byte() // the header 0x00
+	nulls := r.bytes((len(columns) + 7 + 2) / 8)
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		bit := i + 2
+		if r.err != nil || nulls[bit/8]&(1<<uint(bit%8)) != 0 {
+			continue
+		}
+		row[i] = readBinaryValue(r, column.Type, column.Flags&flagUnsigned != 0)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return row, nil
+}
+
+// readParams read the parameters of a COM_STMT_EXECUTE or the query attributes
+// of a COM_QUERY. The types are the types bound by the previous execution, it
+// returns the types of the parameters.
+func readParams(r *reader, count int, types []uint16, withNames bool) ([]*Param, []uint16) {
+	if count < 0 || count > r.len()*8 {
+		// the null bitmap has a bit of every parameter
+		r.err = ErrMalformed
+		return nil, types
+	}
+	nulls := r.bytes((count + 7) / 8)
+	names := make([]string, count)
+	if r.byte() == 1 {
+		types = make([]uint16, count)
+		for i := range types {
+			types[i] = r.uint16()
+			if withNames {
+				names[i], _ = r.lenencString()
+			}
+		}
+	}
+	if r.err != nil || len(types) != count {
+		// the types are bound by an execution not seen
+		return nil, types
+	}
+
+	params := make([]*Param, count)
+	for i := range params {
+		params[i] = &Param{
+			Name: names[i],
+			Type: byte(types[i]),
+		}
+		if nulls[i/8]&(1<<uint(i%8)) == 0 {
+			params[i].Value = readBinaryValue(r, byte(types[i]), types[i]&paramUnsigned != 0)
+		}
+	}
+	if r.err != nil {
+		return nil, types
+	}
+	return params, types
+}
+
+// readBinaryValue read a value of the binary protocol. The integers are int64
+// or uint64, the floats are float64, the others are string.
+func readBinaryValue(r *reader, typ byte, unsigned bool) interface{} {
+	switch typ {
+	case TypeNull:
+		return nil
+	case TypeTiny:
+		v := r.byte()
+		if unsigned {
+			return uint64(v)
+		}
+		return int64(int8(v))
+	case TypeShort, TypeYear:
+		v := r.uint16()
+		if unsigned {
+			return uint64(v)
+		}
+		return int64(int16(v))
+	case TypeLong, TypeInt24:
+		v := r.uint32()
+		if unsigned {
+			return uint64(v)
+		}
+		return int64(int32(v))
+	case TypeLongLong:
+		v := r.uint64()
+		if unsigned {
+			return v
+		}
+		return int64(v)
+	case TypeFloat:
+		return float64(math.Float32frombits(r.uint32()))
+	case TypeDouble:
+		return math.Float64frombits(r.uint64())
+	case TypeDate, TypeDatetime, TypeTimestamp:
+		return readDatetime(r, typ)
+	case TypeTime:
+		return readTime(r)
+	}
+	s, _ := r.lenencString()
+	return s
+}
+
+func readDatetime(r *reader, typ byte) string {
+	length := int(r.byte())
+	data := &reader{buf: r.bytes(length)}
+	var year uint16
+	var month, day, hour, minute, second byte
+	var usec uint32
+	if length >= 4 {
+		year = data.uint16()
+		month = data.byte()
+		day = data.byte()
+	}
+	if length >= 7 {
+		hour = data.byte()
+		minute = data.byte()
+		second = data.byte()
+	}
+	if length >= 11 {
+		usec = data.uint32()
+	}
+
+	s := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if typ == TypeDate {
+		return s
+	}
+	s += fmt.Sprintf(" %02d:%02d:%02d", hour, minute, second)
+	if usec > 0 {
+		s += fmt.Sprintf(".%06d", usec)
+	}
+	return s
+}
+
+func readTime(r *reader) string {
+	length := int(r.byte())
+	data := &reader{buf: r.bytes(length)}
+	var negative, hour, minute, second byte
+	var days, usec uint32
+	if length >= 8 {
+		negative = data.byte()
+		days = data.uint32()
+		hour = data.byte()
+		minute = data.byte()
+		second = data.byte()
+	}
+	if length >= 12 {
+		usec = data.uint32()
+	}
+
+	s := ""
+	if negative == 1 {
+		s = "-"
+	}
+	s += fmt.Sprintf("%02d:%02d:%02d", days*24+uint32(hour), minute, second)
+	if usec > 0 {
+		s += fmt.Sprintf(".%06d", usec)
+	}
+	return s
+}
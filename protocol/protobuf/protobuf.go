@@ -0,0 +1,455 @@
+// Package protobuf decode the protobuf messages of the frames dynamically by
+// the descriptors loaded at startup, no generated Go code is needed. The
+// descriptors are loaded from a FileDescriptorSet, such as the output of
+// `protoc --include_imports --descriptor_set_out`, or compiled from the .proto
+// files. The regions of a frame and their message types are configured by a
+// RegionFunc, the decoded messages are appended to the bodies of the record.
+//
+// Decode the body after a 4 bytes length of every frame:
+//
+//	schema, err := protobuf.LoadDescriptorSet("api.pb")
+//	decodeFunc := schema.DecodeFunc(decode, protobuf.StaticRegions(protobuf.Region{Offset: 4, Type: "api.Request"}))
+//	a := fdump.NewApp(decodeFunc, brief, protobuf.DetailFunc(detail), nil, briefAttributes)
+//
+// or the gRPC messages by the methods of the services:
+//
+//	newDecoder := schema.Decoder(http2.NewDecoderFactory(), schema.GRPCRegions)
+//	a := fdump.NewStreamApp(newDecoder, http2.Brief, protobuf.DetailFunc(http2.Detail), nil, http2.BriefAttributes())
+package protobuf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/http2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Schema the message types and the services loaded
+type Schema struct {
+	files *protoregistry.Files
+}
+
+// NewSchema create a schema of the files
+func NewSchema(files *protoregistry.Files) *Schema {
+	return &Schema{files: files}
+}
+
+// LoadDescriptorSet load a serialized FileDescriptorSet. The imported files
+// must be included.
+func LoadDescriptorSet(filename string) (*Schema, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(buf, set); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", filename, err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return NewSchema(files), nil
+}
+
+// LoadProtoFiles compile the .proto files, the imports are searched in the
+// importPaths. The well-known types, such as google/protobuf/any.proto, are
+// always available.
+func LoadProtoFiles(importPaths []string, filenames ...string) (*Schema, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: importPaths,
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), filenames...)
+	if err != nil {
+		return nil, err
+	}
+
+	files := &protoregistry.Files{}
+	for _, file := range compiled {
+		if err := registerFile(files, file); err != nil {
+			return nil, err
+		}
+	}
+	return NewSchema(files), nil
+}
+
+// registerFile register the file after its imports
+func registerFile(files *protoregistry.Files, file protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(file.Path()); err == nil {
+		return nil
+	}
+	imports := file.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err := registerFile(files, imports.Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(file)
+}
+
+// messageType returns the descriptor of the message type
+func (s *Schema) messageType(name string) (protoreflect.MessageDescriptor, error) {
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message type %s: %v", name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a message type", name)
+	}
+	return md, nil
+}
+
+// MethodTypes returns the request and the response message types of the gRPC
+// method, the path is like /helloworld.Greeter/SayHello.
+func (s *Schema) MethodTypes(path string) (request, response string, err error) {
+	path = strings.TrimPrefix(path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid method path %q", path)
+	}
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(path[:i]))
+	if err != nil {
+		return "", "", fmt.Errorf("service %s: %v", path[:i], err)
+	}
+	service, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return "", "", fmt.Errorf("%s isn't a service", path[:i])
+	}
+	method := service.Methods().ByName(protoreflect.Name(path[i+1:]))
+	if method == nil {
+		return "", "", fmt.Errorf("method %s not found", path)
+	}
+	return string(method.Input().FullName()), string(method.Output().FullName()), nil
+}
+
+// Unmarshal decode the data as a message of the type. The message has the Err
+// if the data can't be decoded.
+func (s *Schema) Unmarshal(typeName string, data []byte) *Message {
+	message := &Message{Type: typeName}
+	md, err := s.messageType(typeName)
+	if err != nil {
+		message.Err = err.Error()
+		return message
+	}
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, m); err != nil {
+		message.Err = err.Error()
+		return message
+	}
+	message.message = m
+	return message
+}
+
+// Region a part of a frame encoded as a message
+type Region struct {
+	Offset int    // the offset from the start of the frame
+	Length int    // the length of the region, 0 is to the end of the frame
+	Type   string // the full name of the message type, such as helloworld.HelloRequest
+
+	// Data is decoded instead of the part of the frame if it's not nil, such
+	// as the payload in the bodies decoded from the frame.
+	Data []byte
+}
+
+// RegionFunc returns the regions of the frame decoded to the bodies
+type RegionFunc func(net, transport gopacket.Flow, frame []byte, bodies []interface{}) []Region
+
+// StaticRegions returns a RegionFunc for the frames of the same layout
+func StaticRegions(regions ...Region) RegionFunc {
+	return func(net, transport gopacket.Flow, frame []byte, bodies []interface{}) []Region {
+		return regions
+	}
+}
+
+// GRPCRegions implements the RegionFunc, it decodes the gRPC messages of the
+// bodies decoded by the http2 package by the types of their methods.
+func (s *Schema) GRPCRegions(net, transport gopacket.Flow, frame []byte, bodies []interface{}) []Region {
+	regions := make([]Region, 0)
+	for _, body := range bodies {
+		message, ok := body.(*http2.Message)
+		if !ok || message.Type != http2.TypeMessage || message.Compressed {
+			continue
+		}
+		request, response, err := s.MethodTypes(message.Path)
+		if err != nil {
+			// the service isn't loaded
+			continue
+		}
+		region := Region{
+			Type: response,
+			Data: message.Data,
+		}
+		if message.IsRequest {
+			region.Type = request
+		}
+		if region.Data == nil {
+			region.Data = []byte{}
+		}
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// decodeRegions decode the regions of the frame
+func (s *Schema) decodeRegions(regions []Region, frame []byte) []interface{} {
+	bodies := make([]interface{}, 0, len(regions))
+	for _, region := range regions {
+		data := region.Data
+		if data == nil {
+			end := len(frame)
+			if region.Length > 0 {
+				end = region.Offset + region.Length
+			}
+			if region.Offset < 0 || region.Offset > end || end > len(frame) {
+				bodies = append(bodies, &Message{
+					Type: region.Type,
+					Err:  fmt.Sprintf("region [%d, %d) out of the frame of %d bytes", region.Offset, end, len(frame)),
+				})
+				continue
+			}
+			data = frame[region.Offset:end]
+		}
+		bodies = append(bodies, s.Unmarshal(region.Type, data))
+	}
+	return bodies
+}
+
+// DecodeFunc returns a fdump.DecodeFunc append the messages of the regions of
+// every frame decoded by the decodeFunc. The whole buffer is a frame if the
+// decodeFunc is nil, such as the payload of an udp packet.
+func (s *Schema) DecodeFunc(decodeFunc fdump.DecodeFunc, regions RegionFunc) fdump.DecodeFunc {
+	return func(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+		if decodeFunc == nil {
+			n = len(buf)
+		} else {
+			bodies, n, err = decodeFunc(net, transport, buf)
+			if err != nil {
+				return
+			}
+		}
+		if n > 0 {
+			frame := buf[:n]
+			bodies = append(bodies, s.decodeRegions(regions(net, transport, frame, bodies), frame)...)
+		}
+		return
+	}
+}
+
+// Decoder returns a fdump.StreamDecoderFactory, its decoders append the
+// messages of the regions of every frame decoded by the decoders created by
+// the newDecoder.
+func (s *Schema) Decoder(newDecoder fdump.StreamDecoderFactory, regions RegionFunc) fdump.StreamDecoderFactory {
+	return func(net, transport gopacket.Flow) fdump.StreamDecoder {
+		return &decoder{
+			StreamDecoder: newDecoder(net, transport),
+			schema:        s,
+			regions:       regions,
+		}
+	}
+}
+
+type decoder struct {
+	fdump.StreamDecoder
+	schema  *Schema
+	regions RegionFunc
+}
+
+func (d *decoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	bodies, n, err = d.StreamDecoder.Decode(net, transport, buf)
+	if err != nil || len(bodies) == 0 {
+		return
+	}
+	bodies = append(bodies, d.schema.decodeRegions(d.regions(net, transport, buf[:n], bodies), buf[:n])...)
+	return
+}
+
+// Gap implements the fdump.GapHandler
+func (d *decoder) Gap(net, transport gopacket.Flow, skip int) {
+	if handler, ok := d.StreamDecoder.(fdump.GapHandler); ok {
+		handler.Gap(net, transport, skip)
+	}
+}
+
+// SetDirection implements the fdump.DirectionSetter
+func (d *decoder) SetDirection(connID uint64, direction fdump.Direction) {
+	if setter, ok := d.StreamDecoder.(fdump.DirectionSetter); ok {
+		setter.SetDirection(connID, direction)
+	}
+}
+
+// Message a dynamic message decoded from a region
+type Message struct {
+	Type    string
+	Err     string // the region can't be decoded as the Type
+	message *dynamicpb.Message
+}
+
+// Proto returns the dynamic message, nil if it can't be decoded
+func (m *Message) Proto() proto.Message {
+	if m.message == nil {
+		return nil
+	}
+	return m.message
+}
+
+// String returns the message in the text format
+func (m *Message) String() string {
+	if m.message == nil {
+		return fmt.Sprintf("%s: %s", m.Type, m.Err)
+	}
+	buf, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m.message)
+	if err != nil {
+		return fmt.Sprintf("%s: %v", m.Type, err)
+	}
+	return fmt.Sprintf("%s {\n%s}", m.Type, indent(string(buf)))
+}
+
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "  " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// MarshalJSON marshal the message by the protobuf JSON mapping, so the fields
+// are written by the headless mode.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Type    string          `json:"type"`
+		Err     string          `json:"error,omitempty"`
+		Message json.RawMessage `json:"message,omitempty"`
+	}{
+		Type: m.Type,
+		Err:  m.Err,
+	}
+	if m.message != nil {
+		buf, err := protojson.Marshal(m.message)
+		if err != nil {
+			return nil, err
+		}
+		v.Message = buf
+	}
+	return json.Marshal(v)
+}
+
+// Fields returns the values of all the fields set, keyed by the path of the
+// fields, such as `user.name`, `tags[0]` and `labels[env]`. The enums are
+// their names, the other values are the Go types of the protoreflect.Value.
+func (m *Message) Fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	if m.message != nil {
+		flatten(fields, "", m.message)
+	}
+	return fields
+}
+
+// Field returns the value of the field by its path
+func (m *Message) Field(path string) (interface{}, bool) {
+	v, ok := m.Fields()[path]
+	return v, ok
+}
+
+func flatten(fields map[string]interface{}, prefix string, m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		path := prefix + string(fd.Name())
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				flattenValue(fields, fmt.Sprintf("%s[%d]", path, i), fd, list.Get(i))
+			}
+		case fd.IsMap():
+			keys := make([]string, 0, v.Map().Len())
+			values := make(map[string]protoreflect.Value)
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				key := k.String()
+				keys = append(keys, key)
+				values[key] = v
+				return true
+			})
+			sort.Strings(keys)
+			for _, key := range keys {
+				flattenValue(fields, fmt.Sprintf("%s[%s]", path, key), fd.MapValue(), values[key])
+			}
+		default:
+			flattenValue(fields, path, fd, v)
+		}
+		return true
+	})
+}
+
+func flattenValue(fields map[string]interface{}, path string, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		flatten(fields, path+".", v.Message())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			fields[path] = string(ev.Name())
+		} else {
+			fields[path] = int32(v.Enum())
+		}
+	default:
+		fields[path] = v.Interface()
+	}
+}
+
+// Messages returns the dynamic messages of the record
+func Messages(record *fdump.Record) []*Message {
+	if record == nil {
+		return nil
+	}
+	messages := make([]*Message, 0)
+	for _, body := range record.Bodies {
+		if message, ok := body.(*Message); ok {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Detail returns the dynamic messages of the record in the text format. It
+// implements the fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	result := ""
+	for _, message := range Messages(record) {
+		result += message.String() + "\n"
+	}
+	return result
+}
+
+// DetailFunc returns a fdump.DetailFunc shows the dynamic messages after the
+// detail of the detailFunc.
+func DetailFunc(detailFunc fdump.DetailFunc) fdump.DetailFunc {
+	return func(record *fdump.Record) string {
+		result := detailFunc(record)
+		messages := Detail(record)
+		if result != "" && messages != "" && !strings.HasSuffix(result, "\n") {
+			result += "\n"
+		}
+		if result != "" && messages != "" {
+			result += "\n"
+		}
+		return result + messages
+	}
+}
@@ -0,0 +1,199 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+	"github.com/tenfyzhong/fdump/protocol/http2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testProto = `syntax = "proto3";
+package test;
+
+message Inner {
+  bool ok = 1;
+}
+
+enum Kind {
+  KIND_UNKNOWN = 0;
+  KIND_USER = 1;
+}
+
+message Request {
+  string name = 1;
+  int32 id = 2;
+  Inner inner = 3;
+  repeated string tags = 4;
+  map<string, string> labels = 5;
+  Kind kind = 6;
+}
+
+service Greeter {
+  rpc Call(Request) returns (Inner);
+}
+`
+
+func testSchema(t *testing.T) *Schema {
+	dir, err := ioutil.TempDir("", "fdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "test.proto"), []byte(testProto), 0644))
+
+	schema, err := LoadProtoFiles([]string{dir}, "test.proto")
+	assert.NoError(t, err)
+	return schema
+}
+
+func testRequest(t *testing.T, schema *Schema) []byte {
+	md, err := schema.messageType("test.Request")
+	assert.NoError(t, err)
+	m := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	m.Set(fields.ByName("name"), protoreflect.ValueOfString("foo"))
+	m.Set(fields.ByName("id"), protoreflect.ValueOfInt32(42))
+	inner := m.Mutable(fields.ByName("inner")).Message()
+	inner.Set(inner.Descriptor().Fields().ByName("ok"), protoreflect.ValueOfBool(true))
+	tags := m.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("a"))
+	tags.Append(protoreflect.ValueOfString("b"))
+	labels := m.Mutable(fields.ByName("labels")).Map()
+	labels.Set(protoreflect.ValueOfString("env").MapKey(), protoreflect.ValueOfString("prod"))
+	m.Set(fields.ByName("kind"), protoreflect.ValueOfEnum(1))
+	buf, err := proto.Marshal(m)
+	assert.NoError(t, err)
+	return buf
+}
+
+func testDecode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	if len(buf) < 4 {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+	length := int(binary.BigEndian.Uint32(buf))
+	if len(buf) < length {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+	bodies = append(bodies, length)
+	n = length
+	return
+}
+
+func TestDecodeFunc(t *testing.T) {
+	schema := testSchema(t)
+	body := testRequest(t, schema)
+	frame := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(body)))
+	frame = append(frame, body...)
+
+	decodeFunc := schema.DecodeFunc(testDecode, StaticRegions(Region{Offset: 4, Type: "test.Request"}))
+	bodies, n, err := decodeFunc(gopacket.Flow{}, gopacket.Flow{}, append(frame, 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, len(frame), n)
+	assert.Len(t, bodies, 2)
+
+	record := &fdump.Record{Bodies: bodies}
+	messages := Messages(record)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "", messages[0].Err)
+	assert.Equal(t, map[string]interface{}{
+		"name":        "foo",
+		"id":          int32(42),
+		"inner.ok":    true,
+		"tags[0]":     "a",
+		"tags[1]":     "b",
+		"labels[env]": "prod",
+		"kind":        "KIND_USER",
+	}, messages[0].Fields())
+	v, ok := messages[0].Field("inner.ok")
+	assert.True(t, ok)
+	assert.Equal(t, true, v)
+
+	detail := DetailFunc(func(record *fdump.Record) string {
+		return "frame"
+	})(record)
+	assert.Contains(t, detail, "frame\n\ntest.Request {\n")
+	// prototext randomizes the spaces
+	assert.Regexp(t, `name:\s+"foo"`, detail)
+
+	buf, err := json.Marshal(messages[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), `"type":"test.Request"`)
+	assert.Contains(t, string(buf), `"name":"foo"`)
+
+	// the region is out of the frame
+	decodeFunc = schema.DecodeFunc(testDecode, StaticRegions(Region{Offset: 4, Length: 1000, Type: "test.Request"}))
+	bodies, _, err = decodeFunc(gopacket.Flow{}, gopacket.Flow{}, frame)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", bodies[1].(*Message).Err)
+
+	// the whole buffer is a frame without the decodeFunc
+	decodeFunc = schema.DecodeFunc(nil, StaticRegions(Region{Type: "test.Unknown"}))
+	bodies, n, err = decodeFunc(gopacket.Flow{}, gopacket.Flow{}, body)
+	assert.NoError(t, err)
+	assert.Equal(t, len(body), n)
+	assert.Contains(t, bodies[0].(*Message).Err, "test.Unknown")
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	schema := testSchema(t)
+	file, err := schema.files.FindFileByPath("test.proto")
+	assert.NoError(t, err)
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(file)},
+	}
+	buf, err := proto.Marshal(set)
+	assert.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "fdump")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf)
+	assert.NoError(t, err)
+	f.Close()
+
+	schema, err = LoadDescriptorSet(f.Name())
+	assert.NoError(t, err)
+	request, response, err := schema.MethodTypes("/test.Greeter/Call")
+	assert.NoError(t, err)
+	assert.Equal(t, "test.Request", request)
+	assert.Equal(t, "test.Inner", response)
+	_, _, err = schema.MethodTypes("/test.Greeter/Unknown")
+	assert.Error(t, err)
+
+	_, err = LoadDescriptorSet(f.Name() + ".unknown")
+	assert.Error(t, err)
+}
+
+func TestGRPCRegions(t *testing.T) {
+	schema := testSchema(t)
+	body := testRequest(t, schema)
+	newDecoder := schema.Decoder(fdump.DecodeFuncFactory(func(net, transport gopacket.Flow, buf []byte) ([]interface{}, int, error) {
+		return []interface{}{&http2.Message{
+			Type:      http2.TypeMessage,
+			Path:      "/test.Greeter/Call",
+			IsRequest: true,
+			Data:      buf,
+		}}, len(buf), nil
+	}), schema.GRPCRegions)
+
+	d := newDecoder(gopacket.Flow{}, gopacket.Flow{})
+	bodies, _, err := d.Decode(gopacket.Flow{}, gopacket.Flow{}, body)
+	assert.NoError(t, err)
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, "test.Request", bodies[1].(*Message).Type)
+	assert.Equal(t, "foo", bodies[1].(*Message).Fields()["name"])
+	d.Close()
+}
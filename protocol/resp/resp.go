@@ -0,0 +1,517 @@
+// Package resp decode the Redis serialization protocol for fdump, both the
+// RESP2 and the RESP3 types are supported except the streamed strings and
+// aggregates. The inline commands, such as `PING\r\n`, are decoded as an array
+// of bulk strings.
+//
+// Use the Decode with fdump.NewApp:
+//
+//	a := fdump.NewApp(resp.Decode, resp.Brief, resp.Detail, nil, resp.BriefAttributes())
+//
+// or the NewDecoder with fdump.NewStreamApp to pair the replies with the
+// commands by their order.
+package resp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/tenfyzhong/fdump"
+)
+
+// the types of the values
+const (
+	TypeSimpleString = '+'
+	TypeError        = '-'
+	TypeInteger      = ':'
+	TypeBulkString   = '$'
+	TypeArray        = '*'
+	TypeNull         = '_'
+	TypeBoolean      = '#'
+	TypeDouble       = ','
+	TypeBigNumber    = '('
+	TypeBlobError    = '!'
+	TypeVerbatim     = '='
+	TypeMap          = '%'
+	TypeSet          = '~'
+	TypeAttribute    = '|'
+	TypePush         = '>'
+)
+
+// maxBulkLength the max length of a bulk string allowed by redis
+const maxBulkLength = 512 * 1024 * 1024
+
+// maxInlineLength the max length of an inline command allowed by redis
+const maxInlineLength = 64 * 1024
+
+var (
+	// ErrInvalidType the type of the value is unknown
+	ErrInvalidType = errors.New("resp invalid type")
+	// ErrInvalidLength the length of a string or an aggregate is invalid
+	ErrInvalidLength = errors.New("resp invalid length")
+	// ErrStreamed the streamed strings and aggregates aren't supported
+	ErrStreamed = errors.New("resp streamed type not supported")
+
+	crlf = []byte("\r\n")
+)
+
+// Value a RESP value
+type Value struct {
+	Type  byte
+	Str   string   `json:",omitempty"` // the strings, the double and the big number
+	Int   int64    `json:",omitempty"`
+	Bool  bool     `json:",omitempty"`
+	Null  bool     `json:",omitempty"` // the null of RESP3 and the null bulk string or array of RESP2
+	Elems []*Value `json:",omitempty"` // the elements of an aggregate, a map has the keys and values in turn
+
+	// Attrs is the attribute sent before the value, the keys and values in
+	// turn.
+	Attrs []*Value `json:",omitempty"`
+}
+
+// Message a decoded command or reply
+type Message struct {
+	Value  *Value
+	Inline bool // an inline command
+
+	// Seq is the order of the command or the reply in its direction, the push
+	// values aren't counted. It's zero if decoded by the Decode.
+	Seq int `json:",omitempty"`
+}
+
+// Decode decode a command or a reply, it implements the fdump.DecodeFunc.
+func Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	if len(buf) == 0 {
+		err = fdump.ErrPkgNoEnough
+		return
+	}
+
+	message := &Message{}
+	if isType(buf[0]) {
+		message.Value, n, err = parse(buf)
+	} else {
+		message.Value, n, err = parseInline(buf)
+		message.Inline = true
+	}
+	if err != nil {
+		return
+	}
+	bodies = append(bodies, message)
+	return
+}
+
+// NewDecoder create a decoder which sets the Seq of the messages, it
+// implements the fdump.StreamDecoderFactory.
+func NewDecoder(net, transport gopacket.Flow) fdump.StreamDecoder {
+	return &decoder{}
+}
+
+type decoder struct {
+	count int
+}
+
+func (d *decoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	bodies, n, err = Decode(net, transport, buf)
+	if err != nil || len(bodies) == 0 {
+		return
+	}
+	message := bodies[0].(*Message)
+	if message.Value.Type != TypePush {
+		d.count++
+		message.Seq = d.count
+	}
+	return
+}
+
+func (d *decoder) Close() {
+}
+
+func isType(b byte) bool {
+	switch b {
+	case TypeSimpleString, TypeError, TypeInteger, TypeBulkString, TypeArray,
+		TypeNull, TypeBoolean, TypeDouble, TypeBigNumber, TypeBlobError,
+		TypeVerbatim, TypeMap, TypeSet, TypeAttribute, TypePush:
+		return true
+	}
+	return false
+}
+
+// line returns the line without the CRLF and the length with the CRLF
+func line(buf []byte) (string, int, error) {
+	i := bytes.Index(buf, crlf)
+	if i < 0 {
+		return "", 0, fdump.ErrPkgNoEnough
+	}
+	return string(buf[:i]), i + 2, nil
+}
+
+// parse parse a value, it returns the fdump.ErrPkgNoEnough if the value isn't
+// complete.
+func parse(buf []byte) (*Value, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, fdump.ErrPkgNoEnough
+	}
+
+	typ := buf[0]
+	if !isType(typ) {
+		return nil, 0, ErrInvalidType
+	}
+	text, n, err := line(buf[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	n++
+	if text == "?" {
+		return nil, 0, ErrStreamed
+	}
+
+	v := &Value{Type: typ}
+	switch typ {
+	case TypeSimpleString, TypeError, TypeBigNumber:
+		v.Str = text
+	case TypeDouble:
+		v.Str = text
+		if _, err := strconv.ParseFloat(normalizeDouble(text), 64); err != nil {
+			return nil, 0, err
+		}
+	case TypeInteger:
+		v.Int, err = strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+	case TypeNull:
+		v.Null = true
+	case TypeBoolean:
+		switch text {
+		case "t":
+			v.Bool = true
+		case "f":
+		default:
+			return nil, 0, ErrInvalidType
+		}
+	case TypeBulkString, TypeBlobError, TypeVerbatim:
+		length, err := strconv.Atoi(text)
+		if err != nil || length < -1 || length > maxBulkLength {
+			return nil, 0, ErrInvalidLength
+		}
+		if length == -1 {
+			v.Null = true
+			break
+		}
+		if len(buf) < n+length+2 {
+			return nil, 0, fdump.ErrPkgNoEnough
+		}
+		if !bytes.Equal(buf[n+length:n+length+2], crlf) {
+			return nil, 0, ErrInvalidLength
+		}
+		v.Str = string(buf[n : n+length])
+		n += length + 2
+	case TypeArray, TypeMap, TypeSet, TypeAttribute, TypePush:
+		count, err := strconv.Atoi(text)
+		if err != nil || count < -1 {
+			return nil, 0, ErrInvalidLength
+		}
+		if count == -1 {
+			v.Null = true
+			break
+		}
+		if typ == TypeMap || typ == TypeAttribute {
+			count *= 2
+		}
+		for i := 0; i < count; i++ {
+			elem, m, err := parse(buf[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			v.Elems = append(v.Elems, elem)
+			n += m
+		}
+	}
+
+	if typ == TypeAttribute {
+		// the attribute is followed by the value it describes
+		value, m, err := parse(buf[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		value.Attrs = v.Elems
+		return value, n + m, nil
+	}
+	return v, n, nil
+}
+
+func normalizeDouble(text string) string {
+	switch text {
+	case "inf":
+		return "+Inf"
+	case "-inf":
+		return "-Inf"
+	}
+	return text
+}
+
+// parseInline parse an inline command as an array of bulk strings
+func parseInline(buf []byte) (*Value, int, error) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 && len(buf) > maxInlineLength {
+		return nil, 0, ErrInvalidLength
+	}
+	if i < 0 {
+		return nil, 0, fdump.ErrPkgNoEnough
+	}
+	text := strings.TrimRight(string(buf[:i]), "\r")
+	v := &Value{Type: TypeArray}
+	for _, arg := range strings.Fields(text) {
+		v.Elems = append(v.Elems, &Value{Type: TypeBulkString, Str: arg})
+	}
+	return v, i + 1, nil
+}
+
+// Command returns the arguments if the value is a command, an array of bulk
+// strings.
+func (v *Value) Command() ([]string, bool) {
+	if v == nil || v.Type != TypeArray || len(v.Elems) == 0 {
+		return nil, false
+	}
+	args := make([]string, 0, len(v.Elems))
+	for _, elem := range v.Elems {
+		if elem.Type != TypeBulkString || elem.Null {
+			return nil, false
+		}
+		args = append(args, elem.Str)
+	}
+	return args, true
+}
+
+// Brief returns a line of the value like the redis-cli
+func (v *Value) Brief() string {
+	if v.Null {
+		return "(nil)"
+	}
+	switch v.Type {
+	case TypeSimpleString, TypeBigNumber:
+		return v.Str
+	case TypeError:
+		return "(error) " + v.Str
+	case TypeBlobError:
+		return "(error) " + strconv.Quote(v.Str)
+	case TypeInteger:
+		return fmt.Sprintf("(integer) %d", v.Int)
+	case TypeDouble:
+		return "(double) " + v.Str
+	case TypeBoolean:
+		return fmt.Sprintf("(boolean) %v", v.Bool)
+	case TypeBulkString, TypeVerbatim:
+		return strconv.Quote(v.Str)
+	case TypeArray:
+		return fmt.Sprintf("(array) %d", len(v.Elems))
+	case TypeSet:
+		return fmt.Sprintf("(set) %d", len(v.Elems))
+	case TypePush:
+		return fmt.Sprintf("(push) %d", len(v.Elems))
+	case TypeMap:
+		return fmt.Sprintf("(map) %d", len(v.Elems)/2)
+	}
+	return ""
+}
+
+// Tree returns the value and its elements like the redis-cli
+func (v *Value) Tree() string {
+	var b strings.Builder
+	v.tree(&b, "")
+	return b.String()
+}
+
+func (v *Value) tree(b *strings.Builder, indent string) {
+	if len(v.Attrs) > 0 {
+		b.WriteString("(attribute)\n")
+		v.elems(b, indent, v.Attrs, true)
+		b.WriteString(indent)
+	}
+
+	if v.Null || len(v.Elems) == 0 || !isAggregate(v.Type) {
+		if isAggregate(v.Type) && !v.Null {
+			b.WriteString("(empty)\n")
+			return
+		}
+		b.WriteString(v.Brief())
+		b.WriteString("\n")
+		return
+	}
+	v.elems(b, indent, v.Elems, v.Type == TypeMap)
+}
+
+func (v *Value) elems(b *strings.Builder, indent string, elems []*Value, isMap bool) {
+	step := 1
+	if isMap {
+		step = 2
+	}
+	width := len(strconv.Itoa(len(elems) / step))
+	for i := 0; i+step-1 < len(elems); i += step {
+		if i > 0 {
+			b.WriteString(indent)
+		}
+		prefix := fmt.Sprintf("%*d) ", width, i/step+1)
+		if isMap {
+			prefix += elems[i].Brief() + " => "
+		}
+		b.WriteString(prefix)
+		elems[i+step-1].tree(b, indent+strings.Repeat(" ", len(prefix)))
+	}
+}
+
+func isAggregate(typ byte) bool {
+	switch typ {
+	case TypeArray, TypeMap, TypeSet, TypePush:
+		return true
+	}
+	return false
+}
+
+// BriefAttributes returns the brief columns of the Brief
+func BriefAttributes() []*fdump.BriefColumnAttribute {
+	return []*fdump.BriefColumnAttribute{
+		&fdump.BriefColumnAttribute{
+			Title:    "Cmd",
+			MaxWidth: 12,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Key",
+			MaxWidth: 32,
+		},
+		&fdump.BriefColumnAttribute{
+			Title:    "Reply",
+			MaxWidth: 40,
+		},
+	}
+}
+
+// Brief returns the command name and the key of a command, or the brief of a
+// reply. It implements the fdump.BriefFunc.
+func Brief(record *fdump.Record) []string {
+	message := recordMessage(record)
+	if message == nil {
+		return nil
+	}
+
+	results := make([]string, 3)
+	if args, ok := isCommand(record, message); ok {
+		results[0] = strings.ToUpper(args[0])
+		if len(args) > 1 {
+			results[1] = args[1]
+		}
+		return results
+	}
+	results[2] = message.Value.Brief()
+	return results
+}
+
+// Detail returns the full tree of the value. It implements the
+// fdump.DetailFunc.
+func Detail(record *fdump.Record) string {
+	message := recordMessage(record)
+	if message == nil {
+		return ""
+	}
+
+	result := ""
+	if message.Seq > 0 {
+		result += fmt.Sprintf("Seq: %d\n\n", message.Seq)
+	}
+	return result + message.Value.Tree()
+}
+
+// Pair pair the replies with the commands by the Seq, it implements the
+// fdump.PairFunc.
+func Pair(record *fdump.Record) (key string, isRequest bool) {
+	message := recordMessage(record)
+	if message == nil || message.Seq == 0 {
+		return
+	}
+	_, isRequest = isCommand(record, message)
+	return fmt.Sprintf("resp:%d", message.Seq), isRequest
+}
+
+// Sniff returns true if the payload begins with a command, it implements the
+// fdump.SniffFunc.
+func Sniff(net, transport gopacket.Flow, payload []byte) bool {
+	if len(payload) < 4 || payload[0] != TypeArray {
+		return false
+	}
+	i := bytes.Index(payload, crlf)
+	return i > 1 && i+2 < len(payload) && payload[i+2] == TypeBulkString
+}
+
+// Protocol returns the protocol to register to a fdump.Registry. It matches
+// the port 6379, or the payload begins with a command.
+func Protocol() *fdump.Protocol {
+	return &fdump.Protocol{
+		Name:            "redis",
+		NewDecoder:      NewDecoder,
+		Brief:           Brief,
+		Detail:          Detail,
+		BriefAttributes: BriefAttributes(),
+		Pair:            Pair,
+		Ports:           serverPorts,
+		Sniff:           Sniff,
+	}
+}
+
+// serverPorts the default ports of the redis server.
+var serverPorts = []fdump.PortRange{
+	fdump.PortRange{Min: 6379, Max: 6379},
+}
+
+// isCommand returns the arguments if the message is a command. The direction
+// of the record is used if it's known, otherwise the server port tells. An
+// array reply looks like a command if neither is known.
+func isCommand(record *fdump.Record, message *Message) ([]string, bool) {
+	direction := record.Direction
+	if direction == fdump.DirectionUnknown {
+		direction = portDirection(record.Transport)
+	}
+	if direction == fdump.DirectionServerToClient {
+		return nil, false
+	}
+	return message.Value.Command()
+}
+
+// portDirection returns the direction by the server ports.
+func portDirection(transport gopacket.Flow) fdump.Direction {
+	src, dst := transport.Src().Raw(), transport.Dst().Raw()
+	if len(src) != 2 || len(dst) != 2 {
+		return fdump.DirectionUnknown
+	}
+	fromServer, toServer := isServerPort(binary.BigEndian.Uint16(src)), isServerPort(binary.BigEndian.Uint16(dst))
+	switch {
+	case fromServer && !toServer:
+		return fdump.DirectionServerToClient
+	case toServer && !fromServer:
+		return fdump.DirectionClientToServer
+	}
+	return fdump.DirectionUnknown
+}
+
+func isServerPort(port uint16) bool {
+	for _, r := range serverPorts {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func recordMessage(record *fdump.Record) *Message {
+	if record == nil || len(record.Bodies) == 0 {
+		return nil
+	}
+	message, _ := record.Bodies[0].(*Message)
+	if message == nil || message.Value == nil {
+		return nil
+	}
+	return message
+}
@@ -0,0 +1,141 @@
+package resp
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/tenfyzhong/fdump"
+)
+
+func testDecode(t *testing.T, buf string) (*Message, int, error) {
+	bodies, n, err := Decode(gopacket.Flow{}, gopacket.Flow{}, []byte(buf))
+	if err != nil {
+		return nil, n, err
+	}
+	return bodies[0].(*Message), n, nil
+}
+
+func TestDecodeCommand(t *testing.T) {
+	cmd := "*3\r\n$3\r\nset\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	for i := 0; i < len(cmd); i++ {
+		_, _, err := testDecode(t, cmd[:i])
+		assert.Equal(t, fdump.ErrPkgNoEnough, err, "length %d", i)
+	}
+
+	message, n, err := testDecode(t, cmd+"*1\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, len(cmd), n)
+	args, ok := message.Value.Command()
+	assert.True(t, ok)
+	assert.Equal(t, []string{"set", "foo", "bar"}, args)
+
+	record := &fdump.Record{Bodies: []interface{}{message}}
+	assert.Equal(t, []string{"SET", "foo", ""}, Brief(record))
+	record.Direction = fdump.DirectionServerToClient
+	assert.Equal(t, []string{"", "", "(array) 3"}, Brief(record))
+
+	// an array reply picked up in the middle, the direction is unknown
+	reply, _, err := testDecode(t, "*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+	assert.NoError(t, err)
+	reply.Seq = 1
+	record = &fdump.Record{
+		Bodies:    []interface{}{reply},
+		Transport: gopacket.NewFlow(layers.EndpointTCPPort, []byte{0x18, 0xeb}, []byte{0xc3, 0xcb}),
+	}
+	assert.Equal(t, []string{"", "", "(array) 2"}, Brief(record))
+	_, isRequest := Pair(record)
+	assert.False(t, isRequest)
+	record.Transport = record.Transport.Reverse()
+	assert.Equal(t, []string{"FOO", "bar", ""}, Brief(record))
+	_, isRequest = Pair(record)
+	assert.True(t, isRequest)
+
+	message, n, err = testDecode(t, "PING\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.True(t, message.Inline)
+	args, _ = message.Value.Command()
+	assert.Equal(t, []string{"PING"}, args)
+}
+
+func TestDecodeRESP2Replies(t *testing.T) {
+	cases := []struct {
+		buf   string
+		brief string
+	}{
+		{"+OK\r\n", "OK"},
+		{"-ERR unknown\r\n", "(error) ERR unknown"},
+		{":42\r\n", "(integer) 42"},
+		{"$5\r\nhello\r\n", `"hello"`},
+		{"$-1\r\n", "(nil)"},
+		{"*-1\r\n", "(nil)"},
+		{"$0\r\n\r\n", `""`},
+	}
+	for _, c := range cases {
+		message, n, err := testDecode(t, c.buf)
+		if assert.NoError(t, err, c.buf) {
+			assert.Equal(t, len(c.buf), n)
+			assert.Equal(t, c.brief, message.Value.Brief())
+		}
+	}
+
+	_, _, err := testDecode(t, "$5\r\nhelloXX")
+	assert.Equal(t, ErrInvalidLength, err)
+	_, _, err = testDecode(t, ":abc\r\n")
+	assert.Error(t, err)
+	_, _, err = testDecode(t, "$?\r\n")
+	assert.Equal(t, ErrStreamed, err)
+}
+
+func TestDecodeRESP3(t *testing.T) {
+	buf := "|1\r\n+ttl\r\n:3600\r\n%2\r\n+first\r\n*2\r\n:1\r\n_\r\n+second\r\n~1\r\n#t\r\n"
+	message, n, err := testDecode(t, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	v := message.Value
+	assert.Equal(t, byte(TypeMap), v.Type)
+	assert.Len(t, v.Attrs, 2)
+	assert.Len(t, v.Elems, 4)
+	assert.Equal(t, "(map) 2", v.Brief())
+	assert.Equal(t, "(attribute)\n"+
+		"1) ttl => (integer) 3600\n"+
+		"1) first => 1) (integer) 1\n"+
+		"            2) (nil)\n"+
+		"2) second => 1) (boolean) true\n", v.Tree())
+
+	for _, c := range []string{",3.14\r\n", ",inf\r\n", "(12345678901234567890\r\n", "!3\r\nbad\r\n", "=7\r\ntxt:abc\r\n", ">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n"} {
+		_, n, err := testDecode(t, c)
+		assert.NoError(t, err, c)
+		assert.Equal(t, len(c), n, c)
+	}
+}
+
+func TestDecoderSeq(t *testing.T) {
+	d := NewDecoder(gopacket.Flow{}, gopacket.Flow{})
+	for i, buf := range []string{"+OK\r\n", ">1\r\n+pushed\r\n", ":1\r\n"} {
+		bodies, _, err := d.Decode(gopacket.Flow{}, gopacket.Flow{}, []byte(buf))
+		assert.NoError(t, err)
+		message := bodies[0].(*Message)
+		record := &fdump.Record{Bodies: bodies, Direction: fdump.DirectionServerToClient}
+		key, isRequest := Pair(record)
+		assert.False(t, isRequest)
+		switch i {
+		case 0:
+			assert.Equal(t, 1, message.Seq)
+			assert.Equal(t, "resp:1", key)
+		case 1:
+			assert.Equal(t, 0, message.Seq)
+			assert.Equal(t, "", key)
+		case 2:
+			assert.Equal(t, 2, message.Seq)
+		}
+	}
+	d.Close()
+}
+
+func TestSniff(t *testing.T) {
+	assert.True(t, Sniff(gopacket.Flow{}, gopacket.Flow{}, []byte("*1\r\n$4\r\nPING\r\n")))
+	assert.False(t, Sniff(gopacket.Flow{}, gopacket.Flow{}, []byte("GET / HTTP/1.1\r\n")))
+}
@@ -13,14 +13,59 @@ type RecordType int
 const (
 	RecordTypeTCP = iota
 	RecordTypeUDP
+	RecordTypeConn // the connection lifecycle, the body is a *ConnEvent
 )
 
+var recordTypeNames = []string{"tcp", "udp", "conn"}
+
+func (t RecordType) String() string {
+	if int(t) < 0 || int(t) >= len(recordTypeNames) {
+		return "unknown"
+	}
+	return recordTypeNames[t]
+}
+
 // Record decoded object
 type Record struct {
 	Type      RecordType
 	Net       gopacket.Flow
 	Transport gopacket.Flow
-	Seen      time.Time
+	Seen      time.Time // the capture timestamp of the last packet of the record
 	Bodies    []interface{}
 	Buffer    []byte
+	Interface string // the interface captured from, empty if unknown
+	Protocol  string // the protocol name if it's dispatched by a Registry
+	// Tunnel is the outer tunnel if the packets are decapsulated, nil
+	// otherwise.
+	Tunnel *Tunnel
+
+	// ConnID identify the connection of the record, the records of the both
+	// directions of a connection have the same ConnID.
+	ConnID    uint64
+	Direction Direction
+
+	// Err is the decode error if the Buffer can't be decoded, the Bodies is
+	// empty in this case.
+	Err error
+	// Skip is the number of bytes lost right before the record, -1 if the
+	// stream is picked up in the middle and the start is unknown.
+	Skip int
+
+	// Length and CaptureLength are the original and the captured lengths of
+	// the last packet of the record, Truncated is set if the packet was cut
+	// by the snaplen.
+	Length        int
+	CaptureLength int
+	Truncated     bool
+	// Seq and SeqEnd are the sequence range [Seq, SeqEnd) of a tcp record in
+	// the stream.
+	Seq    uint32
+	SeqEnd uint32
+
+	// Pair is the response of a request or the request of a response, it's
+	// set when a PairFunc is provided.
+	Pair *Record
+	// Latency is the duration from the request to the response, it's only
+	// set on the response.
+	Latency time.Duration
 }
@@ -0,0 +1,373 @@
+package fdump
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// PortRange a range of the ports, both the Min and the Max are included.
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// Contains returns true if the port is in the range
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// SniffFunc returns true if the first payload of a stream looks like the
+// protocol.
+type SniffFunc func(net, transport gopacket.Flow, payload []byte) bool
+
+// Protocol how to decode and show a protocol. A stream is dispatched to the
+// first registered protocol whose Ports contains the source or destination
+// port, then the first one whose BPF matches the first packet with payload,
+// then the first one whose Sniff returns true.
+type Protocol struct {
+	Name            string // Will show in the protocol column
+	NewDecoder      StreamDecoderFactory
+	Brief           BriefFunc
+	Detail          DetailFunc
+	BriefAttributes []*BriefColumnAttribute
+	Pair            PairFunc // optional
+
+	Ports []PortRange
+	BPF   string // matched on the synthesized packet of the first payload
+	Sniff SniffFunc
+
+	bpf *pcap.BPF
+}
+
+// Registry dispatch the streams to the registered protocols.
+type Registry struct {
+	protocols []*Protocol
+	names     map[string]*Protocol
+	mutex     sync.Mutex
+	conns     map[[2]gopacket.Flow]*Protocol // the both directions of the streams matched
+}
+
+// NewRegistry create an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]*Protocol),
+		conns: make(map[[2]gopacket.Flow]*Protocol),
+	}
+}
+
+// Register add the protocol to the registry. The protocol registered earlier
+// has the higher priority.
+func (r *Registry) Register(p *Protocol) error {
+	if p == nil || p.Name == "" {
+		return errors.New("protocol name is empty")
+	}
+	if p.NewDecoder == nil || p.Brief == nil || p.Detail == nil || len(p.BriefAttributes) == 0 {
+		return fmt.Errorf("protocol %s is incomplete", p.Name)
+	}
+	if _, ok := r.names[p.Name]; ok {
+		return fmt.Errorf("protocol %s is registered", p.Name)
+	}
+
+	if p.BPF != "" {
+		bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, p.BPF)
+		if err != nil {
+			return fmt.Errorf("protocol %s bpf: %v", p.Name, err)
+		}
+		p.bpf = bpf
+	}
+
+	r.protocols = append(r.protocols, p)
+	r.names[p.Name] = p
+	return nil
+}
+
+// Protocols returns the registered protocols in order
+func (r *Registry) Protocols() []*Protocol {
+	return r.protocols
+}
+
+// Protocol returns the protocol registered by the name, nil if not found.
+func (r *Registry) Protocol(name string) *Protocol {
+	return r.names[name]
+}
+
+// NewDecoder implements the StreamDecoderFactory, the protocol of the stream
+// is decided by its first payload.
+func (r *Registry) NewDecoder(net, transport gopacket.Flow) StreamDecoder {
+	return &registryDecoder{
+		registry:  r,
+		net:       net,
+		transport: transport,
+	}
+}
+
+// Brief implements the BriefFunc, it calls the Brief of the record protocol.
+func (r *Registry) Brief(record *Record) []string {
+	p := r.Protocol(record.Protocol)
+	if p == nil {
+		return nil
+	}
+	return p.Brief(record)
+}
+
+// Detail implements the DetailFunc, it calls the Detail of the record
+// protocol.
+func (r *Registry) Detail(record *Record) string {
+	p := r.Protocol(record.Protocol)
+	if p == nil {
+		return ""
+	}
+	return p.Detail(record)
+}
+
+// Pair implements the PairFunc, it calls the Pair of the record protocol.
+func (r *Registry) Pair(record *Record) (key string, isRequest bool) {
+	p := r.Protocol(record.Protocol)
+	if p == nil || p.Pair == nil {
+		return
+	}
+	return p.Pair(record)
+}
+
+func (r *Registry) hasPair() bool {
+	for _, p := range r.protocols {
+		if p.Pair != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// layouts returns the brief columns of every protocol
+func (r *Registry) layouts() map[string][]*BriefColumnAttribute {
+	layouts := make(map[string][]*BriefColumnAttribute)
+	for _, p := range r.protocols {
+		layouts[p.Name] = p.BriefAttributes
+	}
+	return layouts
+}
+
+// briefAttributes returns the columns wide enough for all the protocols. The
+// title is the title of the first protocol has the column.
+func (r *Registry) briefAttributes() []*BriefColumnAttribute {
+	attributes := make([]*BriefColumnAttribute, 0)
+	for _, p := range r.protocols {
+		for i, attribute := range p.BriefAttributes {
+			if i == len(attributes) {
+				attributes = append(attributes, &BriefColumnAttribute{
+					Title:    attribute.Title,
+					MaxWidth: attribute.MaxWidth,
+				})
+				continue
+			}
+			if attribute.MaxWidth > attributes[i].MaxWidth {
+				attributes[i].MaxWidth = attribute.MaxWidth
+			}
+		}
+	}
+	return attributes
+}
+
+// match find the protocol of the stream by its first payload. The both
+// directions of a connection have the same protocol.
+func (r *Registry) match(net, transport gopacket.Flow, payload []byte) *Protocol {
+	key := [2]gopacket.Flow{net, transport}
+	reverseKey := [2]gopacket.Flow{net.Reverse(), transport.Reverse()}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if p, ok := r.conns[key]; ok {
+		return p
+	}
+
+	p := r.matchPorts(transport)
+	if p == nil {
+		p = r.matchBPF(net, transport, payload)
+	}
+	if p == nil {
+		p = r.matchSniff(net, transport, payload)
+	}
+	if p != nil {
+		r.conns[key] = p
+		r.conns[reverseKey] = p
+	}
+	return p
+}
+
+func (r *Registry) matchPorts(transport gopacket.Flow) *Protocol {
+	src, dst, err := transportPorts(transport)
+	if err != nil {
+		return nil
+	}
+	for _, p := range r.protocols {
+		for _, ports := range p.Ports {
+			if ports.Contains(src) || ports.Contains(dst) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Registry) matchBPF(net, transport gopacket.Flow, payload []byte) *Protocol {
+	var data []byte
+	for _, p := range r.protocols {
+		if p.bpf == nil {
+			continue
+		}
+		if data == nil {
+			var err error
+			data, err = synthesizePacket(net, transport, payload)
+			if err != nil {
+				log.Debugf("synthesize packet failed, err: %v", err)
+				return nil
+			}
+		}
+		ci := gopacket.CaptureInfo{
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if p.bpf.Matches(ci, data) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (r *Registry) matchSniff(net, transport gopacket.Flow, payload []byte) *Protocol {
+	for _, p := range r.protocols {
+		if p.Sniff != nil && p.Sniff(net, transport, payload) {
+			return p
+		}
+	}
+	return nil
+}
+
+// forget the protocol of the connection
+func (r *Registry) forget(net, transport gopacket.Flow) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, [2]gopacket.Flow{net, transport})
+	delete(r.conns, [2]gopacket.Flow{net.Reverse(), transport.Reverse()})
+}
+
+// synthesizePacket build an ethernet packet of the payload to match the BPF
+func synthesizePacket(net, transport gopacket.Flow, payload []byte) ([]byte, error) {
+	src, dst, err := transportPorts(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{
+		Net:       net,
+		Transport: transport,
+	}
+	var layer checksumLayer
+	if transport.EndpointType() == layers.EndpointUDPPort {
+		record.Type = RecordTypeUDP
+		layer = &layers.UDP{
+			SrcPort: layers.UDPPort(src),
+			DstPort: layers.UDPPort(dst),
+		}
+	} else {
+		record.Type = RecordTypeTCP
+		layer = &layers.TCP{
+			SrcPort: layers.TCPPort(src),
+			DstPort: layers.TCPPort(dst),
+			ACK:     true,
+			PSH:     true,
+			Window:  65535,
+		}
+	}
+	return serializePacket(record, layer, payload)
+}
+
+// protocolNamer is implemented by the decoder dispatching to the protocols
+type protocolNamer interface {
+	protocolName() string
+}
+
+// decoderProtocol returns the protocol name of the decoder, empty if it's
+// not dispatched by a registry.
+func decoderProtocol(decoder StreamDecoder) string {
+	if namer, ok := decoder.(protocolNamer); ok {
+		return namer.protocolName()
+	}
+	return ""
+}
+
+// registryDecoder create the decoder of the protocol matched by the first
+// payload, and forward all the calls to it.
+type registryDecoder struct {
+	registry  *Registry
+	net       gopacket.Flow
+	transport gopacket.Flow
+	protocol  *Protocol
+	decoder   StreamDecoder
+	connID    uint64
+	direction Direction
+}
+
+func (d *registryDecoder) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	if d.decoder == nil {
+		if len(buf) == 0 {
+			err = ErrPkgNoEnough
+			return
+		}
+		d.protocol = d.registry.match(net, transport, buf)
+		if d.protocol == nil {
+			// not any protocol, ignore the payload
+			log.Debugf("no protocol matched, net: %v, transport: %v", net, transport)
+			n = len(buf)
+			return
+		}
+		d.decoder = d.protocol.NewDecoder(net, transport)
+		if setter, ok := d.decoder.(DirectionSetter); ok {
+			setter.SetDirection(d.connID, d.direction)
+		}
+	}
+	return d.decoder.Decode(net, transport, buf)
+}
+
+func (d *registryDecoder) Close() {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+	d.registry.forget(d.net, d.transport)
+}
+
+// Gap implements the GapHandler
+func (d *registryDecoder) Gap(net, transport gopacket.Flow, skip int) {
+	if handler, ok := d.decoder.(GapHandler); ok {
+		handler.Gap(net, transport, skip)
+	}
+}
+
+// Finish implements the Finisher
+func (d *registryDecoder) Finish(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	if finisher, ok := d.decoder.(Finisher); ok {
+		return finisher.Finish(net, transport, buf)
+	}
+	return nil, 0, ErrPkgNoEnough
+}
+
+// SetDirection implements the DirectionSetter
+func (d *registryDecoder) SetDirection(connID uint64, direction Direction) {
+	d.connID = connID
+	d.direction = direction
+	if setter, ok := d.decoder.(DirectionSetter); ok {
+		setter.SetDirection(connID, direction)
+	}
+}
+
+func (d *registryDecoder) protocolName() string {
+	if d.protocol == nil {
+		return ""
+	}
+	return d.protocol.Name
+}
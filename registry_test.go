@@ -0,0 +1,113 @@
+package fdump
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func testProtocol(name string) *Protocol {
+	return &Protocol{
+		Name:       name,
+		NewDecoder: DecodeFuncFactory(testDecodeFunc),
+		Brief: func(record *Record) []string {
+			return []string{name, record.Bodies[0].(string)}
+		},
+		Detail: func(record *Record) string {
+			return name
+		},
+		BriefAttributes: []*BriefColumnAttribute{
+			&BriefColumnAttribute{Title: name, MaxWidth: len(name)},
+			&BriefColumnAttribute{Title: "body", MaxWidth: 10},
+		},
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	assert.Error(t, r.Register(&Protocol{}))
+	assert.Error(t, r.Register(&Protocol{Name: "foo"}))
+	assert.NoError(t, r.Register(testProtocol("foo")))
+	assert.Error(t, r.Register(testProtocol("foo")))
+	bar := testProtocol("bar")
+	bar.BriefAttributes = bar.BriefAttributes[:1]
+	assert.NoError(t, r.Register(bar))
+
+	assert.Len(t, r.Protocols(), 2)
+	assert.Equal(t, bar, r.Protocol("bar"))
+	assert.Nil(t, r.Protocol("baz"))
+
+	attributes := r.briefAttributes()
+	if assert.Len(t, attributes, 2) {
+		assert.Equal(t, "foo", attributes[0].Title)
+		assert.Equal(t, 3, attributes[0].MaxWidth)
+		assert.Equal(t, 10, attributes[1].MaxWidth)
+	}
+}
+
+func TestRegistryMatch(t *testing.T) {
+	r := NewRegistry()
+	byPort := testProtocol("port")
+	byPort.Ports = []PortRange{PortRange{Min: 6379, Max: 6380}}
+	bySniff := testProtocol("sniff")
+	bySniff.Sniff = func(net, transport gopacket.Flow, payload []byte) bool {
+		return bytes.HasPrefix(payload, []byte("sniff"))
+	}
+	assert.NoError(t, r.Register(byPort))
+	assert.NoError(t, r.Register(bySniff))
+
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	assert.Nil(t, r.match(netFlow, transportFlow, []byte("0123456789")))
+	assert.Equal(t, bySniff, r.match(netFlow, transportFlow, []byte("sniff01234")))
+	// the response has the same protocol
+	assert.Equal(t, bySniff, r.match(netFlow.Reverse(), transportFlow.Reverse(), []byte("0123456789")))
+	r.forget(netFlow, transportFlow)
+	assert.Nil(t, r.match(netFlow.Reverse(), transportFlow.Reverse(), []byte("0123456789")))
+
+	portFlow, err := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(50123), layers.NewTCPPortEndpoint(6380))
+	assert.NoError(t, err)
+	assert.Equal(t, byPort, r.match(netFlow, portFlow, []byte("sniff01234")))
+}
+
+func TestControllerRegistry(t *testing.T) {
+	r := NewRegistry()
+	foo := testProtocol("foo")
+	foo.Sniff = func(net, transport gopacket.Flow, payload []byte) bool {
+		return payload[0] == 'f'
+	}
+	bar := testProtocol("bar")
+	bar.Sniff = func(net, transport gopacket.Flow, payload []byte) bool {
+		return payload[0] == 'b'
+	}
+	assert.NoError(t, r.Register(foo))
+	assert.NoError(t, r.Register(bar))
+
+	c := newController("", "", 65535, "", r.NewDecoder)
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("f123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 500, SYN: true, ACK: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true}, []byte("abcdefghij")),
+		testUDPPacket(t, ts, []byte("b123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	protocols := make([]string, 0)
+	briefs := make([][]string, 0)
+	for _, record := range records {
+		protocols = append(protocols, record.Protocol)
+		briefs = append(briefs, r.Brief(record))
+	}
+	assert.Equal(t, []string{"foo", "foo", "bar"}, protocols)
+	assert.Equal(t, [][]string{
+		[]string{"foo", "f123456789"},
+		[]string{"foo", "abcdefghij"},
+		[]string{"bar", "b123456789"},
+	}, briefs)
+	assert.Equal(t, "bar", r.Detail(records[2]))
+}
@@ -0,0 +1,43 @@
+package fdump
+
+import (
+	"bytes"
+
+	"github.com/google/gopacket"
+)
+
+// ResyncFunc will be called when the decoder of a tcp stream returns an error
+// other than ErrPkgNoEnough. Return the count of bytes to skip to reach the
+// next frame boundary. The skipped bytes will be shown as an undecodable
+// record. It skips at least 1 byte and at most all the buffered bytes.
+type ResyncFunc func(net, transport gopacket.Flow, buf []byte, err error) (skip int)
+
+// ResyncDrop drop all the buffered bytes of the stream, it's the default
+// ResyncFunc.
+func ResyncDrop(net, transport gopacket.Flow, buf []byte, err error) int {
+	return len(buf)
+}
+
+// ResyncSkip returns a ResyncFunc skip n bytes.
+func ResyncSkip(n int) ResyncFunc {
+	return func(net, transport gopacket.Flow, buf []byte, err error) int {
+		return n
+	}
+}
+
+// ResyncMarker returns a ResyncFunc skip to the next marker, such as the
+// magic number of the frame header. It drops all the buffered bytes if there
+// is no marker.
+func ResyncMarker(marker []byte) ResyncFunc {
+	return func(net, transport gopacket.Flow, buf []byte, err error) int {
+		if len(buf) <= 1 {
+			return len(buf)
+		}
+		// the current frame begin at 0, search after it
+		i := bytes.Index(buf[1:], marker)
+		if i < 0 {
+			return len(buf)
+		}
+		return i + 1
+	}
+}
@@ -0,0 +1,87 @@
+package fdump
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestBadFrame = errors.New("bad frame")
+
+// testMarkerDecodeFunc decode the frames begin with '#' and have 4 bytes
+func testMarkerDecodeFunc(net, transport gopacket.Flow, data []byte) (bodies []interface{}, n int, err error) {
+	if len(data) < 4 {
+		err = ErrPkgNoEnough
+		return
+	}
+	if data[0] != '#' {
+		err = errTestBadFrame
+		return
+	}
+	bodies = append(bodies, string(data[1:4]))
+	n = 4
+	return
+}
+
+func TestResyncFuncs(t *testing.T) {
+	var f gopacket.Flow
+	buf := []byte("xx#abc#def")
+	assert.Equal(t, len(buf), ResyncDrop(f, f, buf, errTestBadFrame))
+	assert.Equal(t, 3, ResyncSkip(3)(f, f, buf, errTestBadFrame))
+	assert.Equal(t, 2, ResyncMarker([]byte("#"))(f, f, buf, errTestBadFrame))
+	assert.Equal(t, 4, ResyncMarker([]byte("#"))(f, f, buf[2:], errTestBadFrame))
+	assert.Equal(t, len(buf), ResyncMarker([]byte("!"))(f, f, buf, errTestBadFrame))
+	assert.Equal(t, 1, ResyncMarker([]byte("#"))(f, f, []byte("x"), errTestBadFrame))
+}
+
+func testResyncStream(t *testing.T, resync ResyncFunc, data []byte) (chan *Record, *stream) {
+	msgChan := make(chan *Record, 100)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testMarkerDecodeFunc))
+	if resync != nil {
+		f.resync = resync
+	}
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	s := f.New(netFlow, transportFlow).(*stream)
+	s.Reassembled([]tcpassembly.Reassembly{
+		tcpassembly.Reassembly{
+			Bytes: data,
+			Seen:  time.Now(),
+		},
+	})
+	close(msgChan)
+	return msgChan, s
+}
+
+func TestReassembledResyncDrop(t *testing.T) {
+	msgChan, s := testResyncStream(t, nil, []byte("#abcxx#def#g"))
+
+	r := <-msgChan
+	assert.Equal(t, "abc", r.Bodies[0])
+	r = <-msgChan
+	assert.Equal(t, errTestBadFrame, r.Err)
+	assert.Empty(t, r.Bodies)
+	assert.Equal(t, []byte("xx#def#g"), r.Buffer)
+	_, ok := <-msgChan
+	assert.False(t, ok)
+	assert.Empty(t, s.buf)
+}
+
+func TestReassembledResyncMarker(t *testing.T) {
+	msgChan, s := testResyncStream(t, ResyncMarker([]byte("#")), []byte("#abcxx#def#g"))
+
+	r := <-msgChan
+	assert.Equal(t, "abc", r.Bodies[0])
+	r = <-msgChan
+	assert.Equal(t, errTestBadFrame, r.Err)
+	assert.Equal(t, []byte("xx"), r.Buffer)
+	r = <-msgChan
+	assert.Equal(t, "def", r.Bodies[0])
+	_, ok := <-msgChan
+	assert.False(t, ok)
+	assert.Equal(t, []byte("#g"), s.buf)
+}
@@ -14,6 +14,63 @@ type serialization struct {
 	TransportSrcType, TransportDstType gopacket.EndpointType
 	Seen                               time.Time
 	Buffer                             []byte
+	Interface                          string
+	Protocol                           string
+	Err                                string
+	Skip                               int
+	ConnID                             uint64
+	Direction                          Direction
+	Event                              *ConnEvent // the body of the lifecycle record
+	Tunnel                             *tunnelSerialization
+	Length, CaptureLength              int
+	Truncated                          bool
+	Seq, SeqEnd                        uint32
+}
+
+type tunnelSerialization struct {
+	Type                               string
+	NetSrcRaw, NetDstRaw               []byte
+	NetSrcType, NetDstType             gopacket.EndpointType
+	TransportSrcRaw, TransportDstRaw   []byte
+	TransportSrcType, TransportDstType gopacket.EndpointType
+	ID                                 uint32
+}
+
+func tunnel2Serialization(tunnel *Tunnel) *tunnelSerialization {
+	if tunnel == nil {
+		return nil
+	}
+	return &tunnelSerialization{
+		Type:             tunnel.Type,
+		NetSrcRaw:        tunnel.Net.Src().Raw(),
+		NetDstRaw:        tunnel.Net.Dst().Raw(),
+		NetSrcType:       tunnel.Net.EndpointType(),
+		NetDstType:       tunnel.Net.EndpointType(),
+		TransportSrcRaw:  tunnel.Transport.Src().Raw(),
+		TransportDstRaw:  tunnel.Transport.Dst().Raw(),
+		TransportSrcType: tunnel.Transport.EndpointType(),
+		TransportDstType: tunnel.Transport.EndpointType(),
+		ID:               tunnel.ID,
+	}
+}
+
+func (s *tunnelSerialization) Tunnel() (*Tunnel, error) {
+	if s == nil {
+		return nil, nil
+	}
+	net, err := gopacket.FlowFromEndpoints(
+		gopacket.NewEndpoint(s.NetSrcType, s.NetSrcRaw),
+		gopacket.NewEndpoint(s.NetDstType, s.NetDstRaw))
+	if err != nil {
+		return nil, err
+	}
+	transport, err := gopacket.FlowFromEndpoints(
+		gopacket.NewEndpoint(s.TransportSrcType, s.TransportSrcRaw),
+		gopacket.NewEndpoint(s.TransportDstType, s.TransportDstRaw))
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{Type: s.Type, Net: net, Transport: transport, ID: s.ID}, nil
 }
 
 func (s serialization) Net() (gopacket.Flow, error) {
@@ -31,6 +88,14 @@ func (s serialization) Transport() (gopacket.Flow, error) {
 }
 
 func message2Serialization(record *Record) *serialization {
+	errStr := ""
+	if record.Err != nil {
+		errStr = record.Err.Error()
+	}
+	var event *ConnEvent
+	if record.Type == RecordTypeConn && len(record.Bodies) > 0 {
+		event, _ = record.Bodies[0].(*ConnEvent)
+	}
 	netSrc := record.Net.Src()
 	netDst := record.Net.Dst()
 	transportSrc := record.Transport.Src()
@@ -47,5 +112,18 @@ func message2Serialization(record *Record) *serialization {
 		TransportDstType: transportDst.EndpointType(),
 		Seen:             record.Seen,
 		Buffer:           record.Buffer,
+		Interface:        record.Interface,
+		Protocol:         record.Protocol,
+		Err:              errStr,
+		Skip:             record.Skip,
+		ConnID:           record.ConnID,
+		Direction:        record.Direction,
+		Event:            event,
+		Tunnel:           tunnel2Serialization(record.Tunnel),
+		Length:           record.Length,
+		CaptureLength:    record.CaptureLength,
+		Truncated:        record.Truncated,
+		Seq:              record.Seq,
+		SeqEnd:           record.SeqEnd,
 	}
 }
@@ -26,6 +26,12 @@ func TestMessage2Sericalization(t *testing.T) {
 		Transport: transport,
 		Seen:      seen,
 		Buffer:    []byte{1, 2, 3},
+
+		Length:        80,
+		CaptureLength: 60,
+		Truncated:     true,
+		Seq:           100,
+		SeqEnd:        103,
 	}
 
 	s := message2Serialization(record)
@@ -41,6 +47,11 @@ func TestMessage2Sericalization(t *testing.T) {
 	assert.Equal(t, transportDst.EndpointType(), s.TransportDstType)
 	assert.Equal(t, seen, s.Seen)
 	assert.Equal(t, []byte{1, 2, 3}, s.Buffer)
+	assert.Equal(t, 80, s.Length)
+	assert.Equal(t, 60, s.CaptureLength)
+	assert.True(t, s.Truncated)
+	assert.Equal(t, uint32(100), s.Seq)
+	assert.Equal(t, uint32(103), s.SeqEnd)
 
 	actualNet, err := s.Net()
 	assert.NoError(t, err)
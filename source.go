@@ -0,0 +1,219 @@
+package fdump
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// PacketSource provides the packets to decode. Implement it to capture
+// packets from other than a pcap handle, or use NewPacketSource to wrap a
+// gopacket.PacketDataSource such as a pcapgo.Reader or an afpacket.TPacket.
+type PacketSource interface {
+	// Packets returns the channel to read packets from. The channel should be
+	// closed when there is no more packet.
+	Packets() chan gopacket.Packet
+	// Close release the resource of the source.
+	Close()
+}
+
+type packetSource struct {
+	source    *gopacket.PacketSource
+	closeFunc func()
+}
+
+// NewPacketSource create a PacketSource read packets from the data source
+// and decode them by the decoder, usually the link type of the data source.
+func NewPacketSource(source gopacket.PacketDataSource, decoder gopacket.Decoder) PacketSource {
+	return &packetSource{
+		source: gopacket.NewPacketSource(source, decoder),
+	}
+}
+
+func newPcapSource(handle *pcap.Handle) *packetSource {
+	return &packetSource{
+		source:    gopacket.NewPacketSource(handle, handle.LinkType()),
+		closeFunc: handle.Close,
+	}
+}
+
+func (s *packetSource) Packets() chan gopacket.Packet {
+	return s.source.Packets()
+}
+
+func (s *packetSource) Close() {
+	if s.closeFunc != nil {
+		s.closeFunc()
+	}
+}
+
+type memorySource struct {
+	packets chan gopacket.Packet
+}
+
+// NewMemoryPacketSource create a PacketSource provides the packets in order.
+// It is useful to test a decoder without a real interface.
+func NewMemoryPacketSource(packets []gopacket.Packet) PacketSource {
+	c := make(chan gopacket.Packet, len(packets))
+	for _, packet := range packets {
+		c <- packet
+	}
+	close(c)
+	return &memorySource{
+		packets: c,
+	}
+}
+
+func (s *memorySource) Packets() chan gopacket.Packet {
+	return s.packets
+}
+
+func (s *memorySource) Close() {
+}
+
+// captureInterface tag a packet with the interface captured from, it's put in
+// the AncillaryData of the packet metadata.
+type captureInterface string
+
+func tagInterface(packet gopacket.Packet, name string) {
+	md := packet.Metadata()
+	md.AncillaryData = append(md.AncillaryData, captureInterface(name))
+}
+
+// packetInterface returns the interface the packet captured from, empty if
+// unknown.
+func packetInterface(packet gopacket.Packet) string {
+	for _, data := range packet.Metadata().AncillaryData {
+		if name, ok := data.(captureInterface); ok {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// packetTruncated returns whether the packet was cut by the snaplen.
+func packetTruncated(packet gopacket.Packet) bool {
+	metadata := packet.Metadata()
+	return metadata.Truncated || metadata.CaptureLength < metadata.Length
+}
+
+// mergeWindow is the longest time to hold a packet waiting for the earlier
+// packets from other sources.
+const mergeWindow = 100 * time.Millisecond
+
+type mergeItem struct {
+	packet  gopacket.Packet
+	source  int
+	arrival time.Time
+}
+
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	return h[i].packet.Metadata().Timestamp.Before(h[j].packet.Metadata().Timestamp)
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// mergeSource merge the packets of several sources into one timeline ordered
+// by the timestamp, and tag every packet with the name of its source.
+type mergeSource struct {
+	sources []PacketSource
+	names   []string
+	packets chan gopacket.Packet
+	once    sync.Once
+}
+
+func newMergeSource(sources []PacketSource, names []string) *mergeSource {
+	return &mergeSource{
+		sources: sources,
+		names:   names,
+		packets: make(chan gopacket.Packet, 1000),
+	}
+}
+
+func (s *mergeSource) Packets() chan gopacket.Packet {
+	s.once.Do(func() {
+		go s.merge()
+	})
+	return s.packets
+}
+
+func (s *mergeSource) Close() {
+	for _, source := range s.sources {
+		source.Close()
+	}
+}
+
+func (s *mergeSource) merge() {
+	defer close(s.packets)
+
+	in := make(chan *mergeItem, len(s.sources))
+	for i, source := range s.sources {
+		go func(i int, packets chan gopacket.Packet) {
+			for packet := range packets {
+				tagInterface(packet, s.names[i])
+				in <- &mergeItem{packet: packet, source: i, arrival: time.Now()}
+			}
+			// a nil packet means the source is exhausted
+			in <- &mergeItem{source: i}
+		}(i, source.Packets())
+	}
+
+	h := &mergeHeap{}
+	pending := make([]int, len(s.sources))
+	exhausted := make([]bool, len(s.sources))
+	open := len(s.sources)
+	ticker := time.NewTicker(mergeWindow / 2)
+	defer ticker.Stop()
+
+	// pop the earliest packet if every open source has a pending packet, or
+	// it has been held for the merge window.
+	emit := func(now time.Time) {
+		for h.Len() > 0 {
+			item := (*h)[0]
+			ready := true
+			for i, n := range pending {
+				if n == 0 && i != item.source && !exhausted[i] {
+					ready = false
+					break
+				}
+			}
+			if !ready && now.Sub(item.arrival) < mergeWindow {
+				return
+			}
+			heap.Pop(h)
+			pending[item.source]--
+			s.packets <- item.packet
+		}
+	}
+
+	for open > 0 {
+		select {
+		case item := <-in:
+			if item.packet == nil {
+				open--
+				exhausted[item.source] = true
+			} else {
+				heap.Push(h, item)
+				pending[item.source]++
+			}
+			emit(time.Now())
+		case now := <-ticker.C:
+			emit(now)
+		}
+	}
+	for h.Len() > 0 {
+		s.packets <- heap.Pop(h).(*mergeItem).packet
+	}
+}
@@ -0,0 +1,55 @@
+package fdump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPacketSource(t *testing.T) {
+	packets := []gopacket.Packet{
+		testUDPPacket(t, time.Now(), []byte("0")),
+		testUDPPacket(t, time.Now(), []byte("1")),
+	}
+	s := NewMemoryPacketSource(packets)
+	result := make([]gopacket.Packet, 0)
+	for packet := range s.Packets() {
+		result = append(result, packet)
+	}
+	s.Close()
+	assert.Equal(t, packets, result)
+}
+
+func TestMergeSource(t *testing.T) {
+	ts := time.Now()
+	lo := []gopacket.Packet{
+		testUDPPacket(t, ts, []byte("0")),
+		testUDPPacket(t, ts.Add(2*time.Millisecond), []byte("2")),
+		testUDPPacket(t, ts.Add(3*time.Millisecond), []byte("3")),
+	}
+	eth0 := []gopacket.Packet{
+		testUDPPacket(t, ts.Add(1*time.Millisecond), []byte("1")),
+		testUDPPacket(t, ts.Add(4*time.Millisecond), []byte("4")),
+	}
+
+	s := newMergeSource(
+		[]PacketSource{NewMemoryPacketSource(lo), NewMemoryPacketSource(eth0)},
+		[]string{"lo", "eth0"})
+	payloads := ""
+	ifaces := make([]string, 0)
+	for packet := range s.Packets() {
+		payloads += string(packet.ApplicationLayer().Payload())
+		ifaces = append(ifaces, packetInterface(packet))
+	}
+	s.Close()
+
+	assert.Equal(t, "01234", payloads)
+	assert.Equal(t, []string{"lo", "eth0", "lo", "lo", "eth0"}, ifaces)
+}
+
+func TestPacketInterfaceUnknown(t *testing.T) {
+	packet := testUDPPacket(t, time.Now(), []byte("0"))
+	assert.Equal(t, "", packetInterface(packet))
+}
@@ -0,0 +1,218 @@
+package fdump
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// maxStreamErrors the most streams to count the decode errors of, the
+// errors of the other streams are counted in the total only.
+const maxStreamErrors = 4096
+
+// statsTopStreams the count of the streams with the most decode errors in
+// the stats.
+const statsTopStreams = 10
+
+// Stats the capture statistics, they tell whether fdump falls behind the
+// traffic.
+type Stats struct {
+	// Received, Dropped and IfDropped are the counters of the live pcap
+	// handles. Dropped is the count of the packets dropped by the kernel
+	// because they weren't read in time, IfDropped is the count dropped by
+	// the interfaces.
+	Received  uint64
+	Dropped   uint64
+	IfDropped uint64
+	// Packets the count of the packets read from the source.
+	Packets uint64
+	// Pages the pages buffered by the tcp assembler waiting for the out of
+	// order packets, -1 if it's unknown.
+	Pages int
+	// Pending the records waiting to be shown or written, Capacity is the
+	// capacity of the queue. The capture blocks if the queue is full.
+	Pending  int
+	Capacity int
+	// Records the count of the records, RecordsPerSecond is the rate in the
+	// last second.
+	Records          uint64
+	RecordsPerSecond float64
+	// Errors the count of the records can't be decoded, including the gaps
+	// of the tcp streams and the truncated udp datagrams.
+	Errors uint64
+	// StreamErrors the streams with the most decode errors, most first.
+	StreamErrors []StreamErrors
+	Defrag       DefragStats
+}
+
+// StreamErrors the count of the decode errors of a stream.
+type StreamErrors struct {
+	Net       gopacket.Flow
+	Transport gopacket.Flow
+	Errors    uint64
+}
+
+// Summary returns the stats in a line.
+func (s Stats) Summary() string {
+	summary := fmt.Sprintf("pkts %d", s.Packets)
+	if s.Dropped > 0 || s.IfDropped > 0 {
+		summary += fmt.Sprintf(" drop %d", s.Dropped+s.IfDropped)
+	}
+	summary += fmt.Sprintf(" recs %d %.0f/s err %d queue %d/%d", s.Records, s.RecordsPerSecond, s.Errors, s.Pending, s.Capacity)
+	if s.Pages > 0 {
+		summary += fmt.Sprintf(" pages %d", s.Pages)
+	}
+	return summary
+}
+
+// String returns the stats in lines.
+func (s Stats) String() string {
+	pages := "unknown"
+	if s.Pages >= 0 {
+		pages = fmt.Sprintf("%d", s.Pages)
+	}
+	str := fmt.Sprintf("Capture\n"+
+		"  received by pcap:   %d\n"+
+		"  dropped by kernel:  %d\n"+
+		"  dropped by iface:   %d\n"+
+		"  packets read:       %d\n"+
+		"\nReassembly\n"+
+		"  buffered pages:     %s\n"+
+		"  fragments:          %d\n"+
+		"  reassembled:        %d\n"+
+		"  incomplete:         %d\n"+
+		"  timed out:          %d\n"+
+		"  invalid fragments:  %d\n"+
+		"\nRecords\n"+
+		"  records:            %d\n"+
+		"  records per second: %.1f\n"+
+		"  decode errors:      %d\n"+
+		"  queue:              %d/%d\n",
+		s.Received, s.Dropped, s.IfDropped, s.Packets,
+		pages, s.Defrag.Fragments, s.Defrag.Reassembled, s.Defrag.Incomplete, s.Defrag.TimedOut, s.Defrag.Invalid,
+		s.Records, s.RecordsPerSecond, s.Errors, s.Pending, s.Capacity)
+	if len(s.StreamErrors) > 0 {
+		str += "\nDecode errors by stream\n"
+		for _, e := range s.StreamErrors {
+			str += fmt.Sprintf("  %v %v: %d\n", e.Net, e.Transport, e.Errors)
+		}
+	}
+	return str
+}
+
+// statsCollector collect the stats from the controller. The packets are
+// counted by the capture goroutine, the records by the consumer goroutine.
+type statsCollector struct {
+	packets uint64 // atomic
+
+	mutex        sync.Mutex
+	stats        Stats
+	streamErrors map[[2]gopacket.Flow]uint64
+	// the records count and the time of the last sample, to compute the
+	// records per second
+	lastRecords uint64
+	lastSample  time.Time
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		streamErrors: make(map[[2]gopacket.Flow]uint64),
+		stats:        Stats{Pages: -1},
+	}
+}
+
+func (s *statsCollector) packet() {
+	atomic.AddUint64(&s.packets, 1)
+}
+
+func (s *statsCollector) record(record *Record) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Records++
+	if record.Err == nil {
+		return
+	}
+	s.stats.Errors++
+	key := [2]gopacket.Flow{record.Net, record.Transport}
+	if _, ok := s.streamErrors[key]; ok || len(s.streamErrors) < maxStreamErrors {
+		s.streamErrors[key]++
+	}
+}
+
+// sample update the stats read from the capture, it's called every second
+// by the capture goroutine.
+func (s *statsCollector) sample(now time.Time, handles []*pcap.Handle, pages int) {
+	var received, dropped, ifDropped uint64
+	for _, handle := range handles {
+		stats, err := handle.Stats()
+		if err != nil {
+			log.Debugf("get pcap stats failed, err: %v", err)
+			continue
+		}
+		received += uint64(stats.PacketsReceived)
+		dropped += uint64(stats.PacketsDropped)
+		ifDropped += uint64(stats.PacketsIfDropped)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Received = received
+	s.stats.Dropped = dropped
+	s.stats.IfDropped = ifDropped
+	s.stats.Pages = pages
+	if !s.lastSample.IsZero() {
+		if elapsed := now.Sub(s.lastSample).Seconds(); elapsed > 0 {
+			s.stats.RecordsPerSecond = float64(s.stats.Records-s.lastRecords) / elapsed
+		}
+	}
+	s.lastRecords = s.stats.Records
+	s.lastSample = now
+}
+
+// snapshot returns a copy of the stats.
+func (s *statsCollector) snapshot() Stats {
+	s.mutex.Lock()
+	stats := s.stats
+	streamErrors := make([]StreamErrors, 0, len(s.streamErrors))
+	for key, errors := range s.streamErrors {
+		streamErrors = append(streamErrors, StreamErrors{Net: key[0], Transport: key[1], Errors: errors})
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(streamErrors, func(i, j int) bool {
+		if streamErrors[i].Errors != streamErrors[j].Errors {
+			return streamErrors[i].Errors > streamErrors[j].Errors
+		}
+		return streamErrors[i].Net.String()+streamErrors[i].Transport.String() <
+			streamErrors[j].Net.String()+streamErrors[j].Transport.String()
+	})
+	if len(streamErrors) > statsTopStreams {
+		streamErrors = streamErrors[:statsTopStreams]
+	}
+	stats.StreamErrors = streamErrors
+	stats.Packets = atomic.LoadUint64(&s.packets)
+	return stats
+}
+
+// assemblerPages returns the pages used by the assembler, -1 if it isn't
+// found. The reflection is intentional: tcpassembly doesn't export the page
+// count and the pages freed by the flushes can't be told from outside.
+// TestAssemblerPages fails if the field of gopacket disappears.
+func assemblerPages(assembler *tcpassembly.Assembler) int {
+	pc := reflect.ValueOf(assembler).Elem().FieldByName("pc")
+	if pc.Kind() != reflect.Ptr || pc.IsNil() {
+		return -1
+	}
+	used := pc.Elem().FieldByName("used")
+	if used.Kind() != reflect.Int {
+		return -1
+	}
+	return int(used.Int())
+}
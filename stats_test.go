@@ -0,0 +1,93 @@
+package fdump
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsCollector(t *testing.T) {
+	s := newStatsCollector()
+	net := gopacket.NewFlow(layers.EndpointIPv4, []byte{127, 0, 0, 1}, []byte{10, 2, 2, 2})
+	transport1 := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0, 1}, []byte{0, 2})
+	transport2 := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0, 1}, []byte{0, 3})
+
+	now := time.Now()
+	s.sample(now, nil, 0)
+	s.packet()
+	s.packet()
+	s.record(&Record{Net: net, Transport: transport1})
+	s.record(&Record{Net: net, Transport: transport1, Err: errors.New("bad")})
+	s.record(&Record{Net: net, Transport: transport2, Err: errors.New("bad")})
+	s.record(&Record{Net: net, Transport: transport2, Err: ErrStreamGap})
+	s.sample(now.Add(2*time.Second), nil, 3)
+
+	stats := s.snapshot()
+	assert.Equal(t, uint64(2), stats.Packets)
+	assert.Equal(t, uint64(4), stats.Records)
+	assert.Equal(t, uint64(3), stats.Errors)
+	assert.Equal(t, 2.0, stats.RecordsPerSecond)
+	assert.Equal(t, 3, stats.Pages)
+	assert.Equal(t, []StreamErrors{
+		{Net: net, Transport: transport2, Errors: 2},
+		{Net: net, Transport: transport1, Errors: 1},
+	}, stats.StreamErrors)
+
+	assert.Equal(t, "pkts 2 recs 4 2/s err 3 queue 0/0 pages 3", stats.Summary())
+	stats.Dropped = 5
+	assert.Contains(t, stats.Summary(), "drop 5")
+	assert.Contains(t, stats.String(), "dropped by kernel:  5\n")
+	assert.Contains(t, stats.String(), "127.0.0.1->10.2.2.2 1->3: 2\n")
+}
+
+// testDiscardStream a tcpassembly.Stream discards the data.
+type testDiscardStream struct{}
+
+func (testDiscardStream) Reassembled([]tcpassembly.Reassembly) {}
+func (testDiscardStream) ReassemblyComplete()                  {}
+
+type testDiscardFactory struct{}
+
+func (testDiscardFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
+	return testDiscardStream{}
+}
+
+func TestAssemblerPages(t *testing.T) {
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(testDiscardFactory{}))
+	assert.Equal(t, 0, assemblerPages(assembler), "the page count of tcpassembly isn't found")
+
+	net := gopacket.NewFlow(layers.EndpointIPv4, []byte{127, 0, 0, 1}, []byte{10, 2, 2, 2})
+	ts := time.Now()
+	assembler.AssembleWithTimestamp(net, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, ts)
+	// the segment after a gap is buffered
+	tcp := &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 201, ACK: true}
+	tcp.Payload = []byte("0123456789")
+	assembler.AssembleWithTimestamp(net, tcp, ts)
+	assert.Equal(t, 1, assemblerPages(assembler))
+
+	assembler.FlushAll()
+	assert.Equal(t, 0, assemblerPages(assembler))
+}
+
+func TestControllerStats(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packets := []gopacket.Packet{
+		testUDPPacket(t, time.Now(), []byte("0123456789")),
+		testUDPPacket(t, time.Now(), []byte("012")),
+	}
+
+	runTestController(t, c, packets)
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Packets)
+	assert.Equal(t, uint64(2), stats.Records)
+	assert.Equal(t, uint64(1), stats.Errors)
+	assert.Len(t, stats.StreamErrors, 1)
+	assert.Equal(t, 0, stats.Pages)
+	assert.Equal(t, 0, stats.Pending)
+	assert.Equal(t, 1000, stats.Capacity)
+}
@@ -13,25 +13,106 @@ var (
 	// ErrPkgNoEnough packet no enough error. return this error in decode
 	// function if the packet no enough.
 	ErrPkgNoEnough = errors.New("pkg no enough")
+
+	// ErrStreamGap the bytes buffered before a gap of the stream, they can't
+	// be decoded because the rest of the message is lost.
+	ErrStreamGap = errors.New("stream gap")
+
+	// ErrUDPTruncated the decoder returns ErrPkgNoEnough for the rest bytes
+	// of an udp datagram, a message never spans the datagrams.
+	ErrUDPTruncated = errors.New("udp datagram truncated")
+
+	// ErrUDPLeftover the rest bytes of an udp datagram aren't consumed by
+	// the decoder.
+	ErrUDPLeftover = errors.New("udp leftover bytes")
 )
 
 // DecodeFunc Decode the packet when receive a packet.
 // Return the decoded bodies and used bytes. It will ignore the bodies if it's
-// empty.
+// empty. An udp datagram is decoded repeatedly until all the bytes are used,
+// the bodies without the used bytes take the rest of the datagram.
 type DecodeFunc func(gopacket.Flow, gopacket.Flow, []byte) (bodies []interface{}, n int, err error)
 
+// Decode implements the StreamDecoder, a DecodeFunc is a stateless
+// StreamDecoder.
+func (f DecodeFunc) Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error) {
+	return f(net, transport, buf)
+}
+
+// Close implements the StreamDecoder.
+func (f DecodeFunc) Close() {
+}
+
+// StreamDecoder decode the packets of a tcp stream or an udp flow. A
+// StreamDecoder is created for every stream, so it can keep the state across
+// the messages, such as a compression negotiated by a handshake.
+type StreamDecoder interface {
+	// Decode is the same as the DecodeFunc.
+	Decode(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error)
+	// Close will be called when the stream is finished.
+	Close()
+}
+
+// GapHandler can be implemented by a StreamDecoder to be told about the lost
+// bytes of a tcp stream, so it can reset the state of the stream. The skip is
+// the number of the lost bytes, or -1 if the stream is picked up in the middle
+// without a SYN. The buffered bytes are dropped before the Gap is called.
+type GapHandler interface {
+	Gap(net, transport gopacket.Flow, skip int)
+}
+
+// Finisher can be implemented by a StreamDecoder to decode the bytes left
+// when the tcp stream ends, such as a message ended by the close of the
+// connection. The Finish returns like the Decode, the bytes can't be decoded
+// are dropped.
+type Finisher interface {
+	Finish(net, transport gopacket.Flow, buf []byte) (bodies []interface{}, n int, err error)
+}
+
+// StreamDecoderFactory create a StreamDecoder for a new tcp stream or udp flow.
+type StreamDecoderFactory func(net, transport gopacket.Flow) StreamDecoder
+
+// DecodeFuncFactory adapt the DecodeFunc to a StreamDecoderFactory, all the
+// streams share the stateless function.
+func DecodeFuncFactory(decodeFunc DecodeFunc) StreamDecoderFactory {
+	return func(net, transport gopacket.Flow) StreamDecoder {
+		return decodeFunc
+	}
+}
+
+// packetMeta the capture metadata of the packet being assembled
+type packetMeta struct {
+	iface     string
+	tunnel    *Tunnel
+	conn      *conn
+	direction Direction
+	net       gopacket.Flow
+	transport gopacket.Flow
+	seq       uint32 // the sequence number of the first byte of the payload
+	capture   gopacket.CaptureInfo
+	truncated bool
+	pickup    bool // the first packet of a stream picked up in the middle
+}
+
 type streamFactory struct {
 	msgChan    chan *Record
+	current    packetMeta
 	mutex      sync.Mutex
 	cmds       map[uint16]bool
 	serverPort int
-	decodeFunc DecodeFunc
+	newDecoder StreamDecoderFactory
+	resync     ResyncFunc
+	lifecycle  bool // send a record when the stream is complete
+	// streams the streams held by the assembler, keyed by the flows
+	streams map[[2]gopacket.Flow]bool
 }
 
-func newStreamFactory(msgChan chan *Record, decodeFunc DecodeFunc) *streamFactory {
+func newStreamFactory(msgChan chan *Record, newDecoder StreamDecoderFactory) *streamFactory {
 	f := &streamFactory{
 		msgChan:    msgChan,
-		decodeFunc: decodeFunc,
+		newDecoder: newDecoder,
+		resync:     ResyncDrop,
+		streams:    make(map[[2]gopacket.Flow]bool),
 	}
 	return f
 }
@@ -43,6 +124,20 @@ func (factory *streamFactory) New(net, transport gopacket.Flow) tcpassembly.Stre
 		transport: transport,
 		buf:       make([]byte, 0),
 		factory:   factory,
+		decoder:   factory.newDecoder(net, transport),
+		iface:     factory.current.iface,
+		tunnel:    factory.current.tunnel,
+		conn:      factory.current.conn,
+		direction: factory.current.direction,
+		seq:       factory.current.seq,
+		pickup:    factory.current.pickup,
+	}
+	if s.conn != nil {
+		s.connID = s.conn.id
+	}
+	factory.streams[[2]gopacket.Flow{net, transport}] = true
+	if setter, ok := s.decoder.(DirectionSetter); ok {
+		setter.SetDirection(s.connID, s.direction)
 	}
 	return s
 }
@@ -53,6 +148,17 @@ type stream struct {
 	buf       []byte
 	seen      time.Time
 	factory   *streamFactory
+	decoder   StreamDecoder
+	iface     string  // the interface of the packet being decoded
+	tunnel    *Tunnel // the tunnel of the first packet
+	conn      *conn
+	connID    uint64
+	direction Direction
+	skip      int    // the skip to mark on the next record
+	seq       uint32 // the sequence number of buf[0]
+	capture   gopacket.CaptureInfo
+	truncated bool
+	pickup    bool // picked up in the middle, the start is unknown
 }
 
 func (s stream) Net() gopacket.Flow {
@@ -67,38 +173,167 @@ func (s stream) Transport() gopacket.Flow {
 // Reassembly objects contain stream data IN ORDER.
 func (s *stream) Reassembled(reassemblies []tcpassembly.Reassembly) {
 	log.Debugf("ressembled len: %d", len(reassemblies))
+	s.updateCapture()
 	for _, r := range reassemblies {
+		if s.pickup {
+			r.Skip = -1
+			s.pickup = false
+		}
+		if r.Skip != 0 {
+			s.gap(r.Skip, r.Seen)
+		}
+		s.seen = r.Seen
+		if s.conn != nil {
+			s.conn.bytes += len(r.Bytes)
+		}
 		s.buf = append(s.buf, r.Bytes...)
-		for {
-			bodies, n, err := s.factory.decodeFunc(s.net, s.transport, s.buf)
-			if err != nil {
-				log.Debugf("unpack err: %+v", err)
-				break
-			}
-
-			usedBuf := s.buf[:n]
-			s.buf = s.buf[n:]
-
-			if len(bodies) == 0 {
-				log.Debugf("body is empty")
-				continue
-			}
-
-			record := &Record{
-				Type:      RecordTypeTCP,
-				Bodies:    bodies,
-				Net:       s.net,
-				Transport: s.transport,
-				Seen:      r.Seen,
-				Buffer:    usedBuf,
-			}
-			s.factory.msgChan <- record
+		s.decode(s.decoder.Decode, r.Seen)
+	}
+}
+
+// decode decode the messages in the buffer by the decode until more bytes
+// are needed.
+func (s *stream) decode(decode DecodeFunc, seen time.Time) {
+	for len(s.buf) > 0 {
+		bodies, n, err := decode(s.net, s.transport, s.buf)
+		if err == ErrPkgNoEnough {
+			break
+		}
+		if err != nil {
+			log.Debugf("unpack err: %+v", err)
+			s.resync(err, seen)
+			continue
 		}
+		if n == 0 && len(bodies) == 0 {
+			// nothing decoded, wait for more bytes
+			break
+		}
+
+		usedBuf := s.buf[:n]
+		s.buf = s.buf[n:]
+
+		if len(bodies) == 0 {
+			log.Debugf("body is empty")
+			continue
+		}
+
+		record := &Record{
+			Type:      RecordTypeTCP,
+			Bodies:    bodies,
+			Net:       s.net,
+			Transport: s.transport,
+			Seen:      seen,
+			Buffer:    usedBuf,
+			Interface: s.iface,
+		}
+		s.send(record)
+	}
+}
+
+// updateCapture keep the capture info of the packet being assembled if it
+// belongs to the stream. The packets buffered by the assembler may be
+// delivered while assembling a packet of another stream, the capture info of
+// the previous packet is kept in this case.
+func (s *stream) updateCapture() {
+	current := s.factory.current
+	if current.net != s.net || current.transport != s.transport {
+		return
+	}
+	s.capture = current.capture
+	s.truncated = current.truncated
+	// the same stream may be captured on several interfaces
+	s.iface = current.iface
+}
+
+// resync skip the bytes can't be decoded and send them as an undecodable
+// record.
+func (s *stream) resync(err error, seen time.Time) {
+	skip := s.factory.resync(s.net, s.transport, s.buf, err)
+	if skip < 1 {
+		skip = 1
+	}
+	if skip > len(s.buf) {
+		skip = len(s.buf)
+	}
+
+	record := &Record{
+		Type:      RecordTypeTCP,
+		Net:       s.net,
+		Transport: s.transport,
+		Seen:      seen,
+		Buffer:    s.buf[:skip],
+		Interface: s.iface,
+		Err:       err,
 	}
+	s.buf = s.buf[skip:]
+	s.send(record)
+}
+
+// gap drop the bytes buffered before the gap and tell the decoder. The next
+// record will be marked with the skip.
+func (s *stream) gap(skip int, seen time.Time) {
+	log.Debugf("stream gap, net: %v, transport: %v, skip: %d", s.net, s.transport, skip)
+	if len(s.buf) > 0 {
+		record := &Record{
+			Type:      RecordTypeTCP,
+			Net:       s.net,
+			Transport: s.transport,
+			Seen:      seen,
+			Buffer:    s.buf,
+			Interface: s.iface,
+			Err:       ErrStreamGap,
+		}
+		s.buf = make([]byte, 0)
+		s.send(record)
+	}
+	if skip > 0 {
+		s.seq += uint32(skip)
+	}
+
+	if handler, ok := s.decoder.(GapHandler); ok {
+		handler.Gap(s.net, s.transport, skip)
+	}
+	s.skip = skip
+}
+
+func (s *stream) send(record *Record) {
+	record.ConnID = s.connID
+	record.Tunnel = s.tunnel
+	record.Protocol = decoderProtocol(s.decoder)
+	record.Direction = s.direction
+	// the record is always the beginning of the buffer
+	record.Seq = s.seq
+	record.SeqEnd = s.seq + uint32(len(record.Buffer))
+	s.seq = record.SeqEnd
+	record.Length = s.capture.Length
+	record.CaptureLength = s.capture.CaptureLength
+	record.Truncated = s.truncated
+	if s.conn != nil && record.Err == nil {
+		s.conn.messages++
+	}
+	if record.Err != ErrStreamGap {
+		record.Skip = s.skip
+		s.skip = 0
+	}
+	s.factory.msgChan <- record
 }
 
 // ReassemblyComplete is called when the TCP assembler believes a stream has
 // finished.
 func (s *stream) ReassemblyComplete() {
 	log.Infof("reassembly complete, net: %+v, transport: %+v", s.net, s.transport)
+	delete(s.factory.streams, [2]gopacket.Flow{s.net, s.transport})
+	if finisher, ok := s.decoder.(Finisher); ok {
+		s.decode(finisher.Finish, s.seen)
+	}
+	s.decoder.Close()
+	if s.factory.lifecycle {
+		seen := s.seen
+		if seen.IsZero() {
+			seen = time.Now()
+		}
+		record := connRecord(s.conn, ConnEventComplete, s.net, s.transport, s.direction, seen)
+		record.Tunnel = s.tunnel
+		s.factory.msgChan <- record
+	}
 }
@@ -1,6 +1,7 @@
 package fdump
 
 import (
+	"fmt"
 	"net"
 	"reflect"
 	"testing"
@@ -30,7 +31,7 @@ func testDecodeFunc(net, transport gopacket.Flow, data []byte) (bodies []interfa
 
 func TestNewStream(t *testing.T) {
 	msgChan := make(chan *Record, 1)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -54,7 +55,7 @@ func TestNewStream(t *testing.T) {
 
 func TestReassembledEmpty(t *testing.T) {
 	msgChan := make(chan *Record, 100)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -87,7 +88,7 @@ func TestReassembledEmpty(t *testing.T) {
 
 func TestReassembled1Object(t *testing.T) {
 	msgChan := make(chan *Record, 100)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -119,7 +120,9 @@ func TestReassembled1Object(t *testing.T) {
 		str, ok := r.Bodies[0].(string)
 		assert.True(t, ok)
 		assert.Equal(t, reassembly.Bytes, []byte(str))
-		assert.True(t, r.Seen.UnixNano() > reassembly.Seen.UnixNano())
+		assert.Equal(t, reassembly.Seen, r.Seen)
+		assert.Equal(t, uint32(0), r.Seq)
+		assert.Equal(t, uint32(10), r.SeqEnd)
 	default:
 		assert.FailNow(t, "consume chan failed")
 	}
@@ -129,7 +132,7 @@ func TestReassembled1Object(t *testing.T) {
 
 func TestReassembledIgnore(t *testing.T) {
 	msgChan := make(chan *Record, 100)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -162,7 +165,7 @@ func TestReassembledIgnore(t *testing.T) {
 
 func TestReassembledLess10(t *testing.T) {
 	msgChan := make(chan *Record, 100)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -195,7 +198,7 @@ func TestReassembledLess10(t *testing.T) {
 
 func TestReassembled2Packet(t *testing.T) {
 	msgChan := make(chan *Record, 100)
-	f := newStreamFactory(msgChan, testDecodeFunc)
+	f := newStreamFactory(msgChan, DecodeFuncFactory(testDecodeFunc))
 
 	netSrc := layers.NewIPEndpoint(net.ParseIP("127.0.0.1"))
 	netDst := layers.NewIPEndpoint(net.ParseIP("10.2.2.2"))
@@ -231,10 +234,139 @@ func TestReassembled2Packet(t *testing.T) {
 		str, ok := r.Bodies[0].(string)
 		assert.True(t, ok)
 		assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []byte(str))
-		assert.True(t, r.Seen.UnixNano() > reassembly1.Seen.UnixNano())
+		assert.Equal(t, reassembly1.Seen, r.Seen)
 	default:
 		assert.FailNow(t, "error")
 	}
 
 	assert.Equal(t, []byte{11}, s.buf)
 }
+
+// testCountDecoder decode every 10 bytes and prefix the count of the
+// messages decoded by the decoder.
+type testCountDecoder struct {
+	count  int
+	closed *int
+}
+
+func (d *testCountDecoder) Decode(net, transport gopacket.Flow, data []byte) (bodies []interface{}, n int, err error) {
+	bodies, n, err = testDecodeFunc(net, transport, data)
+	if len(bodies) > 0 {
+		d.count++
+		bodies[0] = fmt.Sprintf("%d:%s", d.count, bodies[0])
+	}
+	return
+}
+
+func (d *testCountDecoder) Close() {
+	*d.closed++
+}
+
+func TestStreamDecoderPerStream(t *testing.T) {
+	closed := 0
+	c := newController("", "", 65535, "", func(net, transport gopacket.Flow) StreamDecoder {
+		return &testCountDecoder{closed: &closed}
+	})
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true}, []byte("01234567890123456789")),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 500, SYN: true, ACK: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 20001, DstPort: 50123, Seq: 501, ACK: true}, []byte("abcdefghij")),
+		testUDPPacket(t, ts, []byte("0123456789")),
+		testUDPPacket(t, ts, []byte("0123456789")),
+	}
+
+	records := runTestController(t, c, packets)
+	bodies := make([]interface{}, 0)
+	for _, r := range records {
+		bodies = append(bodies, r.Bodies...)
+	}
+	assert.Equal(t, []interface{}{"1:0123456789", "2:0123456789", "1:abcdefghij", "1:0123456789", "2:0123456789"}, bodies)
+	// 2 tcp streams and 1 udp flow
+	assert.Equal(t, 3, closed)
+}
+
+type testGapDecoder struct {
+	testCountDecoder
+	skips []int
+}
+
+func (d *testGapDecoder) Gap(net, transport gopacket.Flow, skip int) {
+	d.skips = append(d.skips, skip)
+	d.count = 0
+}
+
+func TestReassembledGap(t *testing.T) {
+	msgChan := make(chan *Record, 100)
+	closed := 0
+	decoder := &testGapDecoder{testCountDecoder: testCountDecoder{closed: &closed}}
+	f := newStreamFactory(msgChan, func(net, transport gopacket.Flow) StreamDecoder {
+		return decoder
+	})
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	s := f.New(netFlow, transportFlow).(*stream)
+
+	now := time.Now()
+	s.Reassembled([]tcpassembly.Reassembly{
+		tcpassembly.Reassembly{Bytes: []byte("6789abcdefghij012"), Skip: -1, Seen: now},
+		tcpassembly.Reassembly{Bytes: []byte("0123456789"), Skip: 3, Seen: now},
+		tcpassembly.Reassembly{Bytes: []byte("0123456789"), Seen: now},
+	})
+	close(msgChan)
+
+	records := make([]*Record, 0)
+	for r := range msgChan {
+		records = append(records, r)
+	}
+	if !assert.Len(t, records, 4) {
+		return
+	}
+	assert.Equal(t, []interface{}{"1:6789abcdef"}, records[0].Bodies)
+	assert.Equal(t, -1, records[0].Skip)
+	assert.Equal(t, ErrStreamGap, records[1].Err)
+	assert.Equal(t, []byte("ghij012"), records[1].Buffer)
+	assert.Equal(t, 0, records[1].Skip)
+	assert.Equal(t, []interface{}{"1:0123456789"}, records[2].Bodies)
+	assert.Equal(t, 3, records[2].Skip)
+	assert.Equal(t, []interface{}{"2:0123456789"}, records[3].Bodies)
+	assert.Equal(t, 0, records[3].Skip)
+	assert.Equal(t, []int{-1, 3}, decoder.skips)
+}
+
+// testFinishDecoder decode the rest bytes as a message when the stream ends
+type testFinishDecoder struct {
+	testCountDecoder
+}
+
+func (d *testFinishDecoder) Finish(net, transport gopacket.Flow, data []byte) (bodies []interface{}, n int, err error) {
+	return []interface{}{"end:" + string(data)}, len(data), nil
+}
+
+func TestReassemblyCompleteFinish(t *testing.T) {
+	msgChan := make(chan *Record, 100)
+	closed := 0
+	f := newStreamFactory(msgChan, func(net, transport gopacket.Flow) StreamDecoder {
+		return &testFinishDecoder{testCountDecoder{closed: &closed}}
+	})
+	netFlow, transportFlow := testFlows(t, "127.0.0.1", "10.2.2.2", layers.EndpointTCPPort)
+	s := f.New(netFlow, transportFlow).(*stream)
+
+	s.Reassembled([]tcpassembly.Reassembly{
+		tcpassembly.Reassembly{Bytes: []byte("0123456789abc"), Seen: time.Now()},
+	})
+	s.ReassemblyComplete()
+	close(msgChan)
+
+	records := make([]*Record, 0)
+	for r := range msgChan {
+		records = append(records, r)
+	}
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, []interface{}{"1:0123456789"}, records[0].Bodies)
+	assert.Equal(t, []interface{}{"end:abc"}, records[1].Bodies)
+	assert.Equal(t, []byte("abc"), records[1].Buffer)
+	assert.Equal(t, 1, closed)
+}
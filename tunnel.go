@@ -0,0 +1,209 @@
+package fdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// the tunnel types can be decapsulated
+const (
+	TunnelVXLAN = "vxlan"
+	TunnelGRE   = "gre"
+	TunnelIPIP  = "ipip" // ipv4 or ipv6 in ipv4 or ipv6
+)
+
+// maxTunnelDepth the most nested tunnels to decapsulate.
+const maxTunnelDepth = 4
+
+// vxlanPort the iana port of vxlan, gopacket decodes it itself.
+const vxlanPort = 4789
+
+// vxlanHeaderLen the length of the vxlan header.
+const vxlanHeaderLen = 8
+
+// tunnelConfig the tunnels to decapsulate.
+type tunnelConfig struct {
+	types map[string]bool
+	// vxlanPorts the udp ports of vxlan besides 4789, like 8472 of the linux
+	// kernel.
+	vxlanPorts map[uint16]bool
+}
+
+// Tunnel the outer tunnel of a decapsulated packet, the record is decoded
+// from the inner packet.
+type Tunnel struct {
+	Type string
+	// Net the endpoints of the outer ip header.
+	Net gopacket.Flow
+	// Transport the endpoints of the outer udp header, it's empty if the
+	// tunnel isn't over udp.
+	Transport gopacket.Flow
+	// ID the VNI of vxlan or the key of gre, 0 if it isn't present.
+	ID uint32
+}
+
+func (t *Tunnel) String() string {
+	s := fmt.Sprintf("%s %v", t.Type, t.Net)
+	if t.Transport != (gopacket.Flow{}) {
+		s += fmt.Sprintf(" %v", t.Transport)
+	}
+	if t.ID != 0 {
+		s += fmt.Sprintf(", id: %d", t.ID)
+	}
+	return s
+}
+
+// parseTunnels parse the tunnel types separated by comma. The udp port of
+// vxlan follows the colon, like vxlan:8472, 4789 is always decapsulated.
+func parseTunnels(s string) (*tunnelConfig, error) {
+	tunnels := &tunnelConfig{
+		types:      make(map[string]bool),
+		vxlanPorts: make(map[uint16]bool),
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		name, port, hasPort := item, "", false
+		if i := strings.Index(item, ":"); i >= 0 {
+			name, port, hasPort = item[:i], item[i+1:], true
+		}
+		switch name {
+		case TunnelVXLAN:
+			if hasPort {
+				p, err := strconv.ParseUint(port, 10, 16)
+				if err != nil || p == 0 {
+					return nil, fmt.Errorf("invalid vxlan port %q", port)
+				}
+				if p != vxlanPort {
+					tunnels.vxlanPorts[uint16(p)] = true
+				}
+			}
+			tunnels.types[name] = true
+		case TunnelGRE, TunnelIPIP:
+			if hasPort {
+				return nil, fmt.Errorf("invalid tunnel %q", item)
+			}
+			tunnels.types[name] = true
+		default:
+			return nil, fmt.Errorf("invalid tunnel %q", item)
+		}
+	}
+	return tunnels, nil
+}
+
+// decapsulate returns the innermost packet of the enabled tunnels and the
+// outermost tunnel, the tunnel is nil if the packet isn't decapsulated.
+func decapsulate(packet gopacket.Packet, tunnels *tunnelConfig) (gopacket.Packet, *Tunnel) {
+	var outer *Tunnel
+	for i := 0; i < maxTunnelDepth && tunnels != nil && len(tunnels.types) > 0; i++ {
+		inner, tunnel := decapsulateOnce(packet, tunnels)
+		if inner == nil {
+			break
+		}
+		if outer == nil {
+			outer = tunnel
+		}
+		packet = inner
+	}
+	return packet, outer
+}
+
+// decapsulateOnce returns the packet inside the tunnel right after the
+// first network layer, nil if there isn't one.
+func decapsulateOnce(packet gopacket.Packet, tunnels *tunnelConfig) (gopacket.Packet, *Tunnel) {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return nil, nil
+	}
+	all := packet.Layers()
+	i := 0
+	for i < len(all) && all[i] != network {
+		i++
+	}
+	if i+1 >= len(all) {
+		return nil, nil
+	}
+	tunnel := &Tunnel{Net: network.NetworkFlow()}
+
+	switch next := all[i+1].(type) {
+	case *layers.IPv4, *layers.IPv6:
+		if !tunnels.types[TunnelIPIP] {
+			return nil, nil
+		}
+		tunnel.Type = TunnelIPIP
+		data := append(append([]byte{}, next.LayerContents()...), next.LayerPayload()...)
+		return innerPacket(packet, data, next.LayerType()), tunnel
+	case *layers.GRE:
+		if !tunnels.types[TunnelGRE] {
+			return nil, nil
+		}
+		tunnel.Type = TunnelGRE
+		if next.KeyPresent {
+			tunnel.ID = next.Key
+		}
+		layerType := next.Protocol.LayerType()
+		if layerType != layers.LayerTypeIPv4 &&
+			layerType != layers.LayerTypeIPv6 &&
+			layerType != layers.LayerTypeEthernet {
+			return nil, nil
+		}
+		return innerPacket(packet, next.LayerPayload(), layerType), tunnel
+	case *layers.UDP:
+		if !tunnels.types[TunnelVXLAN] {
+			return nil, nil
+		}
+		tunnel.Type = TunnelVXLAN
+		tunnel.Transport = next.TransportFlow()
+		if tunnels.vxlanPorts[uint16(next.DstPort)] {
+			// gopacket decodes the port 4789 only
+			vni, payload, ok := decodeVXLAN(next.LayerPayload())
+			if !ok {
+				return nil, nil
+			}
+			tunnel.ID = vni
+			return innerPacket(packet, payload, layers.LayerTypeEthernet), tunnel
+		}
+		if i+2 >= len(all) {
+			return nil, nil
+		}
+		vxlan, ok := all[i+2].(*layers.VXLAN)
+		if !ok {
+			return nil, nil
+		}
+		tunnel.ID = vxlan.VNI
+		return innerPacket(packet, vxlan.LayerPayload(), layers.LayerTypeEthernet), tunnel
+	}
+	return nil, nil
+}
+
+// decodeVXLAN returns the VNI and the inner frame of the vxlan packet, ok is
+// false if the valid VNI flag isn't set.
+func decodeVXLAN(data []byte) (vni uint32, payload []byte, ok bool) {
+	if len(data) < vxlanHeaderLen || data[0]&0x08 == 0 {
+		return 0, nil, false
+	}
+	vni = binary.BigEndian.Uint32(data[4:8]) >> 8
+	return vni, data[vxlanHeaderLen:], true
+}
+
+// innerPacket decode the data to a packet begins with the layerType, it has
+// the metadata of the outer packet.
+func innerPacket(outer gopacket.Packet, data []byte, layerType gopacket.LayerType) gopacket.Packet {
+	packet := gopacket.NewPacket(data, layerType, gopacket.Default)
+	metadata := packet.Metadata()
+	*metadata = *outer.Metadata()
+	metadata.CaptureLength = len(data)
+	metadata.Length = len(data)
+	if cut := outer.Metadata().Length - outer.Metadata().CaptureLength; cut > 0 {
+		// the bytes cut by the snaplen are cut from the inner packet too
+		metadata.Length += cut
+	}
+	return packet
+}
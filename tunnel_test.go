@@ -0,0 +1,192 @@
+package fdump
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testOuterSrcIP = net.ParseIP("192.168.0.1").To4()
+	testOuterDstIP = net.ParseIP("192.168.0.2").To4()
+)
+
+// testTunnelPacket encapsulate the inner packet in the tunnel, the inner
+// ethernet header is kept for vxlan only.
+func testTunnelPacket(t *testing.T, tunnel string, inner gopacket.Packet) gopacket.Packet {
+	ts := inner.Metadata().Timestamp
+	data := inner.Data()[14:]
+	switch tunnel {
+	case TunnelVXLAN:
+		eth, ip := testIPv4(testOuterSrcIP, testOuterDstIP, layers.IPProtocolUDP)
+		udp := &layers.UDP{SrcPort: 40000, DstPort: 4789}
+		udp.SetNetworkLayerForChecksum(ip)
+		vxlan := &layers.VXLAN{ValidIDFlag: true, VNI: 42}
+		return testPacket(t, ts, eth, ip, udp, vxlan, gopacket.Payload(inner.Data()))
+	case TunnelGRE:
+		eth, ip := testIPv4(testOuterSrcIP, testOuterDstIP, layers.IPProtocolGRE)
+		gre := &layers.GRE{Protocol: layers.EthernetTypeIPv4, KeyPresent: true, Key: 7}
+		return testPacket(t, ts, eth, ip, gre, gopacket.Payload(data))
+	default:
+		eth, ip := testIPv4(testOuterSrcIP, testOuterDstIP, layers.IPProtocolIPv4)
+		return testPacket(t, ts, eth, ip, gopacket.Payload(data))
+	}
+}
+
+func TestControllerTunnelUDP(t *testing.T) {
+	cases := []struct {
+		tunnel    string
+		id        uint32
+		transport string
+	}{
+		{TunnelVXLAN, 42, "40000->4789"},
+		{TunnelGRE, 7, ""},
+		{TunnelIPIP, 0, ""},
+	}
+	for _, c := range cases {
+		ctrl := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+		tunnels, err := parseTunnels(c.tunnel)
+		assert.NoError(t, err)
+		ctrl.SetTunnels(tunnels)
+		packet := testTunnelPacket(t, c.tunnel, testUDPPacket(t, time.Now(), []byte("0123456789")))
+
+		records := runTestController(t, ctrl, []gopacket.Packet{packet})
+		assert.Len(t, records, 1, c.tunnel)
+		r := records[0]
+		assert.Equal(t, "0123456789", r.Bodies[0], c.tunnel)
+		assert.Equal(t, "127.0.0.1", r.Net.Src().String(), c.tunnel)
+		assert.Equal(t, "20001", r.Transport.Dst().String(), c.tunnel)
+		if assert.NotNil(t, r.Tunnel, c.tunnel) {
+			assert.Equal(t, c.tunnel, r.Tunnel.Type)
+			assert.Equal(t, "192.168.0.1->192.168.0.2", r.Tunnel.Net.String())
+			assert.Equal(t, c.id, r.Tunnel.ID)
+			if c.transport != "" {
+				assert.Equal(t, c.transport, r.Tunnel.Transport.String())
+			} else {
+				assert.Equal(t, gopacket.Flow{}, r.Tunnel.Transport)
+			}
+		}
+	}
+}
+
+func TestControllerTunnelDisabled(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	packet := testTunnelPacket(t, TunnelVXLAN, testUDPPacket(t, time.Now(), []byte("0123456789")))
+
+	// the outer udp datagram is decoded
+	records := runTestController(t, c, []gopacket.Packet{packet})
+	assert.NotEmpty(t, records)
+	assert.Equal(t, "192.168.0.1", records[0].Net.Src().String())
+	assert.Equal(t, "4789", records[0].Transport.Dst().String())
+	assert.Nil(t, records[0].Tunnel)
+}
+
+func TestControllerTunnelTCP(t *testing.T) {
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	tunnels, err := parseTunnels(TunnelIPIP)
+	assert.NoError(t, err)
+	c.SetTunnels(tunnels)
+	c.SetLifecycle(true)
+	ts := time.Now()
+	packets := []gopacket.Packet{
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 100, SYN: true}, nil),
+		testTCPPacket(t, ts, &layers.TCP{SrcPort: 50123, DstPort: 20001, Seq: 101, ACK: true, PSH: true}, []byte("0123456789")),
+	}
+	for i, packet := range packets {
+		packets[i] = testTunnelPacket(t, TunnelIPIP, packet)
+	}
+
+	records := runTestController(t, c, packets)
+	assert.Len(t, records, 3)
+	for _, r := range records {
+		if assert.NotNil(t, r.Tunnel) {
+			assert.Equal(t, TunnelIPIP, r.Tunnel.Type)
+		}
+	}
+	assert.Equal(t, "0123456789", records[1].Bodies[0])
+	assert.Equal(t, "127.0.0.1", records[1].Net.Src().String())
+}
+
+func TestControllerTunnelVXLANPort(t *testing.T) {
+	inner := testUDPPacket(t, time.Now(), []byte("0123456789"))
+	eth, ip := testIPv4(testOuterSrcIP, testOuterDstIP, layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 8472}
+	udp.SetNetworkLayerForChecksum(ip)
+	header := []byte{0x08, 0, 0, 0, 0, 0, 42, 0}
+	packet := testPacket(t, inner.Metadata().Timestamp, eth, ip, udp, gopacket.Payload(append(header, inner.Data()...)))
+
+	c := newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	tunnels, err := parseTunnels("vxlan:8472")
+	assert.NoError(t, err)
+	c.SetTunnels(tunnels)
+	records := runTestController(t, c, []gopacket.Packet{packet})
+	assert.Len(t, records, 1)
+	assert.Equal(t, "0123456789", records[0].Bodies[0])
+	assert.Equal(t, "127.0.0.1", records[0].Net.Src().String())
+	if assert.NotNil(t, records[0].Tunnel) {
+		assert.Equal(t, TunnelVXLAN, records[0].Tunnel.Type)
+		assert.Equal(t, "40000->8472", records[0].Tunnel.Transport.String())
+		assert.Equal(t, uint32(42), records[0].Tunnel.ID)
+	}
+
+	// the port isn't decapsulated without the config
+	c = newController("", "", 65535, "", DecodeFuncFactory(testDecodeFunc))
+	tunnels, err = parseTunnels(TunnelVXLAN)
+	assert.NoError(t, err)
+	c.SetTunnels(tunnels)
+	records = runTestController(t, c, []gopacket.Packet{packet})
+	assert.NotEmpty(t, records)
+	assert.Equal(t, "8472", records[0].Transport.Dst().String())
+	assert.Nil(t, records[0].Tunnel)
+}
+
+func TestDecodeVXLAN(t *testing.T) {
+	vni, payload, ok := decodeVXLAN([]byte{0x08, 0, 0, 0, 0x12, 0x34, 0x56, 0, 1, 2})
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x123456), vni)
+	assert.Equal(t, []byte{1, 2}, payload)
+
+	_, _, ok = decodeVXLAN([]byte{0, 0, 0, 0, 0x12, 0x34, 0x56, 0})
+	assert.False(t, ok)
+	_, _, ok = decodeVXLAN([]byte{0x08, 0, 0, 0})
+	assert.False(t, ok)
+}
+
+func TestParseTunnels(t *testing.T) {
+	tunnels, err := parseTunnels(" vxlan,GRE,, ipip")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{TunnelVXLAN: true, TunnelGRE: true, TunnelIPIP: true}, tunnels.types)
+	assert.Empty(t, tunnels.vxlanPorts)
+
+	tunnels, err = parseTunnels("vxlan:8472,VXLAN:4789")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{TunnelVXLAN: true}, tunnels.types)
+	assert.Equal(t, map[uint16]bool{8472: true}, tunnels.vxlanPorts)
+
+	for _, s := range []string{"vxlan,l2tp", "vxlan:", "vxlan:0", "vxlan:65536", "gre:8472"} {
+		_, err = parseTunnels(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestTunnelSerialization(t *testing.T) {
+	packet := testTunnelPacket(t, TunnelVXLAN, testUDPPacket(t, time.Now(), nil))
+	tunnels, err := parseTunnels(TunnelVXLAN)
+	assert.NoError(t, err)
+	_, tunnel := decapsulate(packet, tunnels)
+	assert.Equal(t, "vxlan 192.168.0.1->192.168.0.2 40000->4789, id: 42", tunnel.String())
+
+	s := tunnel2Serialization(tunnel)
+	restored, err := s.Tunnel()
+	assert.NoError(t, err)
+	assert.Equal(t, tunnel, restored)
+
+	s = tunnel2Serialization(nil)
+	restored, err = s.Tunnel()
+	assert.NoError(t, err)
+	assert.Nil(t, restored)
+}
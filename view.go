@@ -3,6 +3,8 @@ package fdump
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell"
+	"github.com/google/gopacket"
 	runewidth "github.com/mattn/go-runewidth"
 	"github.com/rivo/tview"
 )
@@ -44,13 +47,17 @@ const (
 )
 
 const (
-	selectedColor = tcell.ColorGreen
-	defaultColor  = tcell.ColorDefault
+	selectedColor    = tcell.ColorGreen
+	defaultColor     = tcell.ColorDefault
+	undecodableColor = tcell.ColorRed
+	gapColor         = tcell.ColorYellow
+	connColor        = tcell.ColorDarkCyan
 )
 
 const (
 	mainPageName   = "main"
 	detailPageName = "detail"
+	statsPageName  = "stats"
 )
 
 var seqColumnAttribute = &BriefColumnAttribute{
@@ -58,6 +65,73 @@ var seqColumnAttribute = &BriefColumnAttribute{
 	MaxWidth: 4,
 }
 
+// builtinColumn a brief column provided by fdump, it shows between the seq
+// column and the columns of the BriefFunc.
+type builtinColumn struct {
+	attribute *BriefColumnAttribute
+	value     func(record *Record) string
+}
+
+var latencyColumn = &builtinColumn{
+	attribute: &BriefColumnAttribute{
+		Title:    "Latency",
+		MaxWidth: 10,
+	},
+	value: func(record *Record) string {
+		if record.Latency <= 0 {
+			return ""
+		}
+		return record.Latency.Round(time.Microsecond).String()
+	},
+}
+
+var ifaceColumn = &builtinColumn{
+	attribute: &BriefColumnAttribute{
+		Title:    "Iface",
+		MaxWidth: 8,
+	},
+	value: func(record *Record) string {
+		return record.Interface
+	},
+}
+
+var connColumn = &builtinColumn{
+	attribute: &BriefColumnAttribute{
+		Title:    "Conn",
+		MaxWidth: 8,
+	},
+	value: func(record *Record) string {
+		if record.ConnID == 0 {
+			return ""
+		}
+		switch record.Direction {
+		case DirectionClientToServer:
+			return fmt.Sprintf("%d ->", record.ConnID)
+		case DirectionServerToClient:
+			return fmt.Sprintf("%d <-", record.ConnID)
+		}
+		return fmt.Sprintf("%d", record.ConnID)
+	},
+}
+
+var protocolColumn = &builtinColumn{
+	attribute: &BriefColumnAttribute{
+		Title:    "Proto",
+		MaxWidth: 8,
+	},
+	value: func(record *Record) string {
+		return record.Protocol
+	},
+}
+
+// builtinColumns the built-in columns can be enabled by name
+var builtinColumns = map[string]*builtinColumn{
+	"conn":    connColumn,
+	"proto":   protocolColumn,
+	"iface":   ifaceColumn,
+	"latency": latencyColumn,
+}
+
 // view controller the message to draw
 type view struct {
 	app             *tview.Application
@@ -66,14 +140,20 @@ type view struct {
 	briefView       *tview.Table
 	detailView      *tview.TextView
 	statusView      *tview.TextView
+	statsView       *tview.TextView // the summary of the stats
+	statsPage       *tview.TextView // the full stats
+	statsFunc       func() Stats    // returns the current stats
 	promptView      *tview.TextView
 	detailPage      *tview.TextView // will use this view to show the detail if too narrow
 	capacity        int
 	messages        []*message
 	briefFunc       BriefFunc
 	detailFunc      DetailFunc
-	decodeFunc      DecodeFunc
+	newDecoder      StreamDecoderFactory
+	pairFunc        PairFunc
 	briefAttributes []*BriefColumnAttribute
+	layouts         map[string][]*BriefColumnAttribute // the brief columns of every protocol
+	columns         []*builtinColumn
 	replayHook      ReplayHook
 	briefWidth      int
 
@@ -89,7 +169,7 @@ func newView(
 	capacity int,
 	briefFunc BriefFunc,
 	detailFunc DetailFunc,
-	decodeFunc DecodeFunc,
+	newDecoder StreamDecoderFactory,
 	replayHook *ReplayHook,
 	briefAttributes []*BriefColumnAttribute) *view {
 	v := &view{
@@ -97,7 +177,7 @@ func newView(
 		capacity:        capacity,
 		briefFunc:       briefFunc,
 		detailFunc:      detailFunc,
-		decodeFunc:      decodeFunc,
+		newDecoder:      newDecoder,
 		briefAttributes: briefAttributes,
 		multis:          make(map[int]bool),
 	}
@@ -117,6 +197,22 @@ func newView(
 	return v
 }
 
+// addColumn show a built-in column in the brief view. Call it before Init.
+func (v *view) addColumn(column *builtinColumn) {
+	for _, c := range v.columns {
+		if c == column {
+			return
+		}
+	}
+	v.columns = append(v.columns, column)
+	v.briefWidth += 1 + column.attribute.MaxWidth
+}
+
+// columnCount returns the count of the columns except the seq column
+func (v *view) columnCount() int {
+	return len(v.columns) + len(v.briefAttributes)
+}
+
 func (v *view) makeMessages() {
 	v.messages = make([]*message, v.capacity)
 }
@@ -129,6 +225,7 @@ func (v *view) Init() {
 	v.initBriefView()
 	v.initDetailView()
 	v.initStatusView()
+	v.initStatsView()
 	v.initPrompt()
 	v.initGrid()
 	v.initPages()
@@ -151,8 +248,31 @@ func (v *view) Run() error {
 	return v.app.Run()
 }
 
+// setLayouts show the titles of the protocol of the selected record. Call it
+// before Init.
+func (v *view) setLayouts(layouts map[string][]*BriefColumnAttribute) {
+	v.layouts = layouts
+}
+
 func (v *view) initTitle() {
-	for column, attribute := range append([]*BriefColumnAttribute{seqColumnAttribute}, v.briefAttributes...) {
+	v.drawTitle(v.briefAttributes)
+}
+
+// drawTitle draw the titles of the brief attributes, the columns not in the
+// brief attributes have empty title.
+func (v *view) drawTitle(briefAttributes []*BriefColumnAttribute) {
+	attributes := []*BriefColumnAttribute{seqColumnAttribute}
+	for _, c := range v.columns {
+		attributes = append(attributes, c.attribute)
+	}
+	for i := range v.briefAttributes {
+		if i < len(briefAttributes) {
+			attributes = append(attributes, briefAttributes[i])
+		} else {
+			attributes = append(attributes, &BriefColumnAttribute{MaxWidth: v.briefAttributes[i].MaxWidth})
+		}
+	}
+	for column, attribute := range attributes {
 		cell := tview.NewTableCell(attribute.Title).
 			SetTextColor(tcell.ColorYellow).
 			SetAlign(tview.AlignLeft).
@@ -175,6 +295,9 @@ func (v *view) initBriefView() {
 	v.briefView.SetDoneFunc(func(key tcell.Key) {
 		v.focusBrief()
 	})
+	v.briefView.SetSelectionChangedFunc(func(row, column int) {
+		v.selectionChanged(row)
+	})
 
 	v.briefView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		key := event.Key()
@@ -198,6 +321,9 @@ func (v *view) initBriefView() {
 			case 'L':
 				v.load()
 				return nil
+			case 'E':
+				v.export()
+				return nil
 			case 'M':
 				v.clearMulti()
 				v.toggle(bitMulti)
@@ -216,6 +342,9 @@ func (v *view) initBriefView() {
 				return nil
 			case 'R':
 				v.replay()
+			case 'T':
+				v.showStats()
+				return nil
 			case '?':
 				v.help()
 				return nil
@@ -250,6 +379,9 @@ func (v *view) detailEventHandler(event *tcell.EventKey) *tcell.EventKey {
 			return nil
 		case 's':
 			v.toggle(bitStop)
+		case 'p':
+			v.focusPair()
+			return nil
 		case '?':
 			v.help()
 			return nil
@@ -269,6 +401,14 @@ func (v *view) initStatusView() {
 	v.redrawStatus()
 }
 
+func (v *view) initStatsView() {
+	v.statsView = tview.NewTextView()
+	v.statsView.SetBorder(false)
+	v.statsView.SetWrap(false)
+	v.statsView.SetWordWrap(false)
+	v.statsView.SetTextAlign(tview.AlignLeft)
+}
+
 func (v *view) initPrompt() {
 	v.promptView = tview.NewTextView()
 	v.promptView.SetBorder(false)
@@ -284,9 +424,15 @@ func (v *view) initGrid() {
 	fmt.Fprintf(separation, "|")
 	separation.SetTextColor(tcell.ColorGreen)
 
+	statsSeparation := tview.NewTextView()
+	fmt.Fprintf(statsSeparation, "|")
+	statsSeparation.SetTextColor(tcell.ColorGreen)
+
 	flex := tview.NewFlex()
 	flex.AddItem(v.statusView, 4, 1, false).
 		AddItem(separation, 1, 1, false).
+		AddItem(v.statsView, 0, 1, false).
+		AddItem(statsSeparation, 1, 1, false).
 		AddItem(v.promptView, 0, 1, false)
 
 	v.grid = tview.NewGrid().
@@ -319,7 +465,7 @@ func (v *view) focusDetail(row int) {
 	}
 
 	record := rm.Record
-	detail := v.detailFunc(record)
+	detail := v.detail(record)
 
 	_, _, width, _ := v.grid.GetRect()
 	if width <= 2*v.briefWidth {
@@ -332,6 +478,58 @@ func (v *view) focusDetail(row int) {
 	v.redrawStatus()
 }
 
+// detail returns the capture metadata of the record followed by the detail
+// from the DetailFunc.
+func (v *view) detail(record *Record) string {
+	header := ""
+	if record.Interface != "" {
+		header += fmt.Sprintf("Interface: %s\n", record.Interface)
+	}
+	if record.Tunnel != nil {
+		header += fmt.Sprintf("Tunnel: %v\n", record.Tunnel)
+	}
+	if !record.Seen.IsZero() {
+		header += fmt.Sprintf("Time: %s\n", record.Seen.Format("2006-01-02 15:04:05.000000"))
+	}
+	if record.CaptureLength > 0 {
+		header += fmt.Sprintf("Packet: %d of %d bytes captured", record.CaptureLength, record.Length)
+		if record.Truncated {
+			header += ", truncated"
+		}
+		header += "\n"
+	}
+	if record.Type == RecordTypeTCP && record.SeqEnd != record.Seq {
+		header += fmt.Sprintf("Sequence: %d-%d\n", record.Seq, record.SeqEnd)
+	}
+	if record.ConnID != 0 {
+		header += fmt.Sprintf("Connection: %d %v\n", record.ConnID, record.Direction)
+	}
+	if record.Err != nil {
+		header += fmt.Sprintf("Error: %v\n", record.Err)
+	}
+	if record.Skip > 0 {
+		header += fmt.Sprintf("Gap: %d bytes lost before\n", record.Skip)
+	} else if record.Skip < 0 {
+		header += "Gap: picked up in the middle of the stream\n"
+	}
+	if record.Latency > 0 {
+		header += fmt.Sprintf("Latency: %v\n", record.Latency)
+	}
+	if record.Pair != nil {
+		header += "Pair: press p to jump to the pair\n"
+	}
+	if header != "" {
+		header += "\n"
+	}
+	if record.Err != nil {
+		return header + hex.Dump(record.Buffer)
+	}
+	if record.Type == RecordTypeConn {
+		return header + connEventText(record)
+	}
+	return header + v.detailFunc(record)
+}
+
 func (v *view) focusInDetailView(detail string) {
 	v.detailView.Clear()
 	fmt.Fprintf(v.detailView, detail)
@@ -388,23 +586,51 @@ func (v *view) updateDraw(record *Record) {
 func (v *view) drawMessage(record *Record) {
 	row := atomic.AddInt32(&v.currentRow, 1)
 
+	// the request is drawn before the response is paired, link it here
+	if record.Pair != nil {
+		record.Pair.Pair = record
+	}
+
+	seqColor := tcell.ColorGreen
+	if record.Err != nil {
+		seqColor = undecodableColor
+	} else if record.Skip != 0 {
+		seqColor = gapColor
+	} else if record.Type == RecordTypeConn {
+		seqColor = connColor
+	}
 	cell := tview.NewTableCell(fmt.Sprintf("%X", row)).
-		SetTextColor(tcell.ColorGreen).
+		SetTextColor(seqColor).
 		SetAlign(tview.AlignLeft).
 		SetSelectable(true).
 		SetMaxWidth(seqColumnAttribute.MaxWidth).
 		SetExpansion(1)
 	v.briefView.SetCell(int(row), 0, cell)
 
-	items := v.briefFunc(record)
-	for column, item := range items {
-		cell := tview.NewTableCell(item).
+	for column, c := range v.columns {
+		cell := tview.NewTableCell(c.value(record)).
 			SetTextColor(tcell.ColorWhite).
 			SetAlign(tview.AlignLeft).
 			SetSelectable(true).
-			SetMaxWidth(v.briefAttributes[column].MaxWidth).
+			SetMaxWidth(c.attribute.MaxWidth).
 			SetExpansion(1)
 		v.briefView.SetCell(int(row), column+1, cell)
+	}
+
+	attributes := v.recordAttributes(record)
+	items, color := v.briefItems(record)
+	for column, item := range items {
+		maxWidth := 0
+		if column < len(attributes) {
+			maxWidth = attributes[column].MaxWidth
+		}
+		cell := tview.NewTableCell(item).
+			SetTextColor(color).
+			SetAlign(tview.AlignLeft).
+			SetSelectable(true).
+			SetMaxWidth(maxWidth).
+			SetExpansion(1)
+		v.briefView.SetCell(int(row), len(v.columns)+column+1, cell)
 
 		if !isSet(v.status, bitDetail|bitFrozen) {
 			v.briefView.Select(int(row), 0)
@@ -417,6 +643,49 @@ func (v *view) drawMessage(record *Record) {
 	}
 }
 
+// recordAttributes returns the brief columns of the record protocol
+func (v *view) recordAttributes(record *Record) []*BriefColumnAttribute {
+	if attributes, ok := v.layouts[record.Protocol]; ok {
+		return attributes
+	}
+	return v.briefAttributes
+}
+
+// selectionChanged show the titles of the selected record protocol
+func (v *view) selectionChanged(row int) {
+	if v.layouts == nil {
+		return
+	}
+	m := v.rowMessage(row)
+	if m == nil {
+		return
+	}
+	v.drawTitle(v.recordAttributes(m.Record))
+}
+
+// briefItems returns the items of the brief columns and the text color.
+func (v *view) briefItems(record *Record) ([]string, tcell.Color) {
+	if record.Err != nil {
+		text := fmt.Sprintf("undecodable %d bytes: %v", len(record.Buffer), record.Err)
+		return []string{text}, undecodableColor
+	}
+	if record.Type == RecordTypeConn {
+		return []string{connEventText(record)}, connColor
+	}
+	return v.briefFunc(record), tcell.ColorWhite
+}
+
+// connEventText returns the text of a lifecycle record
+func connEventText(record *Record) string {
+	text := "connection"
+	for _, body := range record.Bodies {
+		if e, ok := body.(*ConnEvent); ok {
+			text += " " + e.String()
+		}
+	}
+	return text
+}
+
 func (v *view) removeHalf() {
 	total := int(v.currentRow)
 	messages := v.messages[total/2:]
@@ -509,17 +778,51 @@ func (v *view) redrawStatus() {
 	fmt.Fprintf(v.statusView, v.statusString())
 }
 
-func (v *view) save() {
-	var messages []*message
-	isMulti := isSet(v.status, bitMulti)
+// UpdateStats show the summary of the stats in the status bar, and the full
+// stats if the stats page is shown.
+func (v *view) UpdateStats(stats Stats) {
+	v.app.QueueUpdateDraw(func() {
+		v.statsView.SetText(stats.Summary())
+		if v.statsPage != nil {
+			v.statsPage.SetText(stats.String())
+		}
+	})
+}
 
-	if isMulti {
-		// multi mode
-		messages = v.selectedMessage()
-	} else {
-		// all
-		messages = v.messages[:int(v.currentRow)]
+// showStats show the full stats, it's refreshed with the status bar.
+func (v *view) showStats() {
+	textView := tview.NewTextView()
+	textView.SetBorder(true)
+	textView.SetTitle(" stats ")
+	textView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		key := event.Key()
+		if key == tcell.KeyEsc || (key == tcell.KeyRune && event.Rune() == 'q') {
+			v.statsPage = nil
+			v.destroyPage(statsPageName)
+			return nil
+		}
+		return event
+	})
+	if v.statsFunc != nil {
+		textView.SetText(v.statsFunc().String())
 	}
+	v.statsPage = textView
+	v.pages.AddPage(statsPageName, nonstandardModal(textView, 60, 36), true, true)
+	v.app.SetFocus(textView)
+}
+
+// chosenMessages returns the selected messages in multi mode, otherwise all
+// the messages.
+func (v *view) chosenMessages() []*message {
+	if isSet(v.status, bitMulti) {
+		return v.selectedMessage()
+	}
+	return v.messages[:int(v.currentRow)]
+}
+
+func (v *view) save() {
+	messages := v.chosenMessages()
+	isMulti := isSet(v.status, bitMulti)
 
 	if v.currentRow == 0 {
 		v.prompt("No message, not need to save.")
@@ -544,16 +847,48 @@ func (v *view) save() {
 	})
 }
 
+func (v *view) export() {
+	messages := v.chosenMessages()
+	isMulti := isSet(v.status, bitMulti)
+
+	if v.currentRow == 0 {
+		v.prompt("No message, not need to export.")
+		return
+	}
+
+	title := ""
+	if isMulti {
+		title = " Export selected to pcap/pcapng "
+	} else {
+		title = " Export all to pcap/pcapng "
+	}
+
+	v.saveOrLoadModal(title, "Export", func(path string) {
+		records := make([]*Record, len(messages))
+		for i, m := range messages {
+			records[i] = m.Record
+		}
+		err := exportPcap(records, path)
+		if err != nil {
+			log.Errorf("export failed, err: %+v", err)
+			v.prompt(fmt.Sprintf("Export to %s failed, %v", path, err))
+		} else {
+			v.prompt(fmt.Sprintf("Export to %s success", path))
+		}
+	})
+}
+
 func (v *view) load() {
 	v.saveOrLoadModal(" Load records ", "Load", v.loadFile)
 }
 
 func (v *view) loadFile(path string) {
-	messages, err := v.deserialize(path)
+	messages, err := deserialize(path, v.newDecoder)
 	if err != nil {
 		log.Errorf("serialize failed, err: %v", err)
 		v.prompt(fmt.Sprintf("Load from %s failed, err: %v", path, err))
 	} else {
+		pairRecords(messages, v.pairFunc)
 		v.redraw(messages)
 		v.redrawStatus()
 		v.prompt(fmt.Sprintf("Load from %s success", path))
@@ -613,7 +948,7 @@ func serialize(messages []*message, filename string) error {
 	return nil
 }
 
-func (v *view) deserialize(filename string) ([]*Record, error) {
+func deserialize(filename string, newDecoder StreamDecoderFactory) ([]*Record, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		log.Errorf("open file err: %v", err)
@@ -637,6 +972,14 @@ func (v *view) deserialize(filename string) ([]*Record, error) {
 		return nil, err
 	}
 
+	// the records of a stream are decoded by the same decoder
+	decoders := make(map[[2]gopacket.Flow]StreamDecoder)
+	defer func() {
+		for _, decoder := range decoders {
+			decoder.Close()
+		}
+	}()
+
 	records := make([]*Record, 0, len(serializations))
 	for _, s := range serializations {
 		net, err := s.Net()
@@ -647,18 +990,60 @@ func (v *view) deserialize(filename string) ([]*Record, error) {
 		if err != nil {
 			continue
 		}
-
-		bodies, _, err := v.decodeFunc(net, transport, s.Buffer)
+		tunnel, err := s.Tunnel.Tunnel()
 		if err != nil {
-			continue
+			log.Debugf("invalid tunnel, err: %v", err)
+		}
+
+		key := [2]gopacket.Flow{net, transport}
+		decoder, ok := decoders[key]
+		if !ok {
+			decoder = newDecoder(net, transport)
+			if setter, ok := decoder.(DirectionSetter); ok {
+				setter.SetDirection(s.ConnID, s.Direction)
+			}
+			decoders[key] = decoder
+		}
+		if handler, ok := decoder.(GapHandler); ok && s.Skip != 0 {
+			handler.Gap(net, transport, s.Skip)
+		}
+		var bodies []interface{}
+		var decodeErr error
+		if s.Type == RecordTypeConn {
+			if s.Event != nil {
+				bodies = []interface{}{s.Event}
+			}
+		} else if s.Err != "" {
+			decodeErr = errors.New(s.Err)
+		} else {
+			bodies, _, decodeErr = decoder.Decode(net, transport, s.Buffer)
+		}
+
+		protocol := decoderProtocol(decoder)
+		if protocol == "" {
+			protocol = s.Protocol
 		}
 
 		record := &Record{
+			Protocol:  protocol,
+			Type:      s.Type,
 			Net:       net,
 			Transport: transport,
 			Seen:      s.Seen,
 			Bodies:    bodies,
 			Buffer:    s.Buffer,
+			Interface: s.Interface,
+			Tunnel:    tunnel,
+			Err:       decodeErr,
+			Skip:      s.Skip,
+			ConnID:    s.ConnID,
+			Direction: s.Direction,
+
+			Length:        s.Length,
+			CaptureLength: s.CaptureLength,
+			Truncated:     s.Truncated,
+			Seq:           s.Seq,
+			SeqEnd:        s.SeqEnd,
 		}
 
 		records = append(records, record)
@@ -755,7 +1140,7 @@ func (v *view) selectedMessage() []*message {
 }
 
 func (v *view) clearMulti() {
-	cellLen := len(v.briefAttributes)
+	cellLen := v.columnCount()
 	for m := range v.multis {
 		for i := 1; i <= cellLen; i++ {
 			v.briefView.GetCell(m, i).SetBackgroundColor(tcell.ColorDefault)
@@ -771,7 +1156,7 @@ func (v *view) destroyPage(name string) {
 }
 
 func (v *view) setRowBackgroundColor(row int, color tcell.Color) {
-	for i := 1; i <= len(v.briefAttributes); i++ {
+	for i := 1; i <= v.columnCount(); i++ {
 		v.briefView.GetCell(row, i).SetBackgroundColor(color)
 	}
 }
@@ -796,13 +1181,17 @@ func (v *view) help() {
 		[3]string{"brief", "C", "clear"},
 		[3]string{"brief", "S", "save selected/all"},
 		[3]string{"brief", "L", "load from file"},
+		[3]string{"brief", "E", "export selected/all to pcap/pcapng"},
 		[3]string{"brief", "M", "toggle multiple select mode"},
 		[3]string{"brief", "m", "select/unselect row, select mode only"},
 		[3]string{"brief", "r", "revert selected, select mode only"},
 		[3]string{"brief", "a", "select/unselect all, select mode only"},
 		[3]string{"brief", "c", "clear selected, select mode only"},
 		[3]string{"brief", "R", "replay current/seleted row"},
+		[3]string{"brief", "T", "show the capture stats"},
 		[3]string{"detail", "q/Esc", "exit detail"},
+		[3]string{"detail", "p", "jump to the paired request/response"},
+		[3]string{"stats", "q/Esc", "exit stats"},
 		[3]string{"help", "q/Esc", "exit help"},
 	}
 
@@ -923,7 +1312,15 @@ func (v *view) replayRecords() []*Record {
 			records = append(records, m.Record)
 		}
 	}
-	return records
+
+	// the lifecycle records have no payload to replay
+	payloads := records[:0]
+	for _, record := range records {
+		if record.Type != RecordTypeConn {
+			payloads = append(payloads, record)
+		}
+	}
+	return payloads
 }
 
 func (v *view) replaySend(network, addr string, records []*Record) error {
@@ -995,8 +1392,27 @@ func (v *view) replaySend(network, addr string, records []*Record) error {
 	return nil
 }
 
+// focusPair show the detail of the pair of the current record
+func (v *view) focusPair() {
+	m := v.currentMessage()
+	if m == nil || m.Record.Pair == nil {
+		v.prompt("No pair")
+		return
+	}
+
+	for i := 0; i < int(v.currentRow); i++ {
+		if v.messages[i] != nil && v.messages[i].Record == m.Record.Pair {
+			row := i + 1
+			v.briefView.Select(row, 0)
+			v.focusDetail(row)
+			return
+		}
+	}
+	v.prompt("The pair has been removed")
+}
+
 func (v *view) rowMessage(row int) *message {
-	if v.currentRow == 0 {
+	if v.currentRow == 0 || row < 1 {
 		return nil
 	}
 
@@ -35,7 +35,7 @@ func TestNewView(t *testing.T) {
 		},
 	}
 
-	v := newView(tapp, capacity, brief, detail, decode, replayHook, briefAttributes)
+	v := newView(tapp, capacity, brief, detail, DecodeFuncFactory(decode), replayHook, briefAttributes)
 	assert.NotNil(t, v)
 	assert.Equal(t, tapp, v.app)
 	assert.Equal(t, capacity, v.capacity)
@@ -51,6 +51,23 @@ func TestNewView(t *testing.T) {
 	assert.Equal(t, maxWidth, v.briefWidth)
 }
 
+func TestViewAddColumn(t *testing.T) {
+	briefAttributes := []*BriefColumnAttribute{
+		&BriefColumnAttribute{
+			Title:    "title0",
+			MaxWidth: 10,
+		},
+	}
+
+	v := newView(tview.NewApplication(), 100, brief, detail, DecodeFuncFactory(decode), nil, briefAttributes)
+	width := v.briefWidth
+	v.addColumn(ifaceColumn)
+	v.addColumn(ifaceColumn)
+	assert.Equal(t, []*builtinColumn{ifaceColumn}, v.columns)
+	assert.Equal(t, width+1+ifaceColumn.attribute.MaxWidth, v.briefWidth)
+	assert.Equal(t, 2, v.columnCount())
+}
+
 func TestBitSet(t *testing.T) {
 	status := uint64(0)
 	bitSet(&status, uint64(1))
@@ -67,3 +84,18 @@ func TestIsSet(t *testing.T) {
 	assert.True(t, isSet(uint64(1), uint64(1)))
 	assert.False(t, isSet(uint64(2), uint64(1)))
 }
+
+func TestViewShowStats(t *testing.T) {
+	v := newView(tview.NewApplication(), 100, brief, detail, DecodeFuncFactory(decode), nil, nil)
+	v.pages = tview.NewPages()
+	v.statsFunc = func() Stats {
+		return Stats{Packets: 7, Pages: 3}
+	}
+
+	v.showStats()
+	if assert.NotNil(t, v.statsPage) {
+		assert.Contains(t, v.statsPage.GetText(false), "packets read:       7\n")
+		assert.Contains(t, v.statsPage.GetText(false), "buffered pages:     3\n")
+	}
+	assert.True(t, v.pages.HasPage(statsPageName))
+}